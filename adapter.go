@@ -0,0 +1,50 @@
+package chef
+
+import (
+	"net/http"
+)
+
+// WrapMiddleware adapts a standard net/http middleware so it can be
+// registered with Use. The request and writer the middleware hands to its
+// next handler are used for the rest of the chain.
+func WrapMiddleware(m func(http.Handler) http.Handler) Handler {
+	return func(ctx Context) {
+		res := ctx.Response()
+		outer := NewResponse(res.Writer)
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.SetRequest(r)
+			prev := res.Writer
+			res.Writer = w
+			defer func() {
+				res.Writer = prev
+			}()
+			ctx.Next()
+		})
+		m(next).ServeHTTP(outer, ctx.Request())
+
+		// the middleware answered by itself
+		if outer.Committed && !res.Committed {
+			res.Status = outer.Status
+			res.Size = outer.Size
+			res.Committed = true
+		}
+	}
+}
+
+// HTTPMiddleware adapts a chef middleware to the standard net/http
+// middleware signature. The handler runs on a standalone context without
+// route params, session or cache.
+func HTTPMiddleware(h Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			maxParam := 0
+			ctx := NewContext(r, w, &maxParam).(*context)
+			ctx.nextIndex = -1
+			ctx.handlers = []Handler{h, func(c Context) {
+				next.ServeHTTP(c.Response(), c.Request())
+			}}
+			ctx.Next()
+		})
+	}
+}
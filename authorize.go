@@ -0,0 +1,137 @@
+package chef
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type (
+	// AuthorizationRule requires one of Roles and all of Permissions to
+	// access the routes matching Method and Path. Path is a route pattern as
+	// registered (i.e.: "/users/:id"), or a prefix ending with "*". An empty
+	// Method matches every method.
+	AuthorizationRule struct {
+		Method      string
+		Path        string
+		Roles       []string
+		Permissions []string
+	}
+
+	// Grants returns the roles and permissions of the user making the request
+	Grants func(ctx Context) (roles, permissions []string)
+
+	// ruleSet holds the authorization rules declared in code. They're kept
+	// apart from the config, which is swapped whole on reload.
+	ruleSet struct {
+		lock  sync.RWMutex
+		rules []AuthorizationRule
+	}
+)
+
+// Require declares the roles needed on a route, alongside the rules from the
+// [Authorization] config section
+func (c *Chef) Require(method, path string, roles ...string) {
+	c.rules.add(AuthorizationRule{
+		Method: method,
+		Path:   path,
+		Roles:  roles,
+	})
+}
+
+// RequirePermissions declares the permissions needed on a route
+func (c *Chef) RequirePermissions(method, path string, permissions ...string) {
+	c.rules.add(AuthorizationRule{
+		Method:      method,
+		Path:        path,
+		Permissions: permissions,
+	})
+}
+
+// Policy returns every authorization rule in effect, for auditing: the ones
+// from config, then the ones declared in code
+func (c *Chef) Policy() []AuthorizationRule {
+	c.rules.lock.RLock()
+	defer c.rules.lock.RUnlock()
+	return append(append([]AuthorizationRule(nil), c.Config().Authorization.Rules...), c.rules.rules...)
+}
+
+// Authorize returns a middleware enforcing the authorization rules. Every
+// rule matching the route must be satisfied. Requests without any grants get
+// a 401, requests lacking some a 403.
+func (c *Chef) Authorize(grants Grants) Handler {
+	return func(ctx Context) {
+		method, path := ctx.Request().Method, ctx.Path()
+		var rules []AuthorizationRule
+		for _, r := range c.Config().Authorization.Rules {
+			if r.matches(method, path) {
+				rules = append(rules, r)
+			}
+		}
+		c.rules.lock.RLock()
+		for _, r := range c.rules.rules {
+			if r.matches(method, path) {
+				rules = append(rules, r)
+			}
+		}
+		c.rules.lock.RUnlock()
+		if len(rules) == 0 {
+			ctx.Next()
+			return
+		}
+
+		roles, permissions := grants(ctx)
+		if len(roles) == 0 && len(permissions) == 0 {
+			ctx.SetStatusCode(http.StatusUnauthorized)
+			ctx.WriteString("unauthorized")
+			return
+		}
+		for _, r := range rules {
+			if !r.allows(roles, permissions) {
+				ctx.SetStatusCode(http.StatusForbidden)
+				ctx.WriteString("forbidden")
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+func (r AuthorizationRule) matches(method, path string) bool {
+	if r.Method != "" && r.Method != "*" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	if strings.HasSuffix(r.Path, "*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(r.Path, "*"))
+	}
+	return r.Path == path
+}
+
+func (r AuthorizationRule) allows(roles, permissions []string) bool {
+	if len(r.Roles) > 0 && !containsAny(roles, r.Roles) {
+		return false
+	}
+	for _, p := range r.Permissions {
+		if !containsAny(permissions, []string{p}) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *ruleSet) add(rule AuthorizationRule) {
+	s.lock.Lock()
+	s.rules = append(s.rules, rule)
+	s.lock.Unlock()
+}
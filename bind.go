@@ -0,0 +1,201 @@
+package chef
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Decoder decodes the body of r into v
+	Decoder func(r *http.Request, v interface{}) error
+)
+
+var (
+	// ErrUnsupportedMediaType is returned by Bind when no decoder is
+	// registered for the request content type
+	ErrUnsupportedMediaType = errors.New("chef: unsupported media type")
+
+	// ErrInvalidBindTarget is returned when binding into something that is
+	// not a pointer to a struct
+	ErrInvalidBindTarget = errors.New("chef: bind target must be a pointer to a struct")
+
+	decoders = struct {
+		sync.RWMutex
+		m map[string]Decoder
+	}{
+		m: map[string]Decoder{
+			MIMEApplicationJSON: decodeJSON,
+			MIMEApplicationXML:  decodeXML,
+			MIMETextXML:         decodeXML,
+			MIMEApplicationForm: decodeForm,
+			MIMEMultipartForm:   decodeMultipart,
+		},
+	}
+)
+
+// RegisterDecoder registers d as the body decoder used by Context.Bind for
+// the mimeType, replacing any existing one
+func RegisterDecoder(mimeType string, d Decoder) {
+	decoders.Lock()
+	decoders.m[strings.ToLower(mimeType)] = d
+	decoders.Unlock()
+}
+
+// LookupDecoder returns the decoder registered for mimeType
+func LookupDecoder(mimeType string) (Decoder, bool) {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	d, ok := decoders.m[strings.ToLower(mimeType)]
+	return d, ok
+}
+
+// bindBody decodes the request body with the decoder registered for its content type
+func bindBody(r *http.Request, v interface{}) error {
+	ct := r.Header.Get(HeaderContentType)
+	if ct == "" {
+		ct = MIMEApplicationJSON
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return ErrUnsupportedMediaType
+	}
+
+	d, ok := LookupDecoder(mediaType)
+	if !ok {
+		return ErrUnsupportedMediaType
+	}
+	return d(r, v)
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+func decodeXML(r *http.Request, v interface{}) error {
+	return xml.NewDecoder(r.Body).Decode(v)
+}
+
+func decodeForm(r *http.Request, v interface{}) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	return bindValues(r.PostForm, v, "form")
+}
+
+func decodeMultipart(r *http.Request, v interface{}) error {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return err
+	}
+	return bindValues(r.MultipartForm.Value, v, "form")
+}
+
+// bindValues sets the fields of the struct pointed to by v from values, using
+// the tag name as key or the field name when the tag is missing
+func bindValues(values url.Values, v interface{}, tag string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidBindTarget
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Tag.Get(tag)
+		if name == "-" {
+			continue
+		}
+		if i := strings.IndexByte(name, ','); i >= 0 {
+			name = name[:i]
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		vals, ok := values[name]
+		if !ok || len(vals) == 0 || (len(vals) == 1 && vals[0] == "") {
+			def, hasDefault := field.Tag.Lookup("default")
+			if !hasDefault {
+				continue
+			}
+			vals = []string{def}
+		}
+		if err := setField(rv.Field(i), vals); err != nil {
+			return errors.New("chef: invalid value for " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, vals []string) error {
+	if f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8 {
+		s := reflect.MakeSlice(f.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if err := setValue(s.Index(i), val); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+	return setValue(f, vals[0])
+}
+
+func setValue(f reflect.Value, val string) error {
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		return setValue(f.Elem(), val)
+	}
+
+	if f.CanAddr() {
+		if u, ok := f.Addr().Interface().(interface{ UnmarshalText([]byte) error }); ok {
+			return u.UnmarshalText([]byte(val))
+		}
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(val, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	default:
+		return errors.New("unsupported field type " + f.Type().String())
+	}
+	return nil
+}
@@ -1,11 +1,14 @@
 package chef
 
 import (
+	stdctx "context"
+	"html/template"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
-	"github.com/BurntSushi/toml"
 	"github.com/gochef/cache"
 	"github.com/gochef/chef/utils"
 	"github.com/gochef/session"
@@ -20,6 +23,81 @@ type (
 			ViewPath string
 			Port     string
 			Env      string
+
+			// SocketMode is the octal permission of the unix socket when Port
+			// is "unix:/path/to.sock". Default value is 0660
+			SocketMode string
+		}
+		Server struct {
+			MaxQueryParams    int
+			MaxQueryLength    int
+			MaxMultipartParts int
+
+			// MaxConns and MaxConnsPerIP cap the concurrent connections at the
+			// listener, in total and per client IP, before requests reach any
+			// middleware. Zero disables a limit. Connections over a limit are
+			// closed, after a 503 response with RejectWith503.
+			MaxConns      int
+			MaxConnsPerIP int
+			RejectWith503 bool
+
+			AutoOptions bool
+			DebugChain  bool
+			H2C         bool
+
+			// Timeouts of the underlying server. ReadHeaderTimeout defaults to
+			// 10s and IdleTimeout to 2m, the others are unbounded
+			ReadTimeout       Duration
+			ReadHeaderTimeout Duration
+			WriteTimeout      Duration
+			IdleTimeout       Duration
+
+			// MaxHeaderBytes bounds the size of request headers. Default value
+			// is 1MB
+			MaxHeaderBytes int
+
+			// ShutdownTimeout is the grace period given to in-flight requests
+			// on SIGINT or SIGTERM. Default value is 30s
+			ShutdownTimeout Duration
+		}
+		TLS struct {
+			CertFile     string
+			KeyFile      string
+			DisableHTTP2 bool
+			ACMECacheDir string
+			ACMEEmail    string
+			RedirectHTTP bool
+
+			// RedirectPort is where HTTP requests are redirected to HTTPS
+			// from. Default value is ":80"
+			RedirectPort string
+
+			ClientCAFile string
+			ClientAuth   string
+
+			// MinVersion is the lowest TLS version accepted, "1.0" to "1.3".
+			// Default value is "1.2"
+			MinVersion string
+
+			// CipherSuites restricts the TLS 1.0-1.2 cipher suites, by Go name.
+			// TLS 1.3 suites are not configurable.
+			CipherSuites []string
+
+			// Curves sets the key exchange preference among X25519, P256,
+			// P384 and P521
+			Curves []string
+		}
+		Proxy struct {
+			// Trusted lists the CIDR ranges and IPs of the proxies whose
+			// forwarded headers are honored by RealIP and Scheme. Default
+			// value is the loopback and private ranges
+			Trusted []string
+
+			// Headers lists the forwarded headers honored, among Forwarded,
+			// X-Forwarded-For, X-Real-IP, X-Forwarded-Proto and
+			// X-Forwarded-Ssl. Default value is X-Forwarded-For, X-Real-IP
+			// and X-Forwarded-Proto
+			Headers []string
 		}
 		Database struct {
 			Driver      string
@@ -30,24 +108,123 @@ type (
 			Dbname      string
 			AutoConnect bool
 		}
+		Workers struct {
+			// Size is the number of goroutines running jobs. Default value is 4
+			Size int
+
+			// Queue is the number of jobs waiting for a worker before Enqueue
+			// fails. Default value is 100
+			Queue int
+
+			// Retries is how many times a failing job is run again
+			Retries int
+
+			// RetryDelay is the delay before the first retry, doubled for each
+			// next one. Default value is 1s
+			RetryDelay Duration
+		}
+		Scheduler struct {
+			// Jitter is the maximum random delay added to each scheduled run
+			Jitter Duration
+		}
+		Dev struct {
+			// WatchInterval is how often in milliseconds files are checked for
+			// changes in development. Default value is 500
+			WatchInterval int
+
+			// Build is the command rebuilding the executable when a .go file
+			// changes, i.e. "go build -o app .". The process then restarts
+			// into the new executable. Empty disables code reloading.
+			Build string
+		}
 		Fileserver struct {
 			Use  bool
 			Path string
 			Dir  string
+
+			// Listing lists the directories without an index.html, which
+			// are 404 otherwise. ListingTemplate is the html/template file
+			// rendering the listing from a DirListing, a plain list of links
+			// when empty.
+			Listing         bool
+			ListingTemplate string
+		}
+		Files struct {
+			ETag         bool
+			CacheControl string
+		}
+		Time struct {
+			Layouts []string
+			Zone    string
+			Format  string
 		}
+		Authorization struct {
+			Rules []AuthorizationRule
+		}
+		Middleware struct {
+			Global []string
+			Groups map[string][]string
+
+			// Disabled lists middlewares to skip, applied on reload
+			Disabled []string
+		}
+		Flags   map[string]bool
 		Cache   *cache.Config
 		Session *session.Config
 		Logger  *utils.LoggerConfig
+
+		// extra holds every section of the config files, for Unmarshal
+		extra map[string]interface{}
+
+		// trustedProxies is Proxy.Trusted parsed by setDefaults
+		trustedProxies []*net.IPNet
+
+		// times is the [Time] section resolved in New and on reload
+		times *timeSettings
 	}
 
 	// Data represents a map to store contextual data
 	Data map[string]interface{}
 
+	// configHolder holds the current config. ReloadConfig replaces it as a
+	// whole, so requests read a consistent one without locking.
+	configHolder struct {
+		atomic.Pointer[Config]
+	}
+
 	// Chef is the framework instance
 	Chef struct {
-		config *Config
+		config *configHolder
 		router *Router
 		logger *utils.Logger
+		flags  *flagSet
+
+		// scope holds the middlewares prepended to routes registered on this
+		// instance, see When
+		scope []Handler
+
+		// lifecycle is shared by the scoped copies of the instance
+		lifecycle *lifecycle
+
+		// configFile is the path the config is loaded from
+		configFile string
+
+		// source is the central store the config is loaded from, and
+		// sourceErr why it was unavailable at startup
+		source    *configSource
+		sourceErr error
+
+		// set by the options of New, see options.go
+		overrides     []func(config *Config)
+		renderer      Renderer
+		routerOptions RouterOptions
+
+		workers   *Workers
+		scheduler *scheduler
+
+		// rules are the authorization rules declared with Require, shared by
+		// the scoped copies of the instance
+		rules *ruleSet
 	}
 )
 
@@ -55,6 +232,7 @@ type (
 const (
 	MIMEApplicationJSON                  = "application/json"
 	MIMEApplicationJSONCharsetUTF8       = MIMEApplicationJSON + "; " + charsetUTF8
+	MIMEApplicationNDJSON                = "application/x-ndjson"
 	MIMEApplicationJavaScript            = "application/javascript"
 	MIMEApplicationJavaScriptCharsetUTF8 = MIMEApplicationJavaScript + "; " + charsetUTF8
 	MIMEApplicationXML                   = "application/xml"
@@ -70,6 +248,7 @@ const (
 	MIMETextPlainCharsetUTF8             = MIMETextPlain + "; " + charsetUTF8
 	MIMEMultipartForm                    = "multipart/form-data"
 	MIMEOctetStream                      = "application/octet-stream"
+	MIMETextEventStream                  = "text/event-stream"
 	MIMEApplicationAjax                  = "xmlhttprequest"
 )
 
@@ -82,19 +261,26 @@ const (
 	HeaderAccept              = "Accept"
 	HeaderAcceptEncoding      = "Accept-Encoding"
 	HeaderAllow               = "Allow"
+	HeaderAcceptPatch         = "Accept-Patch"
+	HeaderAcceptPost          = "Accept-Post"
 	HeaderAuthorization       = "Authorization"
+	HeaderCacheControl        = "Cache-Control"
 	HeaderContentDisposition  = "Content-Disposition"
 	HeaderContentEncoding     = "Content-Encoding"
 	HeaderContentLength       = "Content-Length"
 	HeaderContentType         = "Content-Type"
 	HeaderCookie              = "Cookie"
+	HeaderXDryRun             = "X-Dry-Run"
+	HeaderETag                = "ETag"
 	HeaderSetCookie           = "Set-Cookie"
 	HeaderIfModifiedSince     = "If-Modified-Since"
+	HeaderIfNoneMatch         = "If-None-Match"
 	HeaderLastModified        = "Last-Modified"
 	HeaderLocation            = "Location"
 	HeaderUpgrade             = "Upgrade"
 	HeaderVary                = "Vary"
 	HeaderWWWAuthenticate     = "WWW-Authenticate"
+	HeaderForwarded           = "Forwarded"
 	HeaderXForwardedFor       = "X-Forwarded-For"
 	HeaderXForwardedProto     = "X-Forwarded-Proto"
 	HeaderXForwardedProtocol  = "X-Forwarded-Protocol"
@@ -123,6 +309,8 @@ const (
 	HeaderXXSSProtection          = "X-XSS-Protection"
 	HeaderXFrameOptions           = "X-Frame-Options"
 	HeaderContentSecurityPolicy   = "Content-Security-Policy"
+	HeaderReferrerPolicy          = "Referrer-Policy"
+	HeaderPermissionsPolicy       = "Permissions-Policy"
 	HeaderXCSRFToken              = "X-CSRF-Token"
 )
 
@@ -149,34 +337,119 @@ var (
 )
 
 // New returns an instance of the framework
-func New() *Chef {
-	c := &Chef{}
+func New(options ...Option) *Chef {
+	c := &Chef{configFile: os.Getenv(EnvConfigFile)}
+	if c.configFile == "" {
+		c.configFile = defaultConfigFile()
+	}
+	for _, option := range options {
+		option(c)
+	}
 
 	// load and parse config
 	c.loadConfig()
 
+	return c.init()
+}
+
+// NewWithConfig returns an instance of the framework using config instead of
+// loading a config file
+func NewWithConfig(config *Config, options ...Option) *Chef {
+	c := &Chef{}
+	for _, option := range options {
+		option(c)
+	}
+	c.config = newConfigHolder(config)
+	c.configFile = ""
+
+	return c.init()
+}
+
+// init sets the instance up from its config
+func (c *Chef) init() *Chef {
+	c.applyOverrides(c.Config())
+	setDefaults(c.Config())
+	if err := c.Config().Validate(); err != nil {
+		panic(err.Error())
+	}
+
 	// initialize logger
-	c.config.Logger.Modules = append(defaultLogModules, c.config.Logger.Modules...)
-	c.logger = utils.NewLogger(c.config.Logger)
+	if c.logger == nil {
+		c.logger = utils.NewLogger(c.Config().Logger)
+	}
+	if c.sourceErr != nil {
+		c.logger.GetModuleLogger("chef").Warningf("Using the local config file: %s", c.sourceErr)
+	}
+	// App.Static is only served by the app itself, which may create it later
+	if dir := c.Config().App.Static; dir != "" {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			c.logger.GetModuleLogger("chef").Warningf("App.Static directory %s does not exist", dir)
+		}
+	}
+
+	// resolve time parsing and formatting settings
+	times, err := newTimeSettings(c.Config())
+	if err != nil {
+		panic("chef: Invalid time config: " + err.Error())
+	}
+	c.Config().times = times
+
+	c.lifecycle = &lifecycle{}
+	c.rules = &ruleSet{}
+
+	// background jobs are drained on shutdown, see stop
+	c.workers = newWorkers(c)
+
+	// scheduled tasks run while the server does, see run
+	c.scheduler = newScheduler(c)
+
+	// load feature flags
+	c.flags = &flagSet{}
+	c.loadFlags()
 
 	// start router
-	c.router = NewRouter(c.config)
+	c.router = NewRouter(c.Config())
+	c.router.config = c.config
+	c.router.options = c.routerOptions
+	c.router.renderer = c.renderer
+
+	// log the handler chain of every request in development
+	if c.Config().Server.DebugChain && c.isEnv("development") {
+		c.router.chainLog = c.logger.GetModuleLogger("chef").Infof
+	}
+
+	// enable the middlewares configured by their own section, then the ones
+	// listed in config
+	hs, err := sectionMiddlewares(c.Config())
+	if err != nil {
+		panic(err.Error())
+	}
+	c.Use(hs...)
+	c.Use(configuredMiddlewares(c.Config(), c.Config().Middleware.Global)...)
 
 	// start fileserver
-	if c.config.Fileserver.Use {
+	if c.Config().Fileserver.Use {
 		c.startFileServer()
 	}
 
 	// Start session if configured to do so
-	session.New(c.config.Session)
+	session.New(c.Config().Session)
 
 	return c
 }
 
 func (c *Chef) loadConfig() {
-	if _, err := toml.DecodeFile("config.toml", &c.config); err != nil {
+	config, err := c.readConfig()
+	if err != nil && c.source != nil {
+		if _, statErr := os.Stat(c.configFile); statErr == nil {
+			c.sourceErr = err
+			config, err = LoadConfig(c.configFile)
+		}
+	}
+	if err != nil {
 		panic("chef: Unable to load config: " + err.Error())
 	}
+	c.config = newConfigHolder(config)
 }
 
 // Logger returns a logger instance
@@ -184,14 +457,23 @@ func (c *Chef) Logger() *utils.Logger {
 	return c.logger
 }
 
-// Config returns the application config
+// Config returns the current application config. It is replaced rather than
+// modified by ReloadConfig, so it must be treated as read-only.
 func (c *Chef) Config() *Config {
-	return c.config
+	return c.config.Load()
+}
+
+func newConfigHolder(config *Config) *configHolder {
+	h := &configHolder{}
+	h.Store(config)
+	return h
 }
 
 // Group returns a new routing group
 func (c *Chef) Group(prefix string, cb func(Group)) {
 	group := NewGroup(prefix, c.router)
+	group.middlewares = append(group.middlewares, c.scope...)
+	group.middlewares = append(group.middlewares, configuredMiddlewares(c.Config(), c.Config().Middleware.Groups[prefix])...)
 	cb(group)
 }
 
@@ -207,49 +489,62 @@ func (c *Chef) After(middlewares ...Handler) {
 
 // GET registers a GET route for path with handler
 func (c *Chef) GET(path string, h Handler) {
-	c.router.add("GET", path, h, nil)
+	c.router.add("GET", path, h, c.scope)
 }
 
 // POST registers a POST route for path with handler
 func (c *Chef) POST(path string, h Handler) {
-	c.router.add("POST", path, h, nil)
+	c.router.add("POST", path, h, c.scope)
 }
 
 // PUT registers a PUT route for path with handler
 func (c *Chef) PUT(path string, h Handler) {
-	c.router.add("PUT", path, h, nil)
+	c.router.add("PUT", path, h, c.scope)
 }
 
 // PATCH registers a PATCH route for path with handler
 func (c *Chef) PATCH(path string, h Handler) {
-	c.router.add("PATCH", path, h, nil)
+	c.router.add("PATCH", path, h, c.scope)
 }
 
 // DELETE registers a DELETE route for path with handler
 func (c *Chef) DELETE(path string, h Handler) {
-	c.router.add("DELETE", path, h, nil)
+	c.router.add("DELETE", path, h, c.scope)
 }
 
 // CONNECT registers a CONNECT route for path with handler
 func (c *Chef) CONNECT(path string, h Handler) {
-	c.router.add("CONNECT", path, h, nil)
+	c.router.add("CONNECT", path, h, c.scope)
 }
 
 // TRACE registers a TRACE route for path with handler
 func (c *Chef) TRACE(path string, h Handler) {
-	c.router.add("TRACE", path, h, nil)
+	c.router.add("TRACE", path, h, c.scope)
 }
 
 // OPTIONS registers a OPTIONS route for path with handler
 func (c *Chef) OPTIONS(path string, h Handler) {
-	c.router.add("OPTIONS", path, h, nil)
+	c.router.add("OPTIONS", path, h, c.scope)
+}
+
+// Accepts declares the request content types accepted by the route at path,
+// advertised on OPTIONS requests when Server.AutoOptions is on
+func (c *Chef) Accepts(path string, mimeTypes ...string) {
+	path = routePattern(path)
+	c.router.accepts[path] = append(c.router.accepts[path], mimeTypes...)
+}
+
+// OnOptions registers hooks adding capability headers (i.e.: rate limits) to
+// automatic OPTIONS responses
+func (c *Chef) OnOptions(hooks ...Handler) {
+	c.router.optionsHooks = append(c.router.optionsHooks, hooks...)
 }
 
 // All registers a new route for multiple HTTP methods and path with matching
 // handler in the router with optional route-level middleware.
 func (c *Chef) All(path string, handler Handler) {
 	for _, m := range methods {
-		c.router.add(m, path, handler, nil)
+		c.router.add(m, path, handler, c.scope)
 	}
 }
 
@@ -257,19 +552,31 @@ func (c *Chef) All(path string, handler Handler) {
 // handler in the router with optional route-level middleware.
 func (c *Chef) Some(mthds []string, path string, handler Handler) {
 	for _, m := range mthds {
-		c.router.add(m, path, handler, nil)
+		c.router.add(m, path, handler, c.scope)
 	}
 }
 
 func (c *Chef) startFileServer() {
-	root := c.config.Fileserver.Dir
-	path := c.config.Fileserver.Path
+	root := c.Config().Fileserver.Dir
+	path := c.Config().Fileserver.Path
 
 	workDir, _ := os.Getwd()
 	filesDir := filepath.Join(workDir, root)
 	dir := http.Dir(filesDir)
 
-	fs := http.StripPrefix(path, http.FileServer(dir))
+	var listing *template.Template
+	if c.Config().Fileserver.Listing {
+		listing = defaultListingTemplate
+		if file := c.Config().Fileserver.ListingTemplate; file != "" {
+			t, err := template.ParseFiles(file)
+			if err != nil {
+				panic("chef: invalid listing template: " + err.Error())
+			}
+			listing = t
+		}
+	}
+
+	fs := http.StripPrefix(path, newFileServer(dir, path, listing))
 	if path != "/" && path[len(path)-1] != '/' {
 		c.GET(path, func(c Context) {
 			http.RedirectHandler(path+"/", 301).ServeHTTP(c.Response(), c.Request())
@@ -283,9 +590,45 @@ func (c *Chef) startFileServer() {
 	})
 }
 
-// Run starts HTTP server
-func (c *Chef) Run() {
-	logger := c.logger.GetModuleLogger("chef")
-	logger.Noticef("Running app on port %s", c.config.App.Port)
-	logger.Fatal(http.ListenAndServe(c.config.App.Port, c.router))
+// CloseStreams tells long-lived requests (SSE, WebSocket, long-poll) that the
+// application is going down so they can send their goodbyes, and waits for
+// them to finish until ctx expires. Call it before closing the listeners.
+func (c *Chef) CloseStreams(ctx stdctx.Context) error {
+	c.lifecycle.lock.Lock()
+	mounts := c.lifecycle.mounts
+	c.lifecycle.lock.Unlock()
+
+	// signal every application before waiting on any
+	errs := make(chan error, len(mounts))
+	for _, m := range mounts {
+		go func(m *Chef) {
+			errs <- m.CloseStreams(ctx)
+		}(m)
+	}
+	err := c.router.CloseStreams(ctx)
+	for range mounts {
+		if e := <-errs; err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Run starts HTTP server and blocks until it is shut down. When launched by
+// systemd socket activation or by a restarting parent, the inherited sockets
+// are served instead of App.Port.
+func (c *Chef) Run() error {
+	c.logger.GetModuleLogger("chef").Noticef("Running app on port %s", c.Config().App.Port)
+	lns, err := c.listenRole(listenerApp, c.Config().App.Port)
+	if err != nil {
+		return err
+	}
+	return c.serve(lns...)
+}
+
+// MustRun is like Run but exits through the logger on failure
+func (c *Chef) MustRun() {
+	if err := c.Run(); err != nil {
+		c.logger.GetModuleLogger("chef").Fatal(err)
+	}
 }
@@ -0,0 +1,604 @@
+package chef
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gochef/cache"
+	"github.com/gochef/chef/utils"
+	"github.com/gochef/session"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables overriding config values
+const EnvPrefix = "CHEF_"
+
+type (
+	// ConfigErrors lists every problem found by Config.Validate
+	ConfigErrors []string
+
+	// Duration is a config value holding a duration, written as a string such
+	// as "30s" or "5m". A plain number is a number of seconds.
+	Duration time.Duration
+
+	// ConfigDecoder decodes the content of a config file into config. Values
+	// missing from the file must be left untouched so files can be layered.
+	ConfigDecoder func(data []byte, config *Config) error
+)
+
+var (
+	configDecoders = struct {
+		sync.RWMutex
+		m map[string]ConfigDecoder
+	}{
+		m: map[string]ConfigDecoder{
+			".toml": decodeTOMLConfig,
+			".json": decodeJSONConfig,
+			".yaml": decodeYAMLConfig,
+			".yml":  decodeYAMLConfig,
+		},
+	}
+
+	// ErrNoConfigSection is returned by Config.Unmarshal for a section missing
+	// from the config files
+	ErrNoConfigSection = errors.New("chef: config section not found")
+
+	// defaultConfigFiles are looked up in order when no config file is set
+	defaultConfigFiles = []string{"config.toml", "config.yaml", "config.yml", "config.json"}
+)
+
+// RegisterConfigDecoder registers d as the decoder of config files with the
+// extension ext, i.e. ".hcl", replacing any existing one
+func RegisterConfigDecoder(ext string, d ConfigDecoder) {
+	configDecoders.Lock()
+	configDecoders.m[strings.ToLower(ext)] = d
+	configDecoders.Unlock()
+}
+
+// LoadConfig reads the config file at path with the decoder registered for
+// its extension. The environment file next to it, i.e. config.production.toml
+// for App.Env "production", and then the local file config.local.toml are
+// merged on top when they exist. The environment overrides apply next, then
+// the values referring to secrets are resolved.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if err := decodeConfigFiles(path, config); err != nil {
+		return nil, err
+	}
+	if err := finishConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// decodeConfigFiles decodes the config file at path and its overlays into
+// config
+func decodeConfigFiles(path string, config *Config) error {
+	if err := decodeConfigFile(path, config); err != nil {
+		return err
+	}
+
+	env := config.App.Env
+	if e, ok := os.LookupEnv(EnvPrefix + "APP_ENV"); ok {
+		env = e
+	}
+	for _, overlay := range configOverlays(path, env) {
+		if _, err := os.Stat(overlay); err != nil {
+			continue
+		}
+		if err := decodeConfigFile(overlay, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishConfig applies the environment overrides and resolves the secrets
+// once every config file is decoded
+func finishConfig(config *Config) error {
+	if err := ApplyEnv(config); err != nil {
+		return err
+	}
+	return ResolveSecrets(config)
+}
+
+// configOverlays returns the files merged on top of the config file at path
+// for env, in order
+func configOverlays(path, env string) []string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	if env == "" {
+		return []string{base + ".local" + ext}
+	}
+	return []string{base + "." + strings.ToLower(env) + ext, base + ".local" + ext}
+}
+
+// decodeConfigFile decodes the file at path into config, keeping the values
+// the file doesn't set
+func decodeConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return decodeConfig(data, filepath.Ext(path), path, config)
+}
+
+// decodeConfig decodes data in the format of the extension ext into config,
+// naming it origin in errors
+func decodeConfig(data []byte, ext, origin string, config *Config) error {
+	configDecoders.RLock()
+	d, ok := configDecoders.m[strings.ToLower(ext)]
+	configDecoders.RUnlock()
+	if !ok {
+		return errors.New("chef: unsupported config format " + origin)
+	}
+
+	if err := d(data, config); err != nil {
+		return errors.New("chef: " + origin + ": " + err.Error())
+	}
+	return nil
+}
+
+// UnmarshalText parses a duration string or a number of seconds
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		*d = Duration(n * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.New("invalid duration " + strconv.Quote(s))
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// UnmarshalJSON accepts a duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return d.UnmarshalText(data)
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// UnmarshalTOML accepts a duration string or a number of seconds
+func (d *Duration) UnmarshalTOML(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		return d.UnmarshalText([]byte(v))
+	case int64:
+		*d = Duration(time.Duration(v) * time.Second)
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	default:
+		return errors.New("invalid duration " + fmt.Sprint(v))
+	}
+	return nil
+}
+
+// MarshalText writes the duration as a string such as "1m30s"
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Or returns the duration, or def when it isn't set
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d > 0 {
+		return time.Duration(d)
+	}
+	return def
+}
+
+// Error implements the error interface
+func (e ConfigErrors) Error() string {
+	return "chef: invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate checks the config for mistakes that would otherwise fail later,
+// reporting all of them at once as ConfigErrors
+func (config *Config) Validate() error {
+	var errs ConfigErrors
+	fail := func(field, problem string) {
+		errs = append(errs, field+": "+problem)
+	}
+	dir := func(field, path string) {
+		if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+			fail(field, "directory "+path+" does not exist")
+		}
+	}
+
+	if err := validatePort(config.App.Port); err != nil {
+		fail("App.Port", err.Error())
+	}
+	if m := config.App.SocketMode; m != "" {
+		if _, err := strconv.ParseUint(m, 8, 32); err != nil {
+			fail("App.SocketMode", "invalid octal mode "+m)
+		}
+	}
+	if config.App.ViewPath != "" {
+		dir("App.ViewPath", config.App.ViewPath)
+	}
+	if config.Fileserver.Use {
+		dir("Fileserver.Dir", config.Fileserver.Dir)
+		if file := config.Fileserver.ListingTemplate; file != "" {
+			if _, err := os.Stat(file); err != nil {
+				fail("Fileserver.ListingTemplate", "file "+file+" does not exist")
+			}
+		}
+	}
+
+	if config.Logger != nil && config.Logger.Level != "" && !utils.IsLevel(config.Logger.Level) {
+		fail("Logger.Level", "unknown level "+config.Logger.Level)
+	}
+	if config.Logger != nil && !utils.IsBackend(config.Logger.Backend) {
+		fail("Logger.Backend", "unknown backend "+config.Logger.Backend)
+	}
+	if config.Logger != nil && config.Logger.Formatter != "" && !utils.IsFormatter(config.Logger.Formatter) {
+		fail("Logger.Formatter", "unknown formatter "+config.Logger.Formatter)
+	}
+	if config.Logger != nil {
+		for i, b := range config.Logger.Backends {
+			name := "Logger.Backends[" + strconv.Itoa(i) + "]"
+			if !utils.IsBackend(b.Type) {
+				fail(name+".Type", "unknown backend "+b.Type)
+			}
+			if b.Level != "" && !utils.IsLevel(b.Level) {
+				fail(name+".Level", "unknown level "+b.Level)
+			}
+			if b.Formatter != "" && !utils.IsFormatter(b.Formatter) {
+				fail(name+".Formatter", "unknown formatter "+b.Formatter)
+			}
+			if strings.EqualFold(b.Type, "file") && b.File == "" {
+				fail(name+".File", "required by file backends")
+			}
+		}
+		for module, n := range config.Logger.Sampling {
+			if n < 1 {
+				fail("Logger.Sampling."+module, "must be at least 1")
+			}
+		}
+	}
+
+	if config.Database.AutoConnect {
+		if config.Database.Driver == "" {
+			fail("Database.Driver", "required with AutoConnect")
+		}
+		if config.Database.Host == "" {
+			fail("Database.Host", "required with AutoConnect")
+		}
+		if config.Database.Dbname == "" {
+			fail("Database.Dbname", "required with AutoConnect")
+		}
+	}
+
+	tlsConfig := config.TLS
+	if _, ok := clientAuthTypes[strings.ToLower(tlsConfig.ClientAuth)]; !ok {
+		fail("TLS.ClientAuth", "unknown mode "+tlsConfig.ClientAuth)
+	}
+	if _, ok := tlsVersions[tlsConfig.MinVersion]; !ok {
+		fail("TLS.MinVersion", "unknown version "+tlsConfig.MinVersion)
+	}
+	for _, name := range tlsConfig.CipherSuites {
+		if _, ok := cipherSuite(name); !ok {
+			fail("TLS.CipherSuites", "unknown cipher suite "+name)
+		}
+	}
+	for _, name := range tlsConfig.Curves {
+		if _, ok := tlsCurves[strings.ToLower(name)]; !ok {
+			fail("TLS.Curves", "unknown curve "+name)
+		}
+	}
+	if (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+		fail("TLS.CertFile", "CertFile and KeyFile must be set together")
+	}
+
+	if zone := config.Time.Zone; zone != "" {
+		if _, err := time.LoadLocation(zone); err != nil {
+			fail("Time.Zone", "unknown zone "+zone)
+		}
+	}
+
+	for _, cidr := range config.Proxy.Trusted {
+		if _, ok := parseCIDR(cidr); !ok {
+			fail("Proxy.Trusted", "invalid CIDR range or IP "+cidr)
+		}
+	}
+	for _, header := range config.Proxy.Headers {
+		if !containsFold(proxyHeaders, header) {
+			fail("Proxy.Headers", "unsupported header "+header)
+		}
+	}
+
+	checkDurations(reflect.ValueOf(config).Elem(), "", fail)
+
+	names := append([]string{}, config.Middleware.Global...)
+	for _, group := range config.Middleware.Groups {
+		names = append(names, group...)
+	}
+	for _, name := range names {
+		if _, ok := LookupMiddleware(name); !ok {
+			fail("Middleware", "unknown middleware "+name)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkDurations fails the negative durations of the config section v
+func checkDurations(v reflect.Value, path string, fail func(field, problem string)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if path != "" {
+			name = path + "." + name
+		}
+		switch f := v.Field(i); {
+		case f.Kind() == reflect.Struct:
+			checkDurations(f, name, fail)
+		case f.Type() == reflect.TypeOf(Duration(0)) && f.Int() < 0:
+			fail(name, "negative duration "+Duration(f.Int()).String())
+		}
+	}
+}
+
+// validatePort checks addr is a host:port pair or a unix socket path
+func validatePort(addr string) error {
+	if strings.HasPrefix(addr, "unix:") {
+		if len(addr) == len("unix:") {
+			return errors.New("missing unix socket path")
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.New("invalid address " + addr + `, expected "host:port" or ":port"`)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return errors.New("invalid port " + port)
+	}
+	return nil
+}
+
+// setDefaults fills the sections missing from config. Optional subsystems
+// such as sessions and the cache stay disabled unless turned on.
+func setDefaults(config *Config) {
+	if config.Logger == nil {
+		config.Logger = &utils.LoggerConfig{}
+	}
+	config.Logger.SetDefaults()
+	config.Logger.Modules = withLogModules(config.Logger.Modules)
+	if config.Session == nil {
+		config.Session = &session.Config{}
+	}
+	if config.Cache == nil {
+		config.Cache = &cache.Config{}
+	}
+	if config.App.Port == "" {
+		config.App.Port = ":8080"
+	}
+	if len(config.Proxy.Trusted) > 0 {
+		config.trustedProxies = parseCIDRs(config.Proxy.Trusted)
+	}
+}
+
+// withLogModules returns modules preceded by the default log modules it
+// lacks, so applying it again leaves modules unchanged
+func withLogModules(modules []string) []string {
+	var missing []string
+	for _, m := range defaultLogModules {
+		if !containsFold(modules, m) {
+			missing = append(missing, m)
+		}
+	}
+	return append(missing, modules...)
+}
+
+// defaultConfigFile returns the first of the default config files found, or
+// config.toml
+func defaultConfigFile() string {
+	for _, f := range defaultConfigFiles {
+		if _, err := os.Stat(f); err == nil {
+			return f
+		}
+	}
+	return defaultConfigFiles[0]
+}
+
+// Unmarshal decodes the config section name, i.e. [payments] in config.toml,
+// into v, so applications can keep their own settings next to chef's. Keys
+// match the fields of v case insensitively, or their json tags. Sections are
+// only kept from the built-in TOML, JSON and YAML formats.
+func (config *Config) Unmarshal(name string, v interface{}) error {
+	section := config.section(name)
+	if section == nil {
+		return ErrNoConfigSection
+	}
+
+	b, err := json.Marshal(section)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("chef: config section " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+// hasSection reports whether the config files have the section name
+func (config *Config) hasSection(name string) bool {
+	return config.section(name) != nil
+}
+
+func (config *Config) section(name string) interface{} {
+	for key, value := range config.extra {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return nil
+}
+
+// mergeExtra merges the sections decoded from a config file into the ones of
+// the files before it
+func (config *Config) mergeExtra(sections map[string]interface{}) {
+	if config.extra == nil {
+		config.extra = map[string]interface{}{}
+	}
+	mergeMaps(config.extra, sections)
+}
+
+// mergeMaps merges src into dst recursively, matching keys case
+// insensitively as the config fields do
+func mergeMaps(dst, src map[string]interface{}) {
+	for key, value := range src {
+		for k := range dst {
+			if strings.EqualFold(k, key) {
+				key = k
+				break
+			}
+		}
+		d, dok := dst[key].(map[string]interface{})
+		s, sok := value.(map[string]interface{})
+		if dok && sok {
+			mergeMaps(d, s)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+func decodeTOMLConfig(data []byte, config *Config) error {
+	var sections map[string]interface{}
+	if err := toml.Unmarshal(data, &sections); err != nil {
+		return err
+	}
+	config.mergeExtra(sections)
+	return toml.Unmarshal(data, config)
+}
+
+func decodeJSONConfig(data []byte, config *Config) error {
+	var sections map[string]interface{}
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return err
+	}
+	config.mergeExtra(sections)
+	return json.Unmarshal(data, config)
+}
+
+// decodeYAMLConfig goes through JSON so that keys match field names case
+// insensitively, as with TOML
+func decodeYAMLConfig(data []byte, config *Config) error {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return decodeJSONConfig(b, config)
+}
+
+// ApplyEnv overrides config values with environment variables named after
+// the section and field in upper snake case, i.e. CHEF_APP_PORT for App.Port,
+// CHEF_SERVER_MAX_CONNS_PER_IP for Server.MaxConnsPerIP or
+// CHEF_DATABASE_PASSWORD for Database.Password. Slices take comma separated
+// values. It is applied by LoadConfig after decoding the file.
+func ApplyEnv(config *Config) error {
+	return applyEnv(reflect.ValueOf(config).Elem(), strings.TrimSuffix(EnvPrefix, "_"))
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := prefix + "_" + envName(field.Name)
+		f := v.Field(i)
+
+		switch {
+		case f.Kind() == reflect.Struct:
+			if err := applyEnv(f, name); err != nil {
+				return err
+			}
+			continue
+		case f.Kind() == reflect.Ptr && f.Type().Elem().Kind() == reflect.Struct:
+			if f.IsNil() {
+				if !hasEnvPrefix(name + "_") {
+					continue
+				}
+				f.Set(reflect.New(f.Type().Elem()))
+			}
+			if err := applyEnv(f.Elem(), name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		vals := []string{val}
+		if f.Kind() == reflect.Slice {
+			vals = strings.Split(val, ",")
+			for i := range vals {
+				vals[i] = strings.TrimSpace(vals[i])
+			}
+		}
+		if err := setField(f, vals); err != nil {
+			return errors.New("chef: invalid value for " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// envName converts a Go field name to upper snake case, keeping acronyms
+// together: MaxConnsPerIP is MAX_CONNS_PER_IP
+func envName(name string) string {
+	r := []rune(name)
+	var b strings.Builder
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) && (unicode.IsLower(r[i-1]) ||
+			unicode.IsUpper(r[i-1]) && i+1 < len(r) && unicode.IsLower(r[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(c))
+	}
+	return b.String()
+}
+
+// hasEnvPrefix reports whether an environment variable starts with prefix
+func hasEnvPrefix(prefix string) bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package chef
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		want   []string
+	}{
+		{
+			name:   "defaults",
+			change: func(config *Config) {},
+		},
+		{
+			name:   "missing static dir",
+			change: func(config *Config) { config.App.Static = "does/not/exist" },
+		},
+		{
+			name:   "port without colon",
+			change: func(config *Config) { config.App.Port = "8080" },
+			want:   []string{"App.Port"},
+		},
+		{
+			name:   "unix socket",
+			change: func(config *Config) { config.App.Port = "unix:/tmp/app.sock" },
+		},
+		{
+			name: "every problem at once",
+			change: func(config *Config) {
+				config.App.SocketMode = "999"
+				config.App.ViewPath = "does/not/exist"
+				config.Logger.Level = "LOUD"
+				config.Database.AutoConnect = true
+				config.TLS.CertFile = "cert.pem"
+				config.Time.Zone = "Mars/Olympus"
+				config.Proxy.Trusted = []string{"10.0.0.0/8", "nope"}
+				config.Proxy.Headers = []string{"X-Client-IP"}
+			},
+			want: []string{
+				"App.SocketMode", "App.ViewPath", "Logger.Level",
+				"Database.Driver", "Database.Host", "Database.Dbname",
+				"TLS.CertFile", "Time.Zone", "Proxy.Trusted", "Proxy.Headers",
+			},
+		},
+	}
+	for _, tt := range tests {
+		config := &Config{}
+		config.App.Port = ":8080"
+		setDefaults(config)
+		tt.change(config)
+
+		var fields []string
+		if errs, ok := config.Validate().(ConfigErrors); ok {
+			for _, e := range errs {
+				fields = append(fields, strings.SplitN(e, ":", 2)[0])
+			}
+		}
+		if !reflect.DeepEqual(fields, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, fields, tt.want)
+		}
+	}
+}
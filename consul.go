@@ -0,0 +1,108 @@
+package chef
+
+import (
+	stdctx "context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ConsulSource loads the config from a key of the Consul KV store and watches
+// it with blocking queries
+type ConsulSource struct {
+	// Address of the Consul agent. Default value is "http://127.0.0.1:8500"
+	Address string
+
+	// Key holding the config document, i.e. "apps/shop/config.toml"
+	Key string
+
+	// Token is the ACL token, if any
+	Token string
+
+	// Client makes the requests. Default value is http.DefaultClient
+	Client *http.Client
+
+	lock  sync.Mutex
+	index string
+}
+
+func (s *ConsulSource) Load(ctx stdctx.Context) ([]byte, error) {
+	data, index, err := s.get(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	s.index = index
+	s.lock.Unlock()
+	return data, nil
+}
+
+func (s *ConsulSource) Watch(ctx stdctx.Context) error {
+	s.lock.Lock()
+	index := s.index
+	s.lock.Unlock()
+
+	for {
+		_, next, err := s.get(ctx, index)
+		if err != nil {
+			return err
+		}
+		// the index also moves when a blocking query times out
+		if index != "" && next != index {
+			s.lock.Lock()
+			s.index = next
+			s.lock.Unlock()
+			return nil
+		}
+		index = next
+	}
+}
+
+// get reads the key, blocking until its index passes index when set
+func (s *ConsulSource) get(ctx stdctx.Context, index string) ([]byte, string, error) {
+	addr := s.Address
+	if addr == "" {
+		addr = "http://127.0.0.1:8500"
+	}
+	query := url.Values{"raw": {""}}
+	if index != "" {
+		query.Set("index", index)
+		query.Set("wait", "5m")
+	}
+	u := strings.TrimSuffix(addr, "/") + "/v1/kv/" + strings.TrimPrefix(s.Key, "/") + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.Token != "" {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+	res, err := sourceClient(s.Client).Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, "", errors.New("consul: key " + s.Key + " not found")
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, "", errors.New("consul: " + res.Status)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, res.Header.Get("X-Consul-Index"), nil
+}
+
+func sourceClient(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c
+}
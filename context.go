@@ -1,12 +1,21 @@
 package chef
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
 	"encoding/json"
+	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gochef/cache"
 	"github.com/gochef/session"
@@ -17,40 +26,75 @@ type (
 	Context interface {
 		SetHandlers(h []Handler)
 		GetHandlers() []Handler
-		Response() http.ResponseWriter
+		Response() *Response
 		Request() *http.Request
+		SetRequest(r *http.Request)
+		Copy() Context
+		Body() ([]byte, error)
 		Write(body []byte)
 		WriteString(body string)
 		JSON(data interface{}) error
+		JSONStream(next Iterator) error
+		NDJSON(next Iterator) error
+		ReadNDJSON(fn func(line int, record json.RawMessage) error) ([]RecordError, error)
+		SSE(event, data string) error
+		SSEGoaway(retry time.Duration) error
+		Closing() <-chan struct{}
+		Bind(v interface{}) error
+		BindQuery(v interface{}) error
 		Param(key string) string
 		FormValue(key string) string
 		FormFile(key string) (*multipart.FileHeader, error)
 		QueryString() string
 		QueryParam(key string) string
 		QueryParams() url.Values
+		QueryTime(key string) (time.Time, error)
+		ParamTime(key string) (time.Time, error)
+		FormTime(key string) (time.Time, error)
+		FormatTime(t time.Time) string
 		Set(key string, data interface{})
 		Remove(key string)
 		Get(key string) interface{}
 		GetAll() Data
 		GetInt(key string) int
 		GetString(key string) string
+		Memo(key string, fn func() (interface{}, error)) (interface{}, error)
 		Redirect(location string, code int)
+		Error(err error)
+		Err() error
 		Next()
+		Trace() []TraceEntry
 		IsTLS() bool
+		VerifiedChain() []*x509.Certificate
+		ClientCertificate() *x509.Certificate
 		IsWebSocket() bool
 		IsAjaxRequest() bool
+		IsDryRun() bool
+		Priority() Priority
 		reset(req *http.Request, res http.ResponseWriter, config *Config)
-		File(file string) error
+		File(file string, opts ...FileOption) error
+		Render(name string, data interface{}) error
 		SetStatusCode(code int)
 		SetHeader(header, value string)
 		Host() string
+		RealIP() string
+		Scheme() string
+		Path() string
 		Session() *session.Session
+		Cache() *cache.Cache
+		IsSessionReadOnly() bool
+
+		// SessionSet and SessionDelete write the session, failing with
+		// ErrNoSession or ErrSessionReadOnly
+		SessionSet(key string, value interface{}) error
+		SessionDelete(key string) error
 	}
 
 	context struct {
 		request   *http.Request
-		response  http.ResponseWriter
+		response  *Response
 		data      Data
+		memo      map[string]interface{}
 		path      string
 		pnames    []string
 		pvalues   []string
@@ -60,9 +104,20 @@ type (
 		next      Handler
 		nextIndex int
 		lock      sync.Mutex
-
-		session *session.Session
-		cache   *cache.Cache
+		config    *Config
+		router    *Router
+		node      *node
+		streaming bool
+		tracing   bool
+		trace     []*TraceEntry
+		isCopy    bool
+		body      []byte
+		bodyRead  bool
+		err       error
+
+		session         *session.Session
+		sessionReadOnly bool
+		cache           *cache.Cache
 	}
 )
 
@@ -72,7 +127,7 @@ func NewContext(req *http.Request, res http.ResponseWriter, maxParam *int) Conte
 		pvalues:  make([]string, *maxParam),
 		params:   make(map[string]string),
 		request:  req,
-		response: res,
+		response: NewResponse(res),
 		data:     make(Data),
 	}
 }
@@ -85,7 +140,7 @@ func (c *context) GetHandlers() []Handler {
 	return c.handlers
 }
 
-func (c *context) Response() http.ResponseWriter {
+func (c *context) Response() *Response {
 	return c.response
 }
 
@@ -93,6 +148,66 @@ func (c *context) Request() *http.Request {
 	return c.request
 }
 
+func (c *context) SetRequest(r *http.Request) {
+	c.request = r
+	c.query = nil
+}
+
+func (c *context) Copy() Context {
+	cp := &context{
+		request:         c.request,
+		response:        NewResponse(c.response.Writer),
+		data:            make(Data, len(c.data)),
+		path:            c.path,
+		pnames:          c.pnames,
+		pvalues:         append([]string(nil), c.pvalues...),
+		params:          make(map[string]string, len(c.params)),
+		handlers:        c.handlers,
+		nextIndex:       c.nextIndex,
+		config:          c.config,
+		router:          c.router,
+		isCopy:          true,
+		body:            c.body,
+		bodyRead:        c.bodyRead,
+		session:         c.session,
+		sessionReadOnly: c.sessionReadOnly,
+		cache:           c.cache,
+	}
+	c.lock.Lock()
+	for k, v := range c.data {
+		cp.data[k] = v
+	}
+	c.lock.Unlock()
+	for k, v := range c.params {
+		cp.params[k] = v
+	}
+	return cp
+}
+
+func (c *context) Body() ([]byte, error) {
+	if !c.bodyRead && c.request.Body != nil {
+		body, err := io.ReadAll(c.request.Body)
+		c.request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		c.body = body
+		c.bodyRead = true
+		// the request may outlive the pooled context, which is reset and
+		// reused for other requests
+		c.request.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	// hand out a fresh reader every time so the body can be consumed again
+	// further down the chain
+	if c.bodyRead {
+		c.request.Body = io.NopCloser(bytes.NewReader(c.body))
+	}
+	return c.body, nil
+}
+
 func (c *context) Write(body []byte) {
 	c.response.Write(body)
 }
@@ -111,6 +226,175 @@ func (c *context) JSON(data interface{}) error {
 	return nil
 }
 
+func (c *context) JSONStream(next Iterator) error {
+	c.SetHeader(HeaderContentType, MIMEApplicationJSONCharsetUTF8)
+	w := bufio.NewWriterSize(c.response, 32*1024)
+	enc := json.NewEncoder(w)
+
+	// Once the first byte is out the status can't change anymore, so an
+	// error leaves the array unterminated for the client to notice.
+	w.WriteByte('[')
+	for i := 0; ; i++ {
+		item, ok, err := next()
+		if err != nil {
+			w.Flush()
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if err := enc.Encode(item); err != nil {
+			w.Flush()
+			return err
+		}
+
+		if (i+1)%streamFlushItems == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			c.response.Flush()
+		}
+	}
+	w.WriteByte(']')
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	c.response.Flush()
+	return nil
+}
+
+func (c *context) NDJSON(next Iterator) error {
+	c.SetHeader(HeaderContentType, MIMEApplicationNDJSON)
+	w := bufio.NewWriterSize(c.response, 32*1024)
+	enc := json.NewEncoder(w)
+
+	// the buffer goes out when full, every streamFlushItems records and when
+	// records trickle in slower than streamFlushInterval, so a slow producer
+	// doesn't hold back what is ready and a slow client pushes back on the
+	// producer through the blocking writes
+	lastFlush := time.Now()
+	for i := 1; ; i++ {
+		item, ok, err := next()
+		if err != nil {
+			w.Flush()
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if err := enc.Encode(item); err != nil {
+			w.Flush()
+			return err
+		}
+
+		if i%streamFlushItems == 0 || time.Since(lastFlush) >= streamFlushInterval {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			c.response.Flush()
+			lastFlush = time.Now()
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	c.response.Flush()
+	return nil
+}
+
+func (c *context) ReadNDJSON(fn func(line int, record json.RawMessage) error) ([]RecordError, error) {
+	var errs []RecordError
+	scanner := bufio.NewScanner(c.request.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		if !json.Valid(raw) {
+			errs = append(errs, RecordError{Line: line, Err: "invalid JSON"})
+			continue
+		}
+		if err := fn(line, append(json.RawMessage(nil), raw...)); err != nil {
+			errs = append(errs, RecordError{Line: line, Err: err.Error()})
+		}
+	}
+	return errs, scanner.Err()
+}
+
+func (c *context) SSE(event, data string) error {
+	return c.sse("", event, data)
+}
+
+// SSEGoaway sends the reconnection delay and a "goaway" event carrying it in
+// milliseconds, for streams to send once Closing is signalled
+func (c *context) SSEGoaway(retry time.Duration) error {
+	ms := strconv.FormatInt(retry.Milliseconds(), 10)
+	return c.sse(ms, "goaway", ms)
+}
+
+func (c *context) sse(retry, event, data string) error {
+	if !c.response.Committed {
+		c.SetHeader(HeaderContentType, MIMETextEventStream)
+		c.SetHeader(HeaderCacheControl, CacheControlNoCache)
+	}
+
+	var b strings.Builder
+	if retry != "" {
+		b.WriteString("retry: " + retry + "\n")
+	}
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	if _, err := c.response.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	c.response.Flush()
+	return nil
+}
+
+func (c *context) Closing() <-chan struct{} {
+	if c.router == nil {
+		return nil
+	}
+	if !c.streaming && !c.isCopy {
+		c.streaming = true
+		atomic.AddInt64(&c.router.streams, 1)
+	}
+	return c.router.closing
+}
+
+func (c *context) untrackStream() {
+	if c.streaming {
+		c.streaming = false
+		atomic.AddInt64(&c.router.streams, -1)
+	}
+}
+
+func (c *context) Bind(v interface{}) error {
+	return bindBody(c.request, v)
+}
+
+func (c *context) BindQuery(v interface{}) error {
+	if err := bindValues(c.QueryParams(), v, "query"); err != nil {
+		return err
+	}
+	return Validate(v, "query")
+}
+
 func (c *context) Param(key string) string {
 	return c.params[key]
 }
@@ -139,6 +423,27 @@ func (c *context) QueryParams() url.Values {
 	return c.query
 }
 
+func (c *context) parseTime(value string) (time.Time, error) {
+	s := timesOf(c.config)
+	return parseTime(value, s.layouts, s.location)
+}
+
+func (c *context) QueryTime(key string) (time.Time, error) {
+	return c.parseTime(c.QueryParam(key))
+}
+
+func (c *context) ParamTime(key string) (time.Time, error) {
+	return c.parseTime(c.Param(key))
+}
+
+func (c *context) FormTime(key string) (time.Time, error) {
+	return c.parseTime(c.FormValue(key))
+}
+
+func (c *context) FormatTime(t time.Time) string {
+	return formatTime(t, timesOf(c.config))
+}
+
 func (c *context) Set(key string, data interface{}) {
 	c.lock.Lock()
 	if c.data == nil {
@@ -189,22 +494,58 @@ func (c *context) GetString(key string) string {
 	return ""
 }
 
+func (c *context) Memo(key string, fn func() (interface{}, error)) (interface{}, error) {
+	c.lock.Lock()
+	v, ok := c.memo[key]
+	c.lock.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	// errors aren't memoized so a later call can retry
+	v, err := fn()
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	if c.memo == nil {
+		c.memo = make(map[string]interface{})
+	}
+	c.memo[key] = v
+	c.lock.Unlock()
+	return v, nil
+}
+
 func (c *context) Redirect(location string, code int) {
 	http.Redirect(c.response, c.request, location, code)
 }
 
 func (c *context) reset(req *http.Request, res http.ResponseWriter, config *Config) {
 	c.nextIndex = -1
+	c.config = config
 	c.request = req
-	c.response = res
+	c.response.reset(res)
 	c.path = ""
 	c.pnames = nil
+	for k := range c.params {
+		delete(c.params, k)
+	}
+	c.node = nil
+	c.tracing = false
+	c.trace = nil
 	c.handlers = []Handler{
-		NotFoundHandler,
+		notFound,
 	}
+	c.memo = nil
+	c.body = nil
+	c.bodyRead = false
+	c.err = nil
+	c.session = nil
+	c.sessionReadOnly = false
 
 	if config.Session.Use {
-		c.session = session.GetDriver(config.Session, req, res)
+		c.session = session.GetDriver(config.Session, req, c.response)
 	}
 
 	if config.Cache.Use {
@@ -217,6 +558,10 @@ func (c *context) Next() {
 	lenHandlers := len(c.handlers)
 
 	if (lenHandlers > 0) && (c.nextIndex < lenHandlers) {
+		if c.tracing {
+			c.runTraced()
+			return
+		}
 		c.handlers[c.nextIndex](c)
 	}
 }
@@ -225,6 +570,20 @@ func (c *context) IsTLS() bool {
 	return c.request.TLS != nil
 }
 
+func (c *context) VerifiedChain() []*x509.Certificate {
+	if c.request.TLS == nil || len(c.request.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return c.request.TLS.VerifiedChains[0]
+}
+
+func (c *context) ClientCertificate() *x509.Certificate {
+	if chain := c.VerifiedChain(); len(chain) > 0 {
+		return chain[0]
+	}
+	return nil
+}
+
 func (c *context) IsWebSocket() bool {
 	return false
 }
@@ -233,7 +592,19 @@ func (c *context) IsAjaxRequest() bool {
 	return c.request.Header.Get(HeaderXRequestedWith) == MIMEApplicationAjax
 }
 
-func (c *context) File(file string) error {
+func (c *context) IsDryRun() bool {
+	v, _ := strconv.ParseBool(c.request.Header.Get(HeaderXDryRun))
+	return v
+}
+
+func (c *context) Render(name string, data interface{}) error {
+	if c.router == nil || c.router.renderer == nil {
+		return ErrNoRenderer
+	}
+	return c.router.renderer.RenderContext(c, name, data)
+}
+
+func (c *context) File(file string, opts ...FileOption) error {
 	f, err := os.Open(file)
 	if err != nil {
 		NotFoundHandler(c)
@@ -243,11 +614,39 @@ func (c *context) File(file string) error {
 
 	fi, _ := f.Stat()
 	if !fi.IsDir() {
+		o := newFileOptions(c.config, opts)
+		if o.etag {
+			etag, err := fileETag(file, fi, f)
+			if err != nil {
+				return err
+			}
+			c.SetHeader(HeaderETag, etag)
+		}
+		if o.cacheControl != "" {
+			c.SetHeader(HeaderCacheControl, o.cacheControl)
+		}
 		http.ServeContent(c.response, c.request, fi.Name(), fi.ModTime(), f)
 	}
 	return nil
 }
 
+func (c *context) Priority() Priority {
+	return parsePriority(c.request.Header.Get(HeaderPriority))
+}
+
+func (c *context) Error(err error) {
+	c.err = err
+	if !c.response.Committed {
+		c.response.Header().Set(HeaderContentType, MIMETextPlainCharsetUTF8)
+		c.response.WriteHeader(http.StatusInternalServerError)
+		c.response.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+	}
+}
+
+func (c *context) Err() error {
+	return c.err
+}
+
 func (c *context) SetStatusCode(code int) {
 	c.response.WriteHeader(code)
 }
@@ -260,6 +659,75 @@ func (c *context) Host() string {
 	return c.request.Host
 }
 
+func (c *context) RealIP() string {
+	remote, _, err := net.SplitHostPort(c.request.RemoteAddr)
+	if err != nil {
+		remote = c.request.RemoteAddr
+	}
+	if ip := net.ParseIP(remote); ip == nil || !c.config.trustsProxy(ip) {
+		return remote
+	}
+
+	header := c.request.Header
+	if hops := header.Values(HeaderForwarded); len(hops) > 0 && c.config.honors(HeaderForwarded) {
+		return c.config.forwardedFor(forwardedParams(hops, "for"), remote)
+	}
+	if hops := header.Values(HeaderXForwardedFor); len(hops) > 0 && c.config.honors(HeaderXForwardedFor) {
+		return c.config.forwardedFor(splitHops(hops), remote)
+	}
+	if ip := header.Get(HeaderXRealIP); ip != "" && c.config.honors(HeaderXRealIP) {
+		return ip
+	}
+	return remote
+}
+
+func (c *context) Scheme() string {
+	if c.request.TLS != nil {
+		return "https"
+	}
+	remote, _, err := net.SplitHostPort(c.request.RemoteAddr)
+	if err != nil {
+		remote = c.request.RemoteAddr
+	}
+	if ip := net.ParseIP(remote); ip == nil || !c.config.trustsProxy(ip) {
+		return "http"
+	}
+
+	// the leftmost values come from the client, so the proto is taken from
+	// the hop RealIP resolves to
+	header := c.request.Header
+	if hops := header.Values(HeaderForwarded); len(hops) > 0 && c.config.honors(HeaderForwarded) {
+		if proto := c.config.forwardedProto(hops); proto != "" {
+			return strings.ToLower(proto)
+		}
+	}
+	if protos := splitHops(header.Values(HeaderXForwardedProto)); len(protos) > 0 && c.config.honors(HeaderXForwardedProto) {
+		// proxies appending to X-Forwarded-For may append the proto too,
+		// otherwise the nearest one set it
+		proto := protos[len(protos)-1]
+		if hops := splitHops(header.Values(HeaderXForwardedFor)); len(hops) == len(protos) {
+			proto = protos[c.config.clientHop(hops)]
+		}
+		return strings.ToLower(proto)
+	}
+	if strings.EqualFold(header.Get(HeaderXForwardedSsl), "on") && c.config.honors(HeaderXForwardedSsl) {
+		return "https"
+	}
+	return "http"
+}
+
 func (c *context) Session() *session.Session {
 	return c.session
 }
+
+func (c *context) Cache() *cache.Cache {
+	return c.cache
+}
+
+func (c *context) IsSessionReadOnly() bool {
+	return c.sessionReadOnly
+}
+
+func (c *context) Path() string {
+	return c.path
+}
@@ -0,0 +1,51 @@
+package chef
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2026, 10, 16, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"*/15 * * * *", "2026-10-16 10:45:00"},
+		{"0 9 * * mon-fri", "2026-10-19 09:00:00"},
+		{"@daily", "2026-10-17 00:00:00"},
+		{"@hourly", "2026-10-16 11:00:00"},
+		{"0 0 1 jan *", "2027-01-01 00:00:00"},
+		{"30 10 16 10 *", "2027-10-16 10:30:00"},
+		{"0 12 13 * 5", "2026-10-16 12:00:00"},
+		{"5,10 11 * * 7", "2026-10-18 11:05:00"},
+		{"@every 90s", "2026-10-16 10:31:45"},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+			continue
+		}
+		if got := s.next(base).Format("2006-01-02 15:04:05"); got != tt.want {
+			t.Errorf("%s: next = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"* * *",
+		"60 * * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"0 0 * foo *",
+		"@every x",
+		"@sometimes",
+	}
+	for _, spec := range tests {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package chef
+
+import (
+	"bytes"
+	stdctx "context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EtcdSource loads the config from a key of etcd through its v3 JSON gateway
+// and watches it
+type EtcdSource struct {
+	// Endpoint of the etcd server. Default value is "http://127.0.0.1:2379"
+	Endpoint string
+
+	// Key holding the config document, i.e. "/apps/shop/config.toml"
+	Key string
+
+	// Client makes the requests, i.e. with the client certificate. Default
+	// value is http.DefaultClient
+	Client *http.Client
+
+	lock     sync.Mutex
+	revision int64
+}
+
+type (
+	etcdRange struct {
+		Header struct {
+			Revision int64 `json:"revision,string"`
+		} `json:"header"`
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+
+	etcdWatch struct {
+		Result struct {
+			Canceled bool `json:"canceled"`
+			Events   []struct {
+				Kv struct {
+					ModRevision int64 `json:"mod_revision,string"`
+				} `json:"kv"`
+			} `json:"events"`
+		} `json:"result"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+)
+
+func (s *EtcdSource) Load(ctx stdctx.Context) ([]byte, error) {
+	var r etcdRange
+	if err := s.post(ctx, "/v3/kv/range", map[string]string{"key": s.key()}, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Kvs) == 0 {
+		return nil, errors.New("etcd: key " + s.Key + " not found")
+	}
+	s.lock.Lock()
+	s.revision = r.Header.Revision
+	s.lock.Unlock()
+	return r.Kvs[0].Value, nil
+}
+
+func (s *EtcdSource) Watch(ctx stdctx.Context) error {
+	s.lock.Lock()
+	revision := s.revision
+	s.lock.Unlock()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"create_request": map[string]string{
+			"key":            s.key(),
+			"start_revision": strconv.FormatInt(revision+1, 10),
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url("/v3/watch"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res, err := sourceClient(s.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("etcd: " + res.Status)
+	}
+
+	// the gateway streams one result per line, starting with the creation
+	dec := json.NewDecoder(res.Body)
+	for {
+		var w etcdWatch
+		if err := dec.Decode(&w); err != nil {
+			return err
+		}
+		if w.Error != nil {
+			return errors.New("etcd: " + w.Error.Message)
+		}
+		if w.Result.Canceled {
+			return errors.New("etcd: watch canceled")
+		}
+		if n := len(w.Result.Events); n > 0 {
+			s.lock.Lock()
+			s.revision = w.Result.Events[n-1].Kv.ModRevision
+			s.lock.Unlock()
+			return nil
+		}
+	}
+}
+
+func (s *EtcdSource) key() string {
+	return base64.StdEncoding.EncodeToString([]byte(s.Key))
+}
+
+func (s *EtcdSource) url(path string) string {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:2379"
+	}
+	return strings.TrimSuffix(endpoint, "/") + path
+}
+
+// post sends body as JSON to path and decodes the response into v
+func (s *EtcdSource) post(ctx stdctx.Context, path string, body, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url(path), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderContentType, MIMEApplicationJSON)
+	res, err := sourceClient(s.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("etcd: " + res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,87 @@
+package chef
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderXChefExplain requests a trace of the handler chain in development and
+// carries it back as a trailer
+const HeaderXChefExplain = "X-Chef-Explain"
+
+type (
+	// TraceEntry records the execution of one handler of the chain
+	TraceEntry struct {
+		Index        int
+		Name         string
+		Duration     time.Duration
+		CalledNext   bool
+		ShortCircuit bool
+	}
+)
+
+// String formats the entry as name(duration), flagging short-circuits with "!"
+func (e TraceEntry) String() string {
+	s := e.Name + "(" + e.Duration.String() + ")"
+	if e.ShortCircuit {
+		s += "!"
+	}
+	return s
+}
+
+// handlerName returns the function name of h, without the package path
+func handlerName(h Handler) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(h).Pointer())
+	if fn == nil {
+		return "handler#" + strconv.Itoa(int(reflect.ValueOf(h).Pointer()))
+	}
+	name := fn.Name()
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// explainEnabled checks whether the request asked for a chain trace and the
+// application allows it
+func explainEnabled(c *context) bool {
+	return c.config != nil && strings.EqualFold(c.config.App.Env, "development") &&
+		c.request.Header.Get(HeaderXChefExplain) != ""
+}
+
+// runTraced runs the handler at the current index, recording its execution
+func (c *context) runTraced() {
+	idx := c.nextIndex
+	h := c.handlers[idx]
+	entry := &TraceEntry{
+		Index: idx,
+		Name:  handlerName(h),
+	}
+	c.trace = append(c.trace, entry)
+
+	start := time.Now()
+	h(c)
+	entry.Duration = time.Since(start)
+	entry.CalledNext = c.nextIndex > idx
+	entry.ShortCircuit = !entry.CalledNext && idx < len(c.handlers)-1
+}
+
+func (c *context) Trace() []TraceEntry {
+	out := make([]TraceEntry, 0, len(c.trace))
+	for _, e := range c.trace {
+		out = append(out, *e)
+	}
+	return out
+}
+
+// explainSummary formats the trace for the explain trailer
+func (c *context) explainSummary() string {
+	parts := make([]string, 0, len(c.trace))
+	for _, e := range c.trace {
+		parts = append(parts, e.String())
+	}
+	return strings.Join(parts, ", ")
+}
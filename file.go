@@ -0,0 +1,104 @@
+package chef
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Cache-Control presets for file responses
+const (
+	CacheControlImmutable = "public, max-age=31536000, immutable"
+	CacheControlNoCache   = "no-cache"
+	CacheControlNoStore   = "no-store"
+)
+
+type (
+	// FileOption configures a single Context.File response
+	FileOption func(*fileOptions)
+
+	fileOptions struct {
+		etag         bool
+		cacheControl string
+	}
+
+	fileETagEntry struct {
+		modTime time.Time
+		size    int64
+		etag    string
+	}
+)
+
+var (
+	// fileETags caches content hashes by path, invalidated on mtime or size change
+	fileETags = struct {
+		sync.RWMutex
+		entries map[string]fileETagEntry
+	}{
+		entries: map[string]fileETagEntry{},
+	}
+)
+
+// FileETag enables or disables the strong content-hash ETag for a file response
+func FileETag(enabled bool) FileOption {
+	return func(o *fileOptions) {
+		o.etag = enabled
+	}
+}
+
+// FileCacheControl sets the Cache-Control header of a file response
+func FileCacheControl(value string) FileOption {
+	return func(o *fileOptions) {
+		o.cacheControl = value
+	}
+}
+
+// FileImmutable marks a file response as immutable, for fingerprinted assets
+func FileImmutable() FileOption {
+	return FileCacheControl(CacheControlImmutable)
+}
+
+func newFileOptions(config *Config, opts []FileOption) *fileOptions {
+	o := &fileOptions{}
+	if config != nil {
+		o.etag = config.Files.ETag
+		o.cacheControl = config.Files.CacheControl
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// fileETag returns the strong ETag of f, hashing its content only when the
+// file changed since it was last seen
+func fileETag(name string, fi os.FileInfo, f io.ReadSeeker) (string, error) {
+	fileETags.RLock()
+	e, ok := fileETags.entries[name]
+	fileETags.RUnlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.etag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+	fileETags.Lock()
+	fileETags.entries[name] = fileETagEntry{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+		etag:    etag,
+	}
+	fileETags.Unlock()
+
+	return etag, nil
+}
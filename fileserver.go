@@ -0,0 +1,135 @@
+package chef
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// DirListing is the data of the directory listing template, see
+	// Fileserver.Listing
+	DirListing struct {
+		// Path is the URL path of the directory, ending with a slash
+		Path    string
+		Entries []DirEntry
+	}
+
+	// DirEntry is a file or directory of a DirListing
+	DirEntry struct {
+		Name    string
+		URL     string
+		Dir     bool
+		Size    int64
+		ModTime time.Time
+	}
+
+	// fileServer serves the files of root, answering directories without an
+	// index.html with listing, or 404 when it's nil
+	fileServer struct {
+		root    http.FileSystem
+		files   http.Handler
+		prefix  string
+		listing *template.Template
+	}
+)
+
+var defaultListingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{- if ne .Path "/"}}
+<li><a href="../">../</a></li>
+{{- end}}
+{{- range .Entries}}
+<li><a href="{{.URL}}">{{.Name}}{{if .Dir}}/{{end}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// newFileServer returns the handler of root mounted at prefix
+func newFileServer(root http.FileSystem, prefix string, listing *template.Template) http.Handler {
+	return &fileServer{
+		root:    root,
+		files:   http.FileServer(root),
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		listing: listing,
+	}
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	f, err := s.root.Open(name)
+	if err != nil {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.IsDir() || s.hasIndex(name) {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+
+	if s.listing == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := path.Base(r.URL.Path) + "/"
+		if q := r.URL.RawQuery; q != "" {
+			target += "?" + q
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	data := DirListing{Path: s.prefix + strings.TrimSuffix(name, "/") + "/"}
+	for _, fi := range infos {
+		e := DirEntry{
+			Name:    fi.Name(),
+			URL:     (&url.URL{Path: fi.Name()}).String(),
+			Dir:     fi.IsDir(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		}
+		if e.Dir {
+			e.URL += "/"
+		}
+		data.Entries = append(data.Entries, e)
+	}
+
+	var buf bytes.Buffer
+	if err := s.listing.Execute(&buf, data); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(HeaderContentType, "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// hasIndex reports whether the directory dir has an index.html, which the
+// file server serves instead of a listing
+func (s *fileServer) hasIndex(dir string) bool {
+	f, err := s.root.Open(path.Join(dir, "index.html"))
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
@@ -0,0 +1,96 @@
+package chef
+
+import (
+	"strings"
+	"sync"
+)
+
+type (
+	// Condition decides whether routes registered through Chef.When are active
+	Condition struct {
+		envs  []string
+		flags []string
+	}
+
+	flagSet struct {
+		sync.RWMutex
+		values map[string]bool
+	}
+)
+
+// Env returns a condition that holds when App.Env is one of envs. It is
+// evaluated once, when the routes are registered.
+func Env(envs ...string) Condition {
+	return Condition{envs: envs}
+}
+
+// FeatureFlag returns a condition that holds while the named flag is enabled.
+// It is evaluated on every request so flipping the flag takes effect without
+// a restart.
+func FeatureFlag(name string) Condition {
+	return Condition{flags: []string{name}}
+}
+
+// And returns a condition that additionally requires the named flag, all the
+// flags of the condition must be enabled
+func (cond Condition) And(flag string) Condition {
+	cond.flags = append(cond.flags[:len(cond.flags):len(cond.flags)], flag)
+	return cond
+}
+
+// When registers the routes declared in cb only if cond holds
+func (c *Chef) When(cond Condition, cb func(*Chef)) {
+	if len(cond.envs) > 0 && !c.isEnv(cond.envs...) {
+		return
+	}
+
+	if len(cond.flags) == 0 {
+		cb(c)
+		return
+	}
+
+	flags := cond.flags
+	scoped := *c
+	scoped.scope = append(append([]Handler{}, c.scope...), func(ctx Context) {
+		for _, name := range flags {
+			if !c.FlagEnabled(name) {
+				NotFoundHandler(ctx)
+				return
+			}
+		}
+		ctx.Next()
+	})
+	cb(&scoped)
+}
+
+// SetFlag enables or disables a feature flag at runtime
+func (c *Chef) SetFlag(name string, enabled bool) {
+	c.flags.Lock()
+	c.flags.values[name] = enabled
+	c.flags.Unlock()
+}
+
+// FlagEnabled reports whether the named feature flag is enabled
+func (c *Chef) FlagEnabled(name string) bool {
+	c.flags.RLock()
+	defer c.flags.RUnlock()
+	return c.flags.values[name]
+}
+
+func (c *Chef) isEnv(envs ...string) bool {
+	for _, env := range envs {
+		if strings.EqualFold(env, c.Config().App.Env) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Chef) loadFlags() {
+	c.flags.Lock()
+	c.flags.values = make(map[string]bool, len(c.Config().Flags))
+	for name, enabled := range c.Config().Flags {
+		c.flags.values[name] = enabled
+	}
+	c.flags.Unlock()
+}
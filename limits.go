@@ -0,0 +1,81 @@
+package chef
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+type (
+	// multipartLimitReader fails the body read once more than max parts went by
+	multipartLimitReader struct {
+		io.ReadCloser
+		delim []byte
+		tail  []byte
+		seen  int
+		max   int
+	}
+)
+
+var (
+	// ErrTooManyParts is returned when reading a multipart body with more parts than allowed
+	ErrTooManyParts = errors.New("chef: multipart body has too many parts")
+)
+
+// checkLimits enforces the [Server] request limits and writes the error
+// response when one is exceeded. It returns false if the request was rejected.
+func (r *Router) checkLimits(req *http.Request, res http.ResponseWriter) bool {
+	config := r.config.Load()
+	if config == nil {
+		return true
+	}
+	cfg := config.Server
+
+	query := req.URL.RawQuery
+	if cfg.MaxQueryLength > 0 && len(query) > cfg.MaxQueryLength {
+		http.Error(res, "query string too long", http.StatusRequestURITooLong)
+		return false
+	}
+	if cfg.MaxQueryParams > 0 && query != "" && strings.Count(query, "&")+1 > cfg.MaxQueryParams {
+		http.Error(res, "too many query parameters", http.StatusBadRequest)
+		return false
+	}
+
+	if cfg.MaxMultipartParts > 0 && req.Body != nil {
+		mediaType, params, err := mime.ParseMediaType(req.Header.Get(HeaderContentType))
+		if err == nil && strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+			req.Body = &multipartLimitReader{
+				ReadCloser: req.Body,
+				delim:      []byte("--" + params["boundary"]),
+				max:        cfg.MaxMultipartParts,
+			}
+		}
+	}
+
+	return true
+}
+
+func (m *multipartLimitReader) Read(p []byte) (int, error) {
+	n, err := m.ReadCloser.Read(p)
+	if n > 0 {
+		// keep the end of the previous chunk around so delimiters split
+		// between two reads are still counted
+		buf := append(m.tail, p[:n]...)
+		m.seen += bytes.Count(buf, m.delim)
+
+		keep := len(m.delim) - 1
+		if len(buf) < keep {
+			keep = len(buf)
+		}
+		m.tail = append(m.tail[:0], buf[len(buf)-keep:]...)
+
+		// n parts are framed by n+1 delimiters
+		if m.seen > m.max+1 {
+			return 0, ErrTooManyParts
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,139 @@
+package chef
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type (
+	// limitListener caps the number of concurrent connections, in total and
+	// per client IP
+	limitListener struct {
+		net.Listener
+		max     int
+		perIP   int
+		respond bool
+
+		lock  sync.Mutex
+		total int
+		ips   map[string]int
+
+		// rejecting bounds the 503 responses being written, past it
+		// connections are closed right away
+		rejecting chan struct{}
+	}
+
+	limitConn struct {
+		net.Conn
+		once    sync.Once
+		release func()
+	}
+)
+
+// maxRejecting is the number of 503 responses written at once
+const maxRejecting = 64
+
+var (
+	connRejectResponse = []byte("HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\nContent-Length: 20\r\n\r\ntoo many connections")
+)
+
+// LimitListener wraps l so that it holds at most max connections in total and
+// perIP connections per client IP. Zero disables a limit. Connections over
+// the limit are closed, after a 503 response if respond is true.
+func LimitListener(l net.Listener, max, perIP int, respond bool) net.Listener {
+	if max <= 0 && perIP <= 0 {
+		return l
+	}
+	return &limitListener{
+		Listener:  l,
+		max:       max,
+		perIP:     perIP,
+		respond:   respond,
+		ips:       map[string]int{},
+		rejecting: make(chan struct{}, maxRejecting),
+	}
+}
+
+// Accept waits for the next connection within the limits
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := connIP(conn)
+		if !l.acquire(ip) {
+			l.reject(conn)
+			continue
+		}
+
+		return &limitConn{
+			Conn: conn,
+			release: func() {
+				l.releaseIP(ip)
+			},
+		}, nil
+	}
+}
+
+func (l *limitListener) acquire(ip string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.max > 0 && l.total >= l.max {
+		return false
+	}
+	if l.perIP > 0 && l.ips[ip] >= l.perIP {
+		return false
+	}
+	l.total++
+	l.ips[ip]++
+	return true
+}
+
+func (l *limitListener) releaseIP(ip string) {
+	l.lock.Lock()
+	l.total--
+	if l.ips[ip]--; l.ips[ip] <= 0 {
+		delete(l.ips, ip)
+	}
+	l.lock.Unlock()
+}
+
+// reject closes conn, writing the 503 in the background so a slow client
+// doesn't hold up Accept
+func (l *limitListener) reject(conn net.Conn) {
+	if !l.respond {
+		conn.Close()
+		return
+	}
+	select {
+	case l.rejecting <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+	go func() {
+		defer func() { <-l.rejecting }()
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		conn.Write(connRejectResponse)
+		conn.Close()
+	}()
+}
+
+// Close closes the connection and frees its slot
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+func connIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+// Access log formats
+const (
+	AccessLogText = "text"
+	AccessLogJSON = "json"
+)
+
+type (
+	// AccessLogOptions is the configuration used to setup the access log middleware
+	AccessLogOptions struct {
+		// Format is either AccessLogText or AccessLogJSON. Default value is AccessLogText
+		Format string
+
+		// Formatter overrides Format with a custom line layout. The returned
+		// bytes are written as is, a trailing newline is not added.
+		Formatter func(entry AccessLogEntry) []byte
+
+		// Output is where log lines are written. Default value is os.Stdout
+		Output io.Writer
+	}
+
+	// AccessLogEntry holds what is recorded for a single request
+	AccessLogEntry struct {
+		Time      time.Time     `json:"time"`
+		Method    string        `json:"method"`
+		Route     string        `json:"route"`
+		URI       string        `json:"uri"`
+		Status    int           `json:"status"`
+		Latency   time.Duration `json:"latency"`
+		Bytes     int64         `json:"bytes"`
+		ClientIP  string        `json:"client_ip"`
+		RequestID string        `json:"request_id,omitempty"`
+	}
+
+	// AccessLogger represents the middleware instance
+	AccessLogger struct {
+		format    func(entry AccessLogEntry) []byte
+		output    io.Writer
+		outputMux sync.Mutex
+	}
+)
+
+// NewAccessLogger creates a new access log middleware instance with provided options
+func NewAccessLogger(options AccessLogOptions) *AccessLogger {
+	l := &AccessLogger{
+		format: options.Formatter,
+		output: options.Output,
+	}
+	if l.output == nil {
+		l.output = os.Stdout
+	}
+	if l.format == nil {
+		if options.Format == AccessLogJSON {
+			l.format = formatAccessLogJSON
+		} else {
+			l.format = formatAccessLogText
+		}
+	}
+
+	return l
+}
+
+// AccessLog returns an access log middleware writing text lines to stdout
+func AccessLog() chef.Handler {
+	return NewAccessLogger(AccessLogOptions{}).Handler
+}
+
+// Handler logs the request once the rest of the chain has run
+func (l *AccessLogger) Handler(ctx chef.Context) {
+	start := time.Now()
+	ctx.Next()
+
+	req := ctx.Request()
+	res := ctx.Response()
+	requestID := res.Header().Get(chef.HeaderXRequestID)
+	if requestID == "" {
+		requestID = req.Header.Get(chef.HeaderXRequestID)
+	}
+
+	line := l.format(AccessLogEntry{
+		Time:      start,
+		Method:    req.Method,
+		Route:     ctx.Path(),
+		URI:       req.RequestURI,
+		Status:    res.Status,
+		Latency:   time.Since(start),
+		Bytes:     res.Size,
+		ClientIP:  ctx.RealIP(),
+		RequestID: requestID,
+	})
+
+	l.outputMux.Lock()
+	l.output.Write(line)
+	l.outputMux.Unlock()
+}
+
+func formatAccessLogText(e AccessLogEntry) []byte {
+	requestID := e.RequestID
+	if requestID == "" {
+		requestID = "-"
+	}
+	return []byte(fmt.Sprintf("%s %s %s %s %d %d %s %s %q\n",
+		e.Time.Format(time.RFC3339), e.ClientIP, requestID, e.Method, e.Status,
+		e.Bytes, e.Latency, e.Route, e.URI))
+}
+
+func formatAccessLogJSON(e AccessLogEntry) []byte {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil
+	}
+	return append(b, '\n')
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// AuthenticatedOptions is the configuration used to setup the login required middleware
+	AuthenticatedOptions struct {
+		// SessionKey is the session key set once the user logged in.
+		// Default value is "user_id"
+		SessionKey string
+
+		// LoginURL is where browsers are redirected to. Default value is "/login"
+		LoginURL string
+
+		// RedirectKey is the session key the requested URL is stored under for
+		// the redirect after login. Default value is "redirect_to"
+		RedirectKey string
+
+		// ContextKey is the key the session value is stored under in the
+		// context. Default value is "user_id"
+		ContextKey string
+
+		// IsAPI decides whether the client gets a 401 JSON answer instead of a
+		// redirect. Default treats AJAX requests and clients not accepting
+		// HTML as API clients.
+		IsAPI func(ctx chef.Context) bool
+	}
+)
+
+const defaultRedirectKey = "redirect_to"
+
+// Authenticated returns a middleware letting through requests whose session
+// holds options.SessionKey. Browsers are redirected to the login page, API
+// clients get a 401 JSON error.
+func Authenticated(options AuthenticatedOptions) chef.Handler {
+	if options.SessionKey == "" {
+		options.SessionKey = "user_id"
+	}
+	if options.LoginURL == "" {
+		options.LoginURL = "/login"
+	}
+	if options.RedirectKey == "" {
+		options.RedirectKey = defaultRedirectKey
+	}
+	if options.ContextKey == "" {
+		options.ContextKey = options.SessionKey
+	}
+	if options.IsAPI == nil {
+		options.IsAPI = func(ctx chef.Context) bool {
+			accept := ctx.Request().Header.Get(chef.HeaderAccept)
+			return ctx.IsAjaxRequest() || !strings.Contains(accept, chef.MIMETextHTML)
+		}
+	}
+
+	return func(ctx chef.Context) {
+		sess := ctx.Session()
+		if sess == nil {
+			ctx.Error(chef.ErrNoSession)
+			return
+		}
+
+		if user := sess.Get(options.SessionKey); user != nil {
+			ctx.Set(options.ContextKey, user)
+			ctx.Next()
+			return
+		}
+
+		if options.IsAPI(ctx) {
+			ctx.SetHeader(chef.HeaderContentType, chef.MIMEApplicationJSONCharsetUTF8)
+			ctx.SetStatusCode(http.StatusUnauthorized)
+			ctx.JSON(map[string]string{"error": "authentication required"})
+			return
+		}
+
+		req := ctx.Request()
+		if req.Method == chef.GET {
+			// a read-only session can't remember where to go back to
+			ctx.SessionSet(options.RedirectKey, req.URL.RequestURI())
+		}
+		ctx.Redirect(options.LoginURL, http.StatusFound)
+	}
+}
+
+// IntendedURL returns and forgets the URL stored by Authenticated under key
+// ("" for the default one), or fallback. Only local paths are returned so the
+// value can't be abused as an open redirect.
+func IntendedURL(ctx chef.Context, key, fallback string) string {
+	if key == "" {
+		key = defaultRedirectKey
+	}
+	sess := ctx.Session()
+	if sess == nil {
+		return fallback
+	}
+
+	target, _ := sess.Get(key).(string)
+	ctx.SessionDelete(key)
+	if !isLocalPath(target) {
+		return fallback
+	}
+	return target
+}
+
+// isLocalPath reports whether target is a path on this host. Browsers read
+// "\" as "/" and drop tabs and newlines, so "/\evil.com" or "/<tab>/evil.com"
+// would leave the site like "//evil.com" does.
+func isLocalPath(target string) bool {
+	if target == "" || target[0] != '/' || strings.ContainsRune(target, '\\') {
+		return false
+	}
+	if len(target) > 1 && target[1] == '/' {
+		return false
+	}
+	for i := 0; i < len(target); i++ {
+		if target[i] < 0x20 || target[i] == 0x7f {
+			return false
+		}
+	}
+	u, err := url.Parse(target)
+	return err == nil && !u.IsAbs() && u.Host == ""
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import "testing"
+
+func TestIsLocalPath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/", true},
+		{"/orders/42?tab=items", true},
+		{"/search?q=a//b", true},
+		{"", false},
+		{"orders", false},
+		{"//x", false},
+		{"/\\x", false},
+		{"/orders\\..\\x", false},
+		{"\\\\x", false},
+		{"https://x", false},
+		{"javascript:alert(1)", false},
+		{"/\t/x", false},
+		{"/\n/x", false},
+	}
+	for _, tt := range tests {
+		if got := isLocalPath(tt.target); got != tt.want {
+			t.Errorf("isLocalPath(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// BodyDumpHandler receives the request and response bodies of a request
+	BodyDumpHandler func(ctx chef.Context, reqBody, resBody []byte)
+
+	// BodyDumpOptions is the configuration used to setup the body dump middleware
+	BodyDumpOptions struct {
+		// Handler is called with the captured bodies once the chain has run
+		Handler BodyDumpHandler
+
+		// Limit caps the number of bytes captured from each body. Default value is 64KB
+		Limit int
+	}
+
+	// dumpWriter copies what is written to the response, up to limit bytes
+	dumpWriter struct {
+		http.ResponseWriter
+		buf   bytes.Buffer
+		limit int
+	}
+)
+
+// BodyDump returns a middleware passing request and response bodies to fn
+func BodyDump(fn BodyDumpHandler) chef.Handler {
+	return BodyDumpWithOptions(BodyDumpOptions{Handler: fn})
+}
+
+// BodyDumpWithOptions returns a body dump middleware with provided options
+func BodyDumpWithOptions(options BodyDumpOptions) chef.Handler {
+	if options.Handler == nil {
+		panic("chef: body dump middleware requires a handler")
+	}
+	if options.Limit <= 0 {
+		options.Limit = 64 * 1024
+	}
+
+	return func(ctx chef.Context) {
+		reqBody, _ := ctx.Body()
+		if len(reqBody) > options.Limit {
+			reqBody = reqBody[:options.Limit]
+		}
+
+		res := ctx.Response()
+		w := &dumpWriter{
+			ResponseWriter: res.Writer,
+			limit:          options.Limit,
+		}
+		res.Writer = w
+		defer func() {
+			res.Writer = w.ResponseWriter
+		}()
+
+		ctx.Next()
+
+		options.Handler(ctx, reqBody, w.buf.Bytes())
+	}
+}
+
+func (w *dumpWriter) Write(b []byte) (int, error) {
+	if room := w.limit - w.buf.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		w.buf.Write(b[:room])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *dumpWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *dumpWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("chef: response does not implement http.Hijacker")
+	}
+	return h.Hijack()
+}
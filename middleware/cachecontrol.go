@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// CacheDirectives describes a Cache-Control policy
+	CacheDirectives struct {
+		// Public lets shared caches store responses to authenticated requests
+		Public bool
+
+		// Private restricts storage to the browser cache
+		Private bool
+
+		// NoCache requires revalidation before each reuse
+		NoCache bool
+
+		// NoStore forbids storing the response at all
+		NoStore bool
+
+		// MaxAge is how long browsers may reuse the response
+		MaxAge time.Duration
+
+		// SMaxAge overrides MaxAge for shared caches such as CDNs
+		SMaxAge time.Duration
+
+		// StaleWhileRevalidate lets caches serve a stale response while
+		// refreshing it in the background
+		StaleWhileRevalidate time.Duration
+
+		// StaleIfError lets caches serve a stale response when the origin fails
+		StaleIfError time.Duration
+
+		// MustRevalidate forbids serving stale responses
+		MustRevalidate bool
+
+		// Immutable tells the response never changes while fresh
+		Immutable bool
+
+		// OnlySuccess limits the header to 2xx and 3xx responses, letting
+		// errors go out without a caching policy. Default is false
+		OnlySuccess bool
+	}
+
+	// cacheControlWriter adds the header once the status is known
+	cacheControlWriter struct {
+		http.ResponseWriter
+		value string
+	}
+)
+
+// CacheControl returns a middleware setting the Cache-Control header from
+// directives, unless the handler set one itself
+func CacheControl(directives CacheDirectives) chef.Handler {
+	value := directives.String()
+
+	return func(ctx chef.Context) {
+		res := ctx.Response()
+		h := res.Header()
+		if !directives.OnlySuccess {
+			h.Set(chef.HeaderCacheControl, value)
+			ctx.Next()
+			return
+		}
+
+		// the status is only known once the handler starts writing
+		w := &cacheControlWriter{ResponseWriter: res.Writer, value: value}
+		res.Writer = w
+		defer func() {
+			res.Writer = w.ResponseWriter
+		}()
+		ctx.Next()
+	}
+}
+
+// String formats the directives as a Cache-Control header value
+func (d CacheDirectives) String() string {
+	var parts []string
+	add := func(ok bool, directive string) {
+		if ok {
+			parts = append(parts, directive)
+		}
+	}
+	seconds := func(name string, v time.Duration) {
+		if v > 0 {
+			parts = append(parts, name+"="+strconv.Itoa(int(v/time.Second)))
+		}
+	}
+
+	add(d.Public, "public")
+	add(d.Private, "private")
+	add(d.NoCache, "no-cache")
+	add(d.NoStore, "no-store")
+	seconds("max-age", d.MaxAge)
+	seconds("s-maxage", d.SMaxAge)
+	seconds("stale-while-revalidate", d.StaleWhileRevalidate)
+	seconds("stale-if-error", d.StaleIfError)
+	add(d.MustRevalidate, "must-revalidate")
+	add(d.Immutable, "immutable")
+	return strings.Join(parts, ", ")
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	h := w.Header()
+	if code < http.StatusBadRequest && h.Get(chef.HeaderCacheControl) == "" {
+		h.Set(chef.HeaderCacheControl, w.value)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheControlWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
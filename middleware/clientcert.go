@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// ClientCertOptions is the configuration used to setup the client certificate middleware
+	ClientCertOptions struct {
+		// Principal maps the verified client certificate to an application
+		// principal, returning false if the identity is not allowed.
+		// Default maps the subject common name.
+		Principal func(cert *x509.Certificate) (interface{}, bool)
+
+		// ContextKey is the key the principal is stored under in the context.
+		// Default value is "principal"
+		ContextKey string
+	}
+)
+
+// ClientCert returns a middleware that requires a verified client certificate
+// and stores the principal it maps to in the context. Requests without a
+// verified chain get a 401, certificates that don't map to a principal a 403.
+func ClientCert(options ClientCertOptions) chef.Handler {
+	if options.Principal == nil {
+		options.Principal = func(cert *x509.Certificate) (interface{}, bool) {
+			return cert.Subject.CommonName, cert.Subject.CommonName != ""
+		}
+	}
+	if options.ContextKey == "" {
+		options.ContextKey = "principal"
+	}
+
+	return func(ctx chef.Context) {
+		chain := ctx.VerifiedChain()
+		if len(chain) == 0 {
+			ctx.SetStatusCode(http.StatusUnauthorized)
+			ctx.WriteString("client certificate required")
+			return
+		}
+
+		principal, ok := options.Principal(chain[0])
+		if !ok {
+			ctx.SetStatusCode(http.StatusForbidden)
+			ctx.WriteString("client certificate not allowed")
+			return
+		}
+
+		ctx.Set(options.ContextKey, principal)
+		ctx.Next()
+	}
+}
@@ -0,0 +1,397 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gochef/chef"
+	"github.com/klauspost/compress/zstd"
+)
+
+type (
+	// CompressOptions is the configuration used to setup the compression middleware
+	CompressOptions struct {
+		// Encodings lists the content codings offered, in order of preference
+		// when the client accepts several equally. Default value is zstd,
+		// brotli, gzip then deflate.
+		Encodings []Encoding
+
+		// Level is the compression level used for content types missing from
+		// ContentLevels. Default value is the default level of each encoding
+		Level int
+
+		// ContentLevels sets the compression level per media type. An entry
+		// ending with "/" matches a whole family (i.e.: "text/").
+		ContentLevels map[string]int
+
+		// MinLength is the minimum response size in bytes worth compressing.
+		// Smaller responses are sent as is. Default value is 1024
+		MinLength int
+
+		// ContentTypes is the list of media types to compress. An entry ending with
+		// "/" matches a whole family (i.e.: "text/"). Default value covers text,
+		// JSON, JavaScript, XML and SVG.
+		ContentTypes []string
+	}
+
+	// Encoding is a content coding the compressor can produce
+	Encoding struct {
+		// Name is the token used in Accept-Encoding and Content-Encoding
+		Name string
+
+		// DefaultLevel is used when no level is configured
+		DefaultLevel int
+
+		// NewWriter returns a writer compressing into w at level. Writers
+		// having a Reset(io.Writer) method are pooled.
+		NewWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	}
+
+	// Compressor represents the middleware instance
+	Compressor struct {
+		encodings     []Encoding
+		level         int
+		contentLevels map[string]int
+		minLength     int
+		contentTypes  []string
+		pools         sync.Map
+	}
+
+	compressWriter struct {
+		http.ResponseWriter
+		compressor *Compressor
+		encoding   Encoding
+		status     int
+		buf        []byte
+		enc        io.WriteCloser
+		pool       *sync.Pool
+		decided    bool
+		compress   bool
+	}
+
+	resetter interface {
+		Reset(w io.Writer)
+	}
+)
+
+var (
+	// GzipEncoding compresses with gzip
+	GzipEncoding = Encoding{
+		Name:         "gzip",
+		DefaultLevel: gzip.DefaultCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+	}
+
+	// DeflateEncoding compresses with deflate, which HTTP defines as the zlib
+	// format, see RFC 9110 section 8.4.1.2
+	DeflateEncoding = Encoding{
+		Name:         "deflate",
+		DefaultLevel: zlib.DefaultCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+	}
+
+	// BrotliEncoding compresses with brotli, see RFC 7932
+	BrotliEncoding = Encoding{
+		Name:         "br",
+		DefaultLevel: brotli.DefaultCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level < brotli.BestSpeed || level > brotli.BestCompression {
+				return nil, errors.New("chef: invalid brotli compression level")
+			}
+			return brotli.NewWriterLevel(w, level), nil
+		},
+	}
+
+	// ZstdEncoding compresses with Zstandard. The window is capped at 8MB as
+	// decoders of the content coding must support no more, see RFC 8878
+	// section 3.1.1.1.2.
+	ZstdEncoding = Encoding{
+		Name:         "zstd",
+		DefaultLevel: 3,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return zstd.NewWriter(w,
+				zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithWindowSize(8<<20),
+			)
+		},
+	}
+
+	defaultCompressTypes = []string{
+		"text/",
+		chef.MIMEApplicationJSON,
+		chef.MIMEApplicationJavaScript,
+		chef.MIMEApplicationXML,
+		"application/problem+json",
+		"image/svg+xml",
+	}
+)
+
+// NewCompressor creates a new compression middleware instance with provided options
+func NewCompressor(options CompressOptions) *Compressor {
+	c := &Compressor{
+		encodings:     options.Encodings,
+		level:         options.Level,
+		contentLevels: options.ContentLevels,
+		minLength:     options.MinLength,
+		contentTypes:  options.ContentTypes,
+	}
+	if len(c.encodings) == 0 {
+		c.encodings = []Encoding{ZstdEncoding, BrotliEncoding, GzipEncoding, DeflateEncoding}
+	}
+	if c.minLength <= 0 {
+		c.minLength = 1024
+	}
+	if len(c.contentTypes) == 0 {
+		c.contentTypes = defaultCompressTypes
+	}
+
+	return c
+}
+
+// Compress returns a zstd, brotli, gzip and deflate compression middleware
+// with default options
+func Compress() chef.Handler {
+	return NewCompressor(CompressOptions{}).Handler
+}
+
+// Handler compresses the response with the preferred encoding the client
+// accepts when the response qualifies for it
+func (c *Compressor) Handler(ctx chef.Context) {
+	res := ctx.Response()
+	res.Header().Add(chef.HeaderVary, chef.HeaderAcceptEncoding)
+
+	encoding, ok := c.negotiate(ctx.Request().Header.Get(chef.HeaderAcceptEncoding))
+	if !ok {
+		ctx.Next()
+		return
+	}
+
+	w := &compressWriter{
+		ResponseWriter: res.Writer,
+		compressor:     c,
+		encoding:       encoding,
+	}
+	res.Writer = w
+	defer func() {
+		w.close()
+		res.Writer = w.ResponseWriter
+	}()
+
+	ctx.Next()
+}
+
+// negotiate picks the encoding with the highest quality in the Accept-Encoding
+// header, ties going to the first configured one
+func (c *Compressor) negotiate(header string) (Encoding, bool) {
+	best, bestQ := Encoding{}, 0.0
+	for _, e := range c.encodings {
+		if q := encodingQuality(header, e.Name); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// writer returns a pooled writer for the encoding at level, compressing into w
+func (c *Compressor) writer(e Encoding, level int, w io.Writer) (io.WriteCloser, *sync.Pool) {
+	key := e.Name + "/" + strconv.Itoa(level)
+	p, _ := c.pools.LoadOrStore(key, &sync.Pool{})
+	pool := p.(*sync.Pool)
+	if enc, ok := pool.Get().(io.WriteCloser); ok {
+		enc.(resetter).Reset(w)
+		return enc, pool
+	}
+
+	enc, err := e.NewWriter(w, level)
+	if err != nil {
+		panic("chef: invalid " + e.Name + " level " + strconv.Itoa(level))
+	}
+	if _, ok := enc.(resetter); !ok {
+		pool = nil
+	}
+	return enc, pool
+}
+
+// levelFor returns the compression level configured for the content type
+func (c *Compressor) levelFor(e Encoding, ct string) int {
+	if level, ok := c.contentLevels[ct]; ok {
+		return level
+	}
+	for t, level := range c.contentLevels {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(ct, t) {
+			return level
+		}
+	}
+	if c.level != 0 {
+		return c.level
+	}
+	return e.DefaultLevel
+}
+
+func (c *Compressor) shouldCompress(h http.Header, status int) bool {
+	switch status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	if h.Get(chef.HeaderContentEncoding) != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+
+	ct := mediaType(h)
+	for _, t := range c.contentTypes {
+		if ct == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(ct, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.compressor.minLength {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.compress {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide commits the headers and flushes the buffered body. Responses are only
+// compressed once enough data has been buffered; a short or streamed response
+// goes out as is.
+func (w *compressWriter) decide(enough bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get(chef.HeaderContentType) == "" && len(w.buf) > 0 {
+		h.Set(chef.HeaderContentType, http.DetectContentType(w.buf))
+	}
+
+	w.compress = enough && w.compressor.shouldCompress(h, w.status)
+	if w.compress {
+		h.Del(chef.HeaderContentLength)
+		h.Set(chef.HeaderContentEncoding, w.encoding.Name)
+		level := w.compressor.levelFor(w.encoding, mediaType(h))
+		w.enc, w.pool = w.compressor.writer(w.encoding, level, w.ResponseWriter)
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.compress {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok && w.compress {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("chef: response does not implement http.Hijacker")
+	}
+	w.decided = true
+	return h.Hijack()
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.compress {
+		w.enc.Close()
+		if w.pool != nil {
+			w.pool.Put(w.enc)
+		}
+		w.enc = nil
+	}
+}
+
+// mediaType returns the lowercased media type of the Content-Type header
+func mediaType(h http.Header) string {
+	ct := h.Get(chef.HeaderContentType)
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(ct))
+}
+
+// encodingQuality returns the quality the Accept-Encoding header gives the
+// encoding, falling back to the "*" entry
+func encodingQuality(header, encoding string) float64 {
+	q, wildcard := 0.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		name, params := part, ""
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			name, params = strings.TrimSpace(part[:i]), part[i+1:]
+		}
+
+		v := 1.0
+		params = strings.ReplaceAll(params, " ", "")
+		if p := strings.TrimPrefix(params, "q="); p != params {
+			if f, err := strconv.ParseFloat(p, 64); err == nil {
+				v = f
+			}
+		}
+
+		switch {
+		case strings.EqualFold(name, encoding):
+			return v
+		case name == "*":
+			wildcard = v
+		}
+	}
+	if wildcard >= 0 {
+		q = wildcard
+	}
+	return q
+}
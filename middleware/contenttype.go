@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gochef/chef"
+)
+
+// RequireContentType returns a middleware rejecting requests carrying a body
+// whose Content-Type is not one of mimeTypes with a 415, before anything
+// reads the body. An entry such as "text/*" matches a whole family.
+func RequireContentType(mimeTypes ...string) chef.Handler {
+	accept := strings.Join(mimeTypes, ", ")
+
+	return func(ctx chef.Context) {
+		req := ctx.Request()
+		if req.ContentLength == 0 && len(req.TransferEncoding) == 0 {
+			ctx.Next()
+			return
+		}
+
+		ct, _, err := mime.ParseMediaType(req.Header.Get(chef.HeaderContentType))
+		if err != nil || !matchesMediaType(ct, mimeTypes) {
+			switch req.Method {
+			case chef.POST:
+				ctx.SetHeader(chef.HeaderAcceptPost, accept)
+			case chef.PATCH:
+				ctx.SetHeader(chef.HeaderAcceptPatch, accept)
+			}
+			ctx.SetStatusCode(http.StatusUnsupportedMediaType)
+			ctx.WriteString("unsupported media type")
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func matchesMediaType(ct string, mimeTypes []string) bool {
+	for _, t := range mimeTypes {
+		if strings.EqualFold(ct, t) {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(strings.ToLower(ct), strings.ToLower(t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"github.com/gochef/chef"
+)
+
+const (
+	defaultCSPPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; " +
+		"style-src 'self' 'nonce-{nonce}'; object-src 'none'; base-uri 'self'"
+)
+
+type (
+	// CSPOptions is the configuration used to setup the CSP nonce middleware
+	CSPOptions struct {
+		// Policy is the Content-Security-Policy, every {nonce} in it is replaced
+		// by the request nonce. Default allows same origin resources and
+		// nonced inline scripts and styles.
+		Policy string
+
+		// ReportOnly sends the policy in Content-Security-Policy-Report-Only
+		ReportOnly bool
+
+		// ContextKey is the key the nonce is stored under in the context, for
+		// templates to add to their inline tags. Default value is "csp_nonce"
+		ContextKey string
+	}
+)
+
+// CSPNonce returns a middleware generating a nonce per request and sending it
+// in the Content-Security-Policy header, so inline scripts carrying it run
+// without 'unsafe-inline'
+func CSPNonce(options CSPOptions) chef.Handler {
+	if options.Policy == "" {
+		options.Policy = defaultCSPPolicy
+	}
+	if options.ContextKey == "" {
+		options.ContextKey = "csp_nonce"
+	}
+	header := chef.HeaderContentSecurityPolicy
+	if options.ReportOnly {
+		header += "-Report-Only"
+	}
+
+	return func(ctx chef.Context) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			ctx.Error(err)
+			return
+		}
+		nonce := base64.StdEncoding.EncodeToString(b)
+
+		ctx.Set(options.ContextKey, nonce)
+		ctx.SetHeader(header, strings.ReplaceAll(options.Policy, "{nonce}", nonce))
+		ctx.Next()
+	}
+}
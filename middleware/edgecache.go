@@ -0,0 +1,367 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+// HeaderXCache reports whether a response was served from cache
+const HeaderXCache = "X-Cache"
+
+type (
+	// EdgeCacheOptions is the configuration used to setup the edge cache
+	EdgeCacheOptions struct {
+		// Origin is the base URL of the server being fronted
+		Origin string
+
+		// Store holds the cached responses. Default value is a MemoryStore
+		Store Store
+
+		// StaleIfError is how long an expired response may still be served when
+		// the origin fails. Default value is 1 minute
+		StaleIfError time.Duration
+
+		// DefaultTTL applies to cacheable responses without an explicit
+		// freshness lifetime. Default value is 0, which doesn't cache them
+		DefaultTTL time.Duration
+
+		// Client is used to reach the origin. Default value is a client with a
+		// 30 seconds timeout
+		Client *http.Client
+
+		// MaxBodySize caps the origin bodies kept in the store, larger ones are
+		// streamed without caching. Default value is 10MB
+		MaxBodySize int64
+	}
+
+	// EdgeCache represents the caching reverse proxy instance
+	EdgeCache struct {
+		origin       *url.URL
+		store        Store
+		staleIfError time.Duration
+		defaultTTL   time.Duration
+		client       *http.Client
+		maxBodySize  int64
+	}
+)
+
+var (
+	// hopHeaders are not forwarded by proxies, see RFC 7230 section 6.1
+	hopHeaders = []string{
+		"Connection",
+		"Proxy-Connection",
+		"Keep-Alive",
+		"Proxy-Authenticate",
+		"Proxy-Authorization",
+		"Te",
+		"Trailer",
+		"Transfer-Encoding",
+		"Upgrade",
+	}
+)
+
+// NewEdgeCache creates a new edge cache instance with provided options
+func NewEdgeCache(options EdgeCacheOptions) *EdgeCache {
+	origin, err := url.Parse(options.Origin)
+	if err != nil || origin.Host == "" {
+		panic("chef: invalid edge cache origin " + options.Origin)
+	}
+
+	e := &EdgeCache{
+		origin:       origin,
+		store:        options.Store,
+		staleIfError: options.StaleIfError,
+		defaultTTL:   options.DefaultTTL,
+		client:       options.Client,
+		maxBodySize:  options.MaxBodySize,
+	}
+	if e.store == nil {
+		e.store = NewMemoryStore()
+	}
+	if e.staleIfError <= 0 {
+		e.staleIfError = time.Minute
+	}
+	if e.client == nil {
+		e.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if e.maxBodySize <= 0 {
+		e.maxBodySize = 10 << 20
+	}
+
+	return e
+}
+
+// Handler serves the request from cache when possible and from the origin otherwise
+func (e *EdgeCache) Handler(ctx chef.Context) {
+	req := ctx.Request()
+	res := ctx.Response()
+	cacheable := req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	var cached *cachedResponse
+	if cacheable {
+		if b, ok := e.store.Get(e.key(req)); ok {
+			cached, _ = decodeCachedResponse(b)
+		}
+		if cached != nil && time.Now().Before(cached.Expires) {
+			res.Header().Set(HeaderXCache, "HIT")
+			cached.write(res)
+			return
+		}
+	}
+
+	fetched, rest, err := e.fetch(req)
+	if rest != nil {
+		defer rest.Close()
+		res.Header().Set(HeaderXCache, "MISS")
+		fetched.write(res)
+		io.Copy(res, rest)
+		return
+	}
+	if err != nil || fetched.Status >= http.StatusInternalServerError {
+		if cached != nil {
+			res.Header().Set(HeaderXCache, "STALE")
+			cached.write(res)
+			return
+		}
+		if err != nil {
+			ctx.SetStatusCode(http.StatusBadGateway)
+			ctx.WriteString("bad gateway")
+			return
+		}
+	}
+
+	if cacheable {
+		if ttl, ok := e.ttl(req, fetched); ok {
+			fetched.Expires = fetched.Stored.Add(ttl)
+			e.save(req, fetched, ttl+e.staleIfError)
+		}
+	}
+
+	res.Header().Set(HeaderXCache, "MISS")
+	fetched.write(res)
+}
+
+// Purge removes the cached responses for the path (including its query
+// string), all their variants included
+func (e *EdgeCache) Purge(path string) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		base := baseKey(method, u)
+		e.store.Delete(base)
+		// variants are keyed after the index, so they are unreachable once
+		// it is gone
+		e.store.Delete(varyKeyPrefix + base)
+	}
+}
+
+// PurgeHandler purges the path given in the "path" query parameter. It should
+// be mounted behind authentication.
+func (e *EdgeCache) PurgeHandler(ctx chef.Context) {
+	path := ctx.QueryParam("path")
+	if path == "" {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.WriteString("missing path")
+		return
+	}
+	e.Purge(path)
+	ctx.SetStatusCode(http.StatusNoContent)
+}
+
+// varyKeyPrefix keys the index of the responses with a Vary header. It holds
+// a random generation, so that variants stored before a purge stay
+// unreachable, followed by a line with the varying request headers.
+const varyKeyPrefix = "edge-vary:"
+
+// baseKey is the cache key of the responses to method on u
+func baseKey(method string, u *url.URL) string {
+	return "edge:" + method + " " + u.RequestURI()
+}
+
+// key returns the cache key of req, selecting the variant of the headers
+// named by the Vary of the stored response
+func (e *EdgeCache) key(req *http.Request) string {
+	base := baseKey(req.Method, req.URL)
+	if index, ok := e.store.Get(varyKeyPrefix + base); ok {
+		return base + variant(req, string(index))
+	}
+	return base
+}
+
+// save stores r as the response to req for ttl, indexing its variants when
+// it has a Vary header
+func (e *EdgeCache) save(req *http.Request, r *cachedResponse, ttl time.Duration) {
+	base := baseKey(req.Method, req.URL)
+	vary := varyHeaders(r.Header)
+	if len(vary) == 0 {
+		e.store.Delete(varyKeyPrefix + base)
+		e.store.Set(base, r.encode(), ttl)
+		return
+	}
+
+	names := strings.Join(vary, ",")
+	index := ""
+	if b, ok := e.store.Get(varyKeyPrefix + base); ok && strings.HasSuffix(string(b), "\n"+names) {
+		index = string(b)
+	} else {
+		index = newTraceID(8) + "\n" + names
+	}
+	e.store.Set(varyKeyPrefix+base, []byte(index), ttl)
+	e.store.Set(base+variant(req, index), r.encode(), ttl)
+}
+
+// variant returns the key suffix selecting the values of req for the headers
+// of index
+func variant(req *http.Request, index string) string {
+	generation, names, _ := strings.Cut(index, "\n")
+	var sb strings.Builder
+	sb.WriteString("|" + generation)
+	for _, name := range strings.Split(names, ",") {
+		sb.WriteString("|" + name + "=" + strings.Join(req.Header.Values(name), ","))
+	}
+	return sb.String()
+}
+
+// varyHeaders returns the sorted canonical names listed by the Vary header
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values(chef.HeaderVary) {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fetch forwards req to the origin. Bodies larger than the cap are returned
+// with the unread rest, which the caller must close.
+func (e *EdgeCache) fetch(req *http.Request) (*cachedResponse, io.ReadCloser, error) {
+	target := *e.origin
+	target.Path = strings.TrimSuffix(target.Path, "/") + req.URL.Path
+	target.RawQuery = req.URL.RawQuery
+
+	out, err := http.NewRequest(req.Method, target.String(), req.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	out = out.WithContext(req.Context())
+	out.Header = req.Header.Clone()
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	out.Header.Set(chef.HeaderXForwardedFor, clientIP(req))
+
+	resp, err := e.client.Do(out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, e.maxBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+
+	fetched := &cachedResponse{
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   body,
+		Stored: time.Now(),
+	}
+	if int64(len(body)) > e.maxBodySize {
+		return fetched, resp.Body, nil
+	}
+	resp.Body.Close()
+	return fetched, nil, nil
+}
+
+// ttl returns the shared-cache freshness lifetime of the response r to req
+func (e *EdgeCache) ttl(req *http.Request, r *cachedResponse) (time.Duration, bool) {
+	if r.Status != http.StatusOK || r.Header.Get(chef.HeaderSetCookie) != "" {
+		return 0, false
+	}
+	for _, name := range varyHeaders(r.Header) {
+		if name == "*" {
+			return 0, false
+		}
+	}
+
+	cc := parseCacheControl(r.Header.Get(chef.HeaderCacheControl))
+	if req.Header.Get(chef.HeaderAuthorization) != "" {
+		// responses to authenticated requests are shared only when the origin
+		// says so, see RFC 9111 section 3.5
+		_, public := cc["public"]
+		_, shared := cc["s-maxage"]
+		if !public && !shared {
+			return 0, false
+		}
+	}
+	if _, ok := cc["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := cc["private"]; ok {
+		return 0, false
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return 0, false
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return 0, false
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	if e.defaultTTL > 0 {
+		return e.defaultTTL, true
+	}
+	return 0, false
+}
+
+// parseCacheControl splits a Cache-Control header into its directives
+func parseCacheControl(header string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			name, value = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = value
+	}
+	return directives
+}
+
+// clientIP appends the remote address to any X-Forwarded-For chain
+func clientIP(req *http.Request) string {
+	ip := req.RemoteAddr
+	if i := strings.LastIndexByte(ip, ':'); i >= 0 {
+		ip = ip[:i]
+	}
+	if prior := req.Header.Get(chef.HeaderXForwardedFor); prior != "" {
+		return prior + ", " + ip
+	}
+	return ip
+}
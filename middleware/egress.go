@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	stdctx "context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// EgressPolicy centralizes the rules applied to outgoing connections, both
+	// for the reverse proxy and for clients created with Client. Host entries
+	// are host names, optionally with a leading "*." matching any subdomain.
+	EgressPolicy struct {
+		// AllowedHosts lists the hosts requests may be sent to. Empty allows all.
+		AllowedHosts []string
+
+		// Pins maps a host to the accepted base64 SHA-256 hashes of a
+		// certificate public key (SPKI) in its verified chain. Hosts without
+		// pins rely on regular certificate verification only, hosts with pins
+		// are refused when verification is skipped.
+		Pins map[string][]string
+
+		// Timeouts sets the deadline of a whole exchange per host
+		Timeouts map[string]time.Duration
+
+		// Timeout is used for hosts missing from Timeouts. Zero means no deadline.
+		Timeout time.Duration
+
+		// TLSConfig is the base TLS configuration of outgoing connections
+		TLSConfig *tls.Config
+	}
+
+	egressTransport struct {
+		policy *EgressPolicy
+		base   http.RoundTripper
+	}
+
+	cancelBody struct {
+		io.ReadCloser
+		cancel stdctx.CancelFunc
+	}
+)
+
+var (
+	// ErrEgressDenied is returned for requests to hosts outside AllowedHosts
+	ErrEgressDenied = errors.New("chef: egress to host denied by policy")
+
+	// ErrPinMismatch is returned when no certificate of the peer matches its pins
+	ErrPinMismatch = errors.New("chef: certificate does not match pinned keys")
+)
+
+// Allowed reports whether the policy lets requests reach host
+func (p *EgressPolicy) Allowed(host string) bool {
+	if len(p.AllowedHosts) == 0 {
+		return true
+	}
+	return matchHost(p.AllowedHosts, host)
+}
+
+// Transport returns a transport enforcing the policy. Connections are made by
+// a clone of http.DefaultTransport carrying the TLS configuration and pins.
+func (p *EgressPolicy) Transport() http.RoundTripper {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = p.tlsConfig()
+	return &egressTransport{policy: p, base: base}
+}
+
+// Wrap returns a transport enforcing the allowed hosts and timeouts of the
+// policy on top of base. Pins are only checked by Transport.
+func (p *EgressPolicy) Wrap(base http.RoundTripper) http.RoundTripper {
+	return &egressTransport{policy: p, base: base}
+}
+
+// Client returns an HTTP client using the policy transport
+func (p *EgressPolicy) Client() *http.Client {
+	return &http.Client{Transport: p.Transport()}
+}
+
+func (p *EgressPolicy) timeout(host string) time.Duration {
+	entries := make([]string, 0, len(p.Timeouts))
+	for e := range p.Timeouts {
+		entries = append(entries, e)
+	}
+	if e, ok := lookupHost(entries, host); ok {
+		return p.Timeouts[e]
+	}
+	return p.Timeout
+}
+
+func (p *EgressPolicy) pins(host string) ([]string, bool) {
+	entries := make([]string, 0, len(p.Pins))
+	for e := range p.Pins {
+		entries = append(entries, e)
+	}
+	if e, ok := lookupHost(entries, host); ok {
+		return p.Pins[e], true
+	}
+	return nil, false
+}
+
+func (p *EgressPolicy) tlsConfig() *tls.Config {
+	cfg := &tls.Config{}
+	if p.TLSConfig != nil {
+		cfg = p.TLSConfig.Clone()
+	}
+	if len(p.Pins) == 0 {
+		return cfg
+	}
+
+	verify := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		pins, ok := p.pins(cs.ServerName)
+		if !ok {
+			return nil
+		}
+		// only the verified chains are trusted: the server may append any
+		// certificate, the pinned one included, to what it sends
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				hash := base64.StdEncoding.EncodeToString(sum[:])
+				for _, pin := range pins {
+					if pin == hash {
+						return nil
+					}
+				}
+			}
+		}
+		return ErrPinMismatch
+	}
+	return cfg
+}
+
+func (t *egressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	if !t.policy.Allowed(host) {
+		return nil, ErrEgressDenied
+	}
+
+	d := t.policy.timeout(host)
+	if d <= 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := stdctx.WithTimeout(req.Context(), d)
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the deadline covers reading the body too
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// matchHost reports whether host matches one of the entries
+func matchHost(entries []string, host string) bool {
+	_, ok := lookupHost(entries, host)
+	return ok
+}
+
+// lookupHost returns the entry matching host, preferring an exact match to
+// the longest wildcard
+func lookupHost(entries []string, host string) (string, bool) {
+	host = normalizeHost(host)
+	match, found := "", false
+	for _, e := range entries {
+		if !matchEntry(e, host) {
+			continue
+		}
+		if !strings.HasPrefix(e, "*.") {
+			return e, true
+		}
+		if !found || len(e) > len(match) {
+			match, found = e, true
+		}
+	}
+	return match, found
+}
+
+// matchEntry reports whether the normalized host matches the entry
+func matchEntry(entry, host string) bool {
+	entry = strings.ToLower(entry)
+	return entry == host || (strings.HasPrefix(entry, "*.") && strings.HasSuffix(host, entry[1:]))
+}
+
+// normalizeHost lowercases host and strips its port and trailing dot
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
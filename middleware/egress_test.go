@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestMatchHost(t *testing.T) {
+	entries := []string{"api.example.com", "*.Internal.example.com"}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"api.example.com", true},
+		{"API.Example.com", true},
+		{"api.example.com:443", true},
+		{"api.example.com.", true},
+		{"db.internal.example.com", true},
+		{"internal.example.com", false},
+		{"example.com", false},
+		{"api.example.com.evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := matchHost(entries, tt.host); got != tt.want {
+			t.Errorf("matchHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestEgressTimeout(t *testing.T) {
+	p := &EgressPolicy{
+		Timeout: time.Second,
+		Timeouts: map[string]time.Duration{
+			"Slow.example.com": time.Minute,
+			"*.example.com":    2 * time.Second,
+		},
+	}
+	tests := []struct {
+		host string
+		want time.Duration
+	}{
+		{"slow.example.com", time.Minute},
+		{"SLOW.example.com:8443", time.Minute},
+		{"fast.example.com", 2 * time.Second},
+		{"other.org", time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.timeout(tt.host); got != tt.want {
+			t.Errorf("timeout(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestEgressPins(t *testing.T) {
+	pinned := &x509.Certificate{RawSubjectPublicKeyInfo: []byte("pinned key")}
+	other := &x509.Certificate{RawSubjectPublicKeyInfo: []byte("other key")}
+	sum := sha256.Sum256(pinned.RawSubjectPublicKeyInfo)
+	p := &EgressPolicy{Pins: map[string][]string{
+		"api.example.com": {base64.StdEncoding.EncodeToString(sum[:])},
+	}}
+	verify := p.tlsConfig().VerifyConnection
+
+	tests := []struct {
+		name  string
+		state tls.ConnectionState
+		want  error
+	}{
+		{
+			name: "pinned key in verified chain",
+			state: tls.ConnectionState{
+				ServerName:       "api.example.com",
+				PeerCertificates: []*x509.Certificate{other, pinned},
+				VerifiedChains:   [][]*x509.Certificate{{other, pinned}},
+			},
+		},
+		{
+			name: "host matched case-insensitively",
+			state: tls.ConnectionState{
+				ServerName:     "API.example.com",
+				VerifiedChains: [][]*x509.Certificate{{pinned}},
+			},
+		},
+		{
+			name: "pinned key only appended to the sent certificates",
+			state: tls.ConnectionState{
+				ServerName:       "api.example.com",
+				PeerCertificates: []*x509.Certificate{other, pinned},
+				VerifiedChains:   [][]*x509.Certificate{{other}},
+			},
+			want: ErrPinMismatch,
+		},
+		{
+			name: "verification skipped",
+			state: tls.ConnectionState{
+				ServerName:       "api.example.com",
+				PeerCertificates: []*x509.Certificate{pinned},
+			},
+			want: ErrPinMismatch,
+		},
+		{
+			name: "host without pins",
+			state: tls.ConnectionState{
+				ServerName:       "other.example.com",
+				PeerCertificates: []*x509.Certificate{other},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := verify(tt.state); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
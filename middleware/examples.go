@@ -0,0 +1,387 @@
+package middleware
+
+import (
+	"encoding/json"
+	"html/template"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gochef/chef"
+)
+
+const redacted = "[REDACTED]"
+
+type (
+	// ExamplesOptions is the configuration used to setup the examples recorder
+	ExamplesOptions struct {
+		// SampleRate is the fraction of requests recorded. Default value is 0.01
+		SampleRate float64
+
+		// PerRoute is how many examples are kept per route and method, newest
+		// first. Default value is 5
+		PerRoute int
+
+		// MaxBodySize caps the recorded bodies. Default value is 4KB
+		MaxBodySize int
+
+		// RedactHeaders lists headers whose values are never recorded.
+		// Authorization and cookies are always redacted.
+		RedactHeaders []string
+
+		// RedactFields lists JSON object keys whose values are masked, matched
+		// case-insensitively. Default value covers passwords, tokens and secrets
+		RedactFields []string
+
+		// Title of the OpenAPI document and of the docs page. Default value is
+		// "API"
+		Title string
+
+		// Version of the OpenAPI document. Default value is "1.0.0"
+		Version string
+	}
+
+	// Example is an anonymized request/response pair
+	Example struct {
+		Method          string            `json:"method"`
+		Route           string            `json:"route"`
+		Query           string            `json:"query,omitempty"`
+		RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+		RequestBody     json.RawMessage   `json:"request_body,omitempty"`
+		Status          int               `json:"status"`
+		ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+		ResponseBody    json.RawMessage   `json:"response_body,omitempty"`
+	}
+
+	// ExampleRecorder represents the middleware instance
+	ExampleRecorder struct {
+		options       ExamplesOptions
+		redactHeaders map[string]bool
+		redactFields  map[string]bool
+
+		lock     sync.RWMutex
+		examples map[string][]Example
+	}
+)
+
+// NewExampleRecorder creates a new examples recorder instance with provided options
+func NewExampleRecorder(options ExamplesOptions) *ExampleRecorder {
+	if options.SampleRate <= 0 {
+		options.SampleRate = 0.01
+	}
+	if options.PerRoute <= 0 {
+		options.PerRoute = 5
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = 4 * 1024
+	}
+	if options.Title == "" {
+		options.Title = "API"
+	}
+	if options.Version == "" {
+		options.Version = "1.0.0"
+	}
+	if len(options.RedactFields) == 0 {
+		options.RedactFields = []string{"password", "token", "secret", "access_token", "refresh_token", "api_key"}
+	}
+
+	r := &ExampleRecorder{
+		options:       options,
+		redactHeaders: map[string]bool{},
+		redactFields:  map[string]bool{},
+		examples:      map[string][]Example{},
+	}
+	for _, h := range append(options.RedactHeaders, chef.HeaderAuthorization, chef.HeaderCookie, chef.HeaderSetCookie) {
+		r.redactHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, f := range options.RedactFields {
+		r.redactFields[strings.ToLower(f)] = true
+	}
+
+	return r
+}
+
+// Handler records a sample of the requests going through it
+func (r *ExampleRecorder) Handler(ctx chef.Context) {
+	if rand.Float64() >= r.options.SampleRate {
+		ctx.Next()
+		return
+	}
+
+	req := ctx.Request()
+	reqBody, _ := ctx.Body()
+
+	res := ctx.Response()
+	w := &dumpWriter{
+		ResponseWriter: res.Writer,
+		limit:          r.options.MaxBodySize,
+	}
+	res.Writer = w
+	ctx.Next()
+	res.Writer = w.ResponseWriter
+
+	route := ctx.Path()
+	if route == "" {
+		return
+	}
+
+	example := Example{
+		Method:          req.Method,
+		Route:           route,
+		Query:           r.anonymizeQuery(req.URL.Query()),
+		RequestHeaders:  r.headers(req.Header),
+		RequestBody:     r.body(reqBody),
+		Status:          res.Status,
+		ResponseHeaders: r.headers(res.Header()),
+		ResponseBody:    r.body(w.buf.Bytes()),
+	}
+
+	key := req.Method + " " + route
+	r.lock.Lock()
+	list := append([]Example{example}, r.examples[key]...)
+	if len(list) > r.options.PerRoute {
+		list = list[:r.options.PerRoute]
+	}
+	r.examples[key] = list
+	r.lock.Unlock()
+}
+
+// Examples returns the recorded examples keyed by "METHOD /route/pattern"
+func (r *ExampleRecorder) Examples() map[string][]Example {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	out := make(map[string][]Example, len(r.examples))
+	for k, v := range r.examples {
+		out[k] = append([]Example(nil), v...)
+	}
+	return out
+}
+
+// ServeExamples responds with the recorded examples as JSON, for
+// documentation generators to consume
+func (r *ExampleRecorder) ServeExamples(ctx chef.Context) {
+	examples := r.Examples()
+	keys := make([]string, 0, len(examples))
+	for k := range examples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]Example, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, examples[k]...)
+	}
+	ctx.JSON(out)
+}
+
+// MountDocs serves the docs page on /_docs and the OpenAPI document on
+// /_docs/openapi.json of router, a *chef.Chef or a *chef.Group. They should be
+// mounted behind authentication outside development.
+func (r *ExampleRecorder) MountDocs(router interface {
+	GET(path string, h chef.Handler)
+}) {
+	router.GET("/_docs", r.ServeDocs)
+	router.GET("/_docs/openapi.json", r.ServeOpenAPI)
+}
+
+// ServeOpenAPI responds with the OpenAPI document of the recorded routes
+func (r *ExampleRecorder) ServeOpenAPI(ctx chef.Context) {
+	ctx.JSON(r.OpenAPI())
+}
+
+// OpenAPI returns an OpenAPI 3 document of the recorded routes, see
+// AddToOpenAPI
+func (r *ExampleRecorder) OpenAPI() map[string]interface{} {
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   r.options.Title,
+			"version": r.options.Version,
+		},
+	}
+	r.AddToOpenAPI(spec)
+	return spec
+}
+
+// AddToOpenAPI adds the recorded examples to the operations of spec, an
+// OpenAPI 3 document decoded from JSON, as request body and response examples
+// named "recorded-1" onwards. Missing paths and operations are added.
+func (r *ExampleRecorder) AddToOpenAPI(spec map[string]interface{}) {
+	paths := openAPIObject(spec, "paths")
+	for key, examples := range r.Examples() {
+		method, route, _ := strings.Cut(key, " ")
+		path, params := openAPIPath(route)
+		op := openAPIObject(openAPIObject(paths, path), strings.ToLower(method))
+		if _, ok := op["parameters"]; !ok && len(params) > 0 {
+			list := make([]interface{}, 0, len(params))
+			for _, name := range params {
+				list = append(list, map[string]interface{}{
+					"name":     name,
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+			op["parameters"] = list
+		}
+
+		for i, e := range examples {
+			name := "recorded-" + strconv.Itoa(i+1)
+			if len(e.RequestBody) > 0 {
+				content := openAPIObject(openAPIObject(op, "requestBody"), "content")
+				addOpenAPIExample(content, e.RequestHeaders, name, e.RequestBody)
+			}
+			response := openAPIObject(openAPIObject(op, "responses"), strconv.Itoa(e.Status))
+			if _, ok := response["description"]; !ok {
+				response["description"] = http.StatusText(e.Status)
+			}
+			if len(e.ResponseBody) > 0 {
+				addOpenAPIExample(openAPIObject(response, "content"), e.ResponseHeaders, name, e.ResponseBody)
+			}
+		}
+	}
+}
+
+// ServeDocs responds with an HTML page listing the recorded examples
+func (r *ExampleRecorder) ServeDocs(ctx chef.Context) {
+	examples := r.Examples()
+	keys := make([]string, 0, len(examples))
+	for k := range examples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	data := struct {
+		Title  string
+		Routes []docsRoute
+	}{Title: r.options.Title}
+	for _, k := range keys {
+		data.Routes = append(data.Routes, docsRoute{Name: k, Examples: examples[k]})
+	}
+	ctx.SetHeader(chef.HeaderContentType, chef.MIMETextHTMLCharsetUTF8)
+	docsTemplate.Execute(ctx.Response(), data)
+}
+
+type docsRoute struct {
+	Name     string
+	Examples []Example
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body><h1>{{.Title}}</h1>
+<p><a href="_docs/openapi.json">OpenAPI document</a></p>
+{{range .Routes}}<section><h2>{{.Name}}</h2>{{range .Examples}}
+<article><h3>{{.Status}}{{with .Query}} ?{{.}}{{end}}</h3>{{with .RequestBody}}
+<h4>Request</h4><pre>{{printf "%s" .}}</pre>{{end}}{{with .ResponseBody}}
+<h4>Response</h4><pre>{{printf "%s" .}}</pre>{{end}}</article>{{end}}</section>
+{{else}}<p>No examples recorded yet.</p>{{end}}
+</body></html>`))
+
+// openAPIPath converts a route pattern to an OpenAPI path, returning the
+// names of its parameters
+func openAPIPath(route string) (string, []string) {
+	segments := strings.Split(route, "/")
+	var params []string
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		case seg == "*":
+			params = append(params, "path")
+			segments[i] = "{path}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// openAPIObject returns the object under key in m, adding it when missing
+func openAPIObject(m map[string]interface{}, key string) map[string]interface{} {
+	if child, ok := m[key].(map[string]interface{}); ok {
+		return child
+	}
+	child := map[string]interface{}{}
+	m[key] = child
+	return child
+}
+
+// addOpenAPIExample adds the body as a named example of the media type of
+// header to content
+func addOpenAPIExample(content map[string]interface{}, header map[string]string, name string, body json.RawMessage) {
+	mime := chef.MIMEApplicationJSON
+	if ct := header[chef.HeaderContentType]; ct != "" {
+		mime = strings.TrimSpace(strings.SplitN(ct, ";", 2)[0])
+	}
+	examples := openAPIObject(openAPIObject(content, mime), "examples")
+	examples[name] = map[string]interface{}{"value": body}
+}
+
+func (r *ExampleRecorder) headers(h http.Header) map[string]string {
+	out := map[string]string{}
+	for k, v := range h {
+		if len(v) == 0 {
+			continue
+		}
+		if r.redactHeaders[k] {
+			out[k] = redacted
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+func (r *ExampleRecorder) anonymizeQuery(q url.Values) string {
+	for k := range q {
+		if r.redactFields[strings.ToLower(k)] {
+			q[k] = []string{redacted}
+		}
+	}
+	return q.Encode()
+}
+
+// body returns the anonymized body as JSON. Non-JSON bodies are recorded
+// as a JSON string.
+func (r *ExampleRecorder) body(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	if len(b) > r.options.MaxBodySize {
+		b = b[:r.options.MaxBodySize]
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		s, _ := json.Marshal(string(b))
+		return s
+	}
+	out, err := json.Marshal(r.redact(v))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func (r *ExampleRecorder) redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if r.redactFields[strings.ToLower(k)] {
+				t[k] = redacted
+			} else {
+				t[k] = r.redact(val)
+			}
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = r.redact(val)
+		}
+	}
+	return v
+}
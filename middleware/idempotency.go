@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+// HeaderIdempotencyKey identifies retries of the same logical request
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+type (
+	// IdempotencyOptions is the configuration used to setup the idempotency middleware
+	IdempotencyOptions struct {
+		// TTL is how long a response is kept for replay. Default value is 24 hours
+		TTL time.Duration
+
+		// Store holds the recorded responses. By default the cache driver
+		// configured in [Cache] is used, or memory if caching is disabled.
+		Store Store
+
+		// Methods are the request methods the middleware applies to.
+		// Default value is POST
+		Methods []string
+
+		// Scope returns the identity keys are scoped to, so that a client
+		// can't replay the response of another one by guessing its key.
+		// Default value is the Authorization header, or else the session ID,
+		// or else the client IP
+		Scope func(ctx chef.Context) string
+	}
+
+	// Idempotency represents the middleware instance
+	Idempotency struct {
+		ttl     time.Duration
+		methods map[string]bool
+		scope   func(ctx chef.Context) string
+
+		lock     sync.Mutex
+		store    Store
+		inFlight map[string]bool
+	}
+)
+
+// NewIdempotency creates a new idempotency middleware instance with provided options
+func NewIdempotency(options IdempotencyOptions) *Idempotency {
+	i := &Idempotency{
+		ttl:      options.TTL,
+		store:    options.Store,
+		methods:  map[string]bool{},
+		scope:    options.Scope,
+		inFlight: map[string]bool{},
+	}
+	if i.ttl <= 0 {
+		i.ttl = 24 * time.Hour
+	}
+	if len(options.Methods) == 0 {
+		options.Methods = []string{http.MethodPost}
+	}
+	for _, m := range options.Methods {
+		i.methods[m] = true
+	}
+	if i.scope == nil {
+		i.scope = defaultIdempotencyScope
+	}
+
+	return i
+}
+
+// Handler replays the recorded response of a request already seen with the
+// same Idempotency-Key. A retry arriving while the first request is still
+// running gets a 409, one reusing the key for a different request a 422.
+func (i *Idempotency) Handler(ctx chef.Context) {
+	req := ctx.Request()
+	idemKey := req.Header.Get(HeaderIdempotencyKey)
+	if idemKey == "" || !i.methods[req.Method] {
+		ctx.Next()
+		return
+	}
+
+	body, err := ctx.Body()
+	if err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.WriteString("unable to read request body")
+		return
+	}
+	sum := sha256.Sum256(append([]byte(req.Method+" "+req.URL.RequestURI()+"\n"), body...))
+	fingerprint := hex.EncodeToString(sum[:])
+	scope := sha256.Sum256([]byte(i.scope(ctx)))
+	key := "idempotency:" + hex.EncodeToString(scope[:8]) + ":" + idemKey
+
+	store := i.getStore(ctx)
+	res := ctx.Response()
+
+	// the store is checked under the same lock as the in-flight slot is
+	// claimed, so a retry racing the end of the first request replays it
+	// instead of running again
+	i.lock.Lock()
+	if i.inFlight[key] {
+		i.lock.Unlock()
+		ctx.SetStatusCode(http.StatusConflict)
+		ctx.WriteString("a request with this idempotency key is in progress")
+		return
+	}
+	if b, ok := store.Get(key); ok {
+		if recorded, ok := decodeCachedResponse(b); ok {
+			i.lock.Unlock()
+			if recorded.Fingerprint != fingerprint {
+				ctx.SetStatusCode(http.StatusUnprocessableEntity)
+				ctx.WriteString("idempotency key reused for a different request")
+				return
+			}
+			res.Header().Set("Idempotent-Replayed", "true")
+			recorded.write(res)
+			return
+		}
+	}
+	i.inFlight[key] = true
+	i.lock.Unlock()
+
+	defer func() {
+		i.lock.Lock()
+		delete(i.inFlight, key)
+		i.lock.Unlock()
+	}()
+
+	w := &captureWriter{ResponseWriter: res.Writer}
+	res.Writer = w
+	ctx.Next()
+	res.Writer = w.ResponseWriter
+
+	// server errors are not recorded so the client can retry them
+	if res.Status >= http.StatusInternalServerError {
+		return
+	}
+	recorded := &cachedResponse{
+		Status:      res.Status,
+		Header:      res.Header().Clone(),
+		Body:        w.body.Bytes(),
+		Stored:      time.Now(),
+		Expires:     time.Now().Add(i.ttl),
+		Fingerprint: fingerprint,
+	}
+	store.Set(key, recorded.encode(), i.ttl)
+}
+
+// defaultIdempotencyScope returns the credentials of the request, its session
+// ID or the client IP, whichever is found first
+func defaultIdempotencyScope(ctx chef.Context) string {
+	if auth := ctx.Request().Header.Get(chef.HeaderAuthorization); auth != "" {
+		return "auth:" + auth
+	}
+	if sess := ctx.Session(); sess != nil && sess.ID() != "" {
+		return "session:" + sess.ID()
+	}
+	return "ip:" + ctx.RealIP()
+}
+
+func (i *Idempotency) getStore(ctx chef.Context) Store {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.store == nil {
+		if c := ctx.Cache(); c != nil {
+			i.store = CacheStore(c)
+		} else {
+			i.store = NewMemoryStore()
+		}
+	}
+	return i.store
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// ConcurrencyOption configures the concurrency limiter middleware
+	ConcurrencyOption func(*ConcurrencyLimiter)
+
+	// ConcurrencyLimiter represents the concurrency limiter middleware instance
+	ConcurrencyLimiter struct {
+		limit    int
+		queue    time.Duration
+		maxQueue int
+		trusted  func(ctx chef.Context) bool
+
+		lock    sync.Mutex
+		running int
+		queued  int
+
+		// waiters holds the queued requests by urgency, first come first
+		// served. Each is told whether it got a slot or was shed.
+		waiters [chef.UrgencyLowest + 1][]chan bool
+	}
+)
+
+// TrustPriority sets which requests may raise their urgency with the Priority
+// header, i.e. authenticated API clients or internal callers. Others may only
+// lower it. By default requests with a verified client certificate are
+// trusted.
+func TrustPriority(fn func(ctx chef.Context) bool) ConcurrencyOption {
+	return func(l *ConcurrencyLimiter) {
+		l.trusted = fn
+	}
+}
+
+// MaxQueue bounds the number of requests waiting for a slot. Once it's full a
+// request more urgent than the least urgent waiter takes its place, shedding
+// that one, otherwise the request itself is shed. Zero means no bound.
+func MaxQueue(n int) ConcurrencyOption {
+	return func(l *ConcurrencyLimiter) {
+		l.maxQueue = n
+	}
+}
+
+// NewConcurrencyLimiter creates a limiter running at most n requests at once.
+// Requests over the limit wait up to queue for a slot. Freed slots go to the
+// queued request with the most urgent Priority header first.
+func NewConcurrencyLimiter(n int, queue time.Duration, opts ...ConcurrencyOption) *ConcurrencyLimiter {
+	if n <= 0 {
+		panic("chef: concurrency limit must be positive")
+	}
+	l := &ConcurrencyLimiter{
+		limit:   n,
+		queue:   queue,
+		trusted: trustedPriority,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// MaxInFlight returns a middleware bounding the number of concurrently
+// executing requests, answering 503 once the limit and queue are exceeded
+func MaxInFlight(n int, queue time.Duration, opts ...ConcurrencyOption) chef.Handler {
+	return NewConcurrencyLimiter(n, queue, opts...).Handler
+}
+
+// InFlight returns the number of requests currently running
+func (l *ConcurrencyLimiter) InFlight() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.running
+}
+
+// Handler runs the request once a slot is free
+func (l *ConcurrencyLimiter) Handler(ctx chef.Context) {
+	if !l.acquire(ctx) {
+		ctx.SetHeader("Retry-After", "1")
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.WriteString("server overloaded")
+		return
+	}
+	defer l.release()
+
+	ctx.Next()
+}
+
+// urgency returns the urgency the request is queued with. Untrusted requests
+// can't be more urgent than the default.
+func (l *ConcurrencyLimiter) urgency(ctx chef.Context) int {
+	urgency := ctx.Priority().Urgency
+	if urgency < chef.UrgencyDefault && (l.trusted == nil || !l.trusted(ctx)) {
+		return chef.UrgencyDefault
+	}
+	return urgency
+}
+
+func (l *ConcurrencyLimiter) acquire(ctx chef.Context) bool {
+	l.lock.Lock()
+	if l.running < l.limit {
+		l.running++
+		l.lock.Unlock()
+		return true
+	}
+	if l.queue <= 0 {
+		l.lock.Unlock()
+		return false
+	}
+
+	urgency := l.urgency(ctx)
+	if l.maxQueue > 0 && l.queued >= l.maxQueue && !l.shed(urgency) {
+		l.lock.Unlock()
+		return false
+	}
+	ready := make(chan bool, 1)
+	l.waiters[urgency] = append(l.waiters[urgency], ready)
+	l.queued++
+	l.lock.Unlock()
+
+	timer := time.NewTimer(l.queue)
+	defer timer.Stop()
+	select {
+	case ok := <-ready:
+		return ok
+	case <-timer.C:
+	case <-ctx.Request().Context().Done():
+	}
+
+	l.lock.Lock()
+	for i, w := range l.waiters[urgency] {
+		if w == ready {
+			l.waiters[urgency] = append(l.waiters[urgency][:i], l.waiters[urgency][i+1:]...)
+			l.queued--
+			l.lock.Unlock()
+			return false
+		}
+	}
+	l.lock.Unlock()
+	// the slot was handed over or the request shed while giving up
+	return <-ready
+}
+
+// shed drops the newest of the least urgent waiters when it's less urgent
+// than urgency, the lock must be held
+func (l *ConcurrencyLimiter) shed(urgency int) bool {
+	for u := chef.UrgencyLowest; u > urgency; u-- {
+		if n := len(l.waiters[u]); n > 0 {
+			l.waiters[u][n-1] <- false
+			l.waiters[u] = l.waiters[u][:n-1]
+			l.queued--
+			return true
+		}
+	}
+	return false
+}
+
+// release hands the slot to the most urgent waiter, if any
+func (l *ConcurrencyLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for u := range l.waiters {
+		if len(l.waiters[u]) > 0 {
+			ready := l.waiters[u][0]
+			l.waiters[u] = l.waiters[u][1:]
+			l.queued--
+			ready <- true
+			return
+		}
+	}
+	l.running--
+}
+
+// trustedPriority trusts the Priority header of clients authenticated with a
+// verified certificate
+func trustedPriority(ctx chef.Context) bool {
+	return ctx.ClientCertificate() != nil
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+)
+
+// parseNetworks parses a list of IPs and CIDR ranges. Plain IPs are turned
+// into single address networks.
+func parseNetworks(list []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(list))
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				panic("chef: invalid IP " + entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			panic("chef: invalid CIDR " + entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// containsIP checks if ip belongs to any of the networks
+func containsIP(networks []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
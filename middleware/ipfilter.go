@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gochef/chef"
+)
+
+// IPFilter returns a middleware rejecting clients with a 403 based on their
+// IP. Entries are IPs or CIDR ranges. Denied networks always win; when allow
+// is not empty, only clients in it get through.
+func IPFilter(allow, deny []string) chef.Handler {
+	allowed := parseNetworks(allow)
+	denied := parseNetworks(deny)
+
+	return func(ctx chef.Context) {
+		ip := ctx.RealIP()
+		if containsIP(denied, ip) || (len(allowed) > 0 && !containsIP(allowed, ip)) {
+			ctx.SetStatusCode(http.StatusForbidden)
+			ctx.WriteString("forbidden")
+			return
+		}
+		ctx.Next()
+	}
+}
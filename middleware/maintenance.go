@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+const (
+	defaultMaintenancePage = "<!DOCTYPE html><html><head><title>Down for maintenance</title></head>" +
+		"<body><h1>Down for maintenance</h1><p>We'll be back shortly.</p></body></html>"
+)
+
+type (
+	// MaintenanceOptions is the configuration used to setup the maintenance middleware
+	MaintenanceOptions struct {
+		// FlagFile turns maintenance on while the file exists
+		FlagFile string
+
+		// Enabled turns maintenance on while it returns true, i.e. to follow a
+		// config key or feature flag
+		Enabled func() bool
+
+		// RetryAfter is sent in the Retry-After header. Default value is 5 minutes
+		RetryAfter time.Duration
+
+		// HTML is the page sent to browsers
+		HTML string
+
+		// JSON is the payload sent to clients accepting JSON
+		JSON interface{}
+
+		// AllowIPs lists IPs and CIDR ranges that bypass maintenance
+		AllowIPs []string
+
+		// AllowPaths lists path prefixes that stay reachable, i.e. health checks
+		AllowPaths []string
+	}
+
+	// MaintenanceMode represents the middleware instance
+	MaintenanceMode struct {
+		options  MaintenanceOptions
+		allowIPs []*net.IPNet
+		toggle   int32
+
+		fileLock    sync.Mutex
+		fileChecked time.Time
+		fileExists  bool
+	}
+)
+
+// NewMaintenance creates a new maintenance middleware instance with provided options
+func NewMaintenance(options MaintenanceOptions) *MaintenanceMode {
+	if options.RetryAfter <= 0 {
+		options.RetryAfter = 5 * time.Minute
+	}
+	if options.HTML == "" {
+		options.HTML = defaultMaintenancePage
+	}
+	if options.JSON == nil {
+		options.JSON = map[string]string{"error": "down for maintenance"}
+	}
+
+	return &MaintenanceMode{
+		options:  options,
+		allowIPs: parseNetworks(options.AllowIPs),
+	}
+}
+
+// Maintenance returns a maintenance middleware with provided options
+func Maintenance(options MaintenanceOptions) chef.Handler {
+	return NewMaintenance(options).Handler
+}
+
+// Enable turns maintenance on at runtime
+func (m *MaintenanceMode) Enable() {
+	atomic.StoreInt32(&m.toggle, 1)
+}
+
+// Disable turns the runtime toggle off. Maintenance stays on if the flag file
+// or Enabled still say so.
+func (m *MaintenanceMode) Disable() {
+	atomic.StoreInt32(&m.toggle, 0)
+}
+
+// Active reports whether maintenance is currently on
+func (m *MaintenanceMode) Active() bool {
+	if atomic.LoadInt32(&m.toggle) == 1 {
+		return true
+	}
+	if m.options.Enabled != nil && m.options.Enabled() {
+		return true
+	}
+	return m.flagFileExists()
+}
+
+// Handler answers 503 while maintenance is active, except for allowed clients and paths
+func (m *MaintenanceMode) Handler(ctx chef.Context) {
+	if !m.Active() || m.allowed(ctx) {
+		ctx.Next()
+		return
+	}
+
+	ctx.SetHeader("Retry-After", strconv.Itoa(int(m.options.RetryAfter/time.Second)))
+	ctx.SetHeader(chef.HeaderCacheControl, chef.CacheControlNoStore)
+	if strings.Contains(ctx.Request().Header.Get(chef.HeaderAccept), chef.MIMEApplicationJSON) {
+		ctx.SetHeader(chef.HeaderContentType, chef.MIMEApplicationJSONCharsetUTF8)
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.JSON(m.options.JSON)
+		return
+	}
+
+	ctx.SetHeader(chef.HeaderContentType, chef.MIMETextHTMLCharsetUTF8)
+	ctx.SetStatusCode(http.StatusServiceUnavailable)
+	ctx.WriteString(m.options.HTML)
+}
+
+func (m *MaintenanceMode) allowed(ctx chef.Context) bool {
+	path := ctx.Request().URL.Path
+	for _, p := range m.options.AllowPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return containsIP(m.allowIPs, ctx.RealIP())
+}
+
+// flagFileExists checks the flag file at most once per second
+func (m *MaintenanceMode) flagFileExists() bool {
+	if m.options.FlagFile == "" {
+		return false
+	}
+
+	m.fileLock.Lock()
+	defer m.fileLock.Unlock()
+	if time.Since(m.fileChecked) >= time.Second {
+		_, err := os.Stat(m.options.FlagFile)
+		m.fileExists = err == nil
+		m.fileChecked = time.Now()
+	}
+	return m.fileExists
+}
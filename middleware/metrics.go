@@ -0,0 +1,226 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// MetricsOptions is the configuration used to setup the metrics middleware
+	MetricsOptions struct {
+		// Namespace prefixes every metric name. Default value is "chef"
+		Namespace string
+
+		// DurationBuckets are the upper bounds in seconds of the request
+		// duration histogram. Default value is 5ms to 10s
+		DurationBuckets []float64
+
+		// SizeBuckets are the upper bounds in bytes of the response size
+		// histogram. Default value is 100B to 10MB
+		SizeBuckets []float64
+	}
+
+	// MetricsCollector represents the middleware instance. It records the
+	// requests and exposes them in the Prometheus text format.
+	MetricsCollector struct {
+		options  MetricsOptions
+		inFlight int64
+
+		lock   sync.Mutex
+		series map[metricLabels]*metricSeries
+	}
+
+	metricLabels struct {
+		method string
+		route  string
+		status string
+	}
+
+	metricSeries struct {
+		count    uint64
+		duration *histogram
+		size     *histogram
+	}
+
+	histogram struct {
+		bounds []float64
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+)
+
+var (
+	defaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	defaultSizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+	// DefaultMetrics is the collector used by Metrics and MetricsEndpoint
+	DefaultMetrics = NewMetricsCollector(MetricsOptions{})
+)
+
+// NewMetricsCollector creates a new metrics middleware instance with provided options
+func NewMetricsCollector(options MetricsOptions) *MetricsCollector {
+	if options.Namespace == "" {
+		options.Namespace = "chef"
+	}
+	if len(options.DurationBuckets) == 0 {
+		options.DurationBuckets = defaultDurationBuckets
+	}
+	if len(options.SizeBuckets) == 0 {
+		options.SizeBuckets = defaultSizeBuckets
+	}
+	sort.Float64s(options.DurationBuckets)
+	sort.Float64s(options.SizeBuckets)
+
+	return &MetricsCollector{
+		options: options,
+		series:  map[metricLabels]*metricSeries{},
+	}
+}
+
+// Metrics returns a middleware recording requests into DefaultMetrics
+func Metrics() chef.Handler {
+	return DefaultMetrics.Handler
+}
+
+// MetricsEndpoint returns a handler exposing DefaultMetrics. It is opt-in:
+//
+//	app.GET("/metrics", middleware.MetricsEndpoint())
+func MetricsEndpoint() chef.Handler {
+	return DefaultMetrics.Expose
+}
+
+// Handler records count, duration and size of the request labeled by method,
+// route pattern and status class
+func (m *MetricsCollector) Handler(ctx chef.Context) {
+	atomic.AddInt64(&m.inFlight, 1)
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&m.inFlight, -1)
+		m.observe(ctx, time.Since(start))
+	}()
+
+	ctx.Next()
+}
+
+func (m *MetricsCollector) observe(ctx chef.Context, elapsed time.Duration) {
+	res := ctx.Response()
+	route := ctx.Path()
+	if route == "" {
+		route = "unmatched"
+	}
+	labels := metricLabels{
+		method: ctx.Request().Method,
+		route:  route,
+		status: strconv.Itoa(res.Status/100) + "xx",
+	}
+
+	m.lock.Lock()
+	s, ok := m.series[labels]
+	if !ok {
+		s = &metricSeries{
+			duration: newHistogram(m.options.DurationBuckets),
+			size:     newHistogram(m.options.SizeBuckets),
+		}
+		m.series[labels] = s
+	}
+	s.count++
+	s.duration.observe(elapsed.Seconds())
+	s.size.observe(float64(res.Size))
+	m.lock.Unlock()
+}
+
+// Expose writes the collected metrics in the Prometheus text format. Register
+// it on the route of your choosing, usually /metrics.
+func (m *MetricsCollector) Expose(ctx chef.Context) {
+	ctx.SetHeader(chef.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	ctx.Write(m.gather())
+}
+
+func (m *MetricsCollector) gather() []byte {
+	ns := m.options.Namespace
+	var b bytes.Buffer
+
+	m.lock.Lock()
+	keys := make([]metricLabels, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	fmt.Fprintf(&b, "# HELP %s_http_requests_total Total number of HTTP requests.\n", ns)
+	fmt.Fprintf(&b, "# TYPE %s_http_requests_total counter\n", ns)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s_http_requests_total{%s} %d\n", ns, k, m.series[k].count)
+	}
+
+	fmt.Fprintf(&b, "# HELP %s_http_request_duration_seconds HTTP request latencies in seconds.\n", ns)
+	fmt.Fprintf(&b, "# TYPE %s_http_request_duration_seconds histogram\n", ns)
+	for _, k := range keys {
+		m.series[k].duration.write(&b, ns+"_http_request_duration_seconds", k.String())
+	}
+
+	fmt.Fprintf(&b, "# HELP %s_http_response_size_bytes HTTP response sizes in bytes.\n", ns)
+	fmt.Fprintf(&b, "# TYPE %s_http_response_size_bytes histogram\n", ns)
+	for _, k := range keys {
+		m.series[k].size.write(&b, ns+"_http_response_size_bytes", k.String())
+	}
+	m.lock.Unlock()
+
+	fmt.Fprintf(&b, "# HELP %s_http_requests_in_flight Number of HTTP requests being served.\n", ns)
+	fmt.Fprintf(&b, "# TYPE %s_http_requests_in_flight gauge\n", ns)
+	fmt.Fprintf(&b, "%s_http_requests_in_flight %d\n", ns, atomic.LoadInt64(&m.inFlight))
+
+	return b.Bytes()
+}
+
+func (l metricLabels) String() string {
+	return `method="` + escapeLabel(l.method) + `",route="` + escapeLabel(l.route) + `",status="` + l.status + `"`
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(b *bytes.Buffer, name, labels string) {
+	for i, bound := range h.bounds {
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(b, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, h.count)
+}
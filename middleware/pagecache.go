@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// PageCacheOption configures the page cache middleware
+	PageCacheOption func(*PageCache)
+
+	// PageCache represents the page cache middleware instance
+	PageCache struct {
+		ttl    time.Duration
+		vary   []string
+		prefix string
+
+		lock   sync.Mutex
+		store  Store
+		keys   map[string]map[string]time.Time
+		pruned time.Time
+	}
+
+	// captureWriter records the body written through it
+	captureWriter struct {
+		http.ResponseWriter
+		body bytes.Buffer
+	}
+)
+
+// VaryBy adds request headers whose values select distinct cache entries.
+// Accept-Encoding is always included, so compressed and plain bodies are never
+// mixed up.
+func VaryBy(headers ...string) PageCacheOption {
+	return func(p *PageCache) {
+		for _, h := range headers {
+			if h = http.CanonicalHeaderKey(h); !p.varies(h) {
+				p.vary = append(p.vary, h)
+			}
+		}
+		sort.Strings(p.vary)
+	}
+}
+
+// WithStore sets the store cached pages are kept in. By default the cache
+// driver configured in [Cache] is used, or memory if caching is disabled.
+func WithStore(store Store) PageCacheOption {
+	return func(p *PageCache) {
+		p.store = store
+	}
+}
+
+// WithKeyPrefix namespaces the cache keys, useful when several page caches
+// share a store
+func WithKeyPrefix(prefix string) PageCacheOption {
+	return func(p *PageCache) {
+		p.prefix = prefix
+	}
+}
+
+// NewPageCache creates a new page cache instance caching responses for ttl
+func NewPageCache(ttl time.Duration, opts ...PageCacheOption) *PageCache {
+	p := &PageCache{
+		ttl:    ttl,
+		vary:   []string{chef.HeaderAcceptEncoding},
+		prefix: "page:",
+		keys:   map[string]map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// CachePage returns a middleware caching full GET responses for ttl
+func CachePage(ttl time.Duration, opts ...PageCacheOption) chef.Handler {
+	return NewPageCache(ttl, opts...).Handler
+}
+
+// Handler serves GET requests from the cache, filling it on misses
+func (p *PageCache) Handler(ctx chef.Context) {
+	req := ctx.Request()
+	if req.Method != http.MethodGet {
+		ctx.Next()
+		return
+	}
+
+	store := p.getStore(ctx)
+	key := p.key(req)
+	res := ctx.Response()
+
+	if b, ok := store.Get(key); ok {
+		if cached, ok := decodeCachedResponse(b); ok {
+			res.Header().Set(HeaderXCache, "HIT")
+			cached.write(res)
+			return
+		}
+	}
+
+	w := &captureWriter{ResponseWriter: res.Writer}
+	res.Writer = w
+	defer func() {
+		res.Writer = w.ResponseWriter
+	}()
+	res.Header().Set(HeaderXCache, "MISS")
+	ctx.Next()
+
+	if !p.cacheable(res) {
+		return
+	}
+
+	now := time.Now()
+	header := res.Header().Clone()
+	header.Del(HeaderXCache)
+	cached := &cachedResponse{
+		Status:  res.Status,
+		Header:  header,
+		Body:    w.body.Bytes(),
+		Stored:  now,
+		Expires: now.Add(p.ttl),
+	}
+	store.Set(key, cached.encode(), p.ttl)
+
+	p.lock.Lock()
+	if p.keys[req.URL.Path] == nil {
+		p.keys[req.URL.Path] = map[string]time.Time{}
+	}
+	p.keys[req.URL.Path][key] = cached.Expires
+	if now.Sub(p.pruned) >= p.ttl {
+		p.prune(now)
+	}
+	p.lock.Unlock()
+}
+
+// prune forgets the keys the store has expired by now, the lock must be held
+func (p *PageCache) prune(now time.Time) {
+	for path, keys := range p.keys {
+		for key, expires := range keys {
+			if !now.Before(expires) {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(p.keys, path)
+		}
+	}
+	p.pruned = now
+}
+
+// Invalidate drops every cached variant of path
+func (p *PageCache) Invalidate(path string) {
+	p.lock.Lock()
+	keys := p.keys[path]
+	delete(p.keys, path)
+	store := p.store
+	p.lock.Unlock()
+
+	if store == nil {
+		return
+	}
+	for key := range keys {
+		store.Delete(key)
+	}
+}
+
+// InvalidateAll drops every page cached by this instance
+func (p *PageCache) InvalidateAll() {
+	p.lock.Lock()
+	paths := make([]string, 0, len(p.keys))
+	for path := range p.keys {
+		paths = append(paths, path)
+	}
+	p.lock.Unlock()
+
+	for _, path := range paths {
+		p.Invalidate(path)
+	}
+}
+
+func (p *PageCache) getStore(ctx chef.Context) Store {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.store == nil {
+		if c := ctx.Cache(); c != nil {
+			p.store = CacheStore(c)
+		} else {
+			p.store = NewMemoryStore()
+		}
+	}
+	return p.store
+}
+
+func (p *PageCache) varies(header string) bool {
+	for _, h := range p.vary {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PageCache) key(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(p.prefix)
+	b.WriteString(req.URL.RequestURI())
+	for _, h := range p.vary {
+		b.WriteString("|" + h + "=" + req.Header.Get(h))
+	}
+	return b.String()
+}
+
+func (p *PageCache) cacheable(res *chef.Response) bool {
+	if res.Status != http.StatusOK || res.Header().Get(chef.HeaderSetCookie) != "" {
+		return false
+	}
+	cc := parseCacheControl(res.Header().Get(chef.HeaderCacheControl))
+	for _, d := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := cc[d]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
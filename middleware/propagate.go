@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	stdctx "context"
+	"net/http"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	propagateKey struct{}
+
+	propagatingTransport struct {
+		base http.RoundTripper
+	}
+)
+
+var defaultPropagatedHeaders = []string{chef.HeaderXRequestID, HeaderTraceparent, "tracestate"}
+
+// PropagateHeaders returns a middleware capturing the named incoming headers,
+// by default the request ID and trace context. They are stored in the context
+// under "propagated_headers" and in the request context, where
+// PropagatingTransport picks them up for outbound requests.
+func PropagateHeaders(names ...string) chef.Handler {
+	if len(names) == 0 {
+		names = defaultPropagatedHeaders
+	}
+
+	return func(ctx chef.Context) {
+		req := ctx.Request()
+		h := http.Header{}
+		for _, name := range names {
+			if values := req.Header.Values(name); len(values) > 0 {
+				h[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+			}
+		}
+
+		ctx.Set("propagated_headers", h)
+		ctx.SetRequest(req.WithContext(stdctx.WithValue(req.Context(), propagateKey{}, h)))
+		ctx.Next()
+	}
+}
+
+// PropagatedHeaders returns the headers captured by PropagateHeaders for the
+// request context c
+func PropagatedHeaders(c stdctx.Context) http.Header {
+	h, _ := c.Value(propagateKey{}).(http.Header)
+	return h
+}
+
+// PropagatingTransport wraps base, http.DefaultTransport if nil, to add the
+// captured headers to outbound requests made with a request context derived
+// from the incoming one. Headers already set on the request are kept.
+func PropagatingTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &propagatingTransport{base: base}
+}
+
+func (t *propagatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := PropagatedHeaders(req.Context())
+	if len(h) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	for name, values := range h {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	stdctx "context"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gochef/chef"
+)
+
+// Load balancing strategies
+const (
+	BalanceRoundRobin = "round-robin"
+	BalanceRandom     = "random"
+)
+
+type (
+	// ProxyConfig is the configuration used to setup the reverse proxy middleware
+	ProxyConfig struct {
+		// Targets are the base URLs of the upstream servers
+		Targets []string
+
+		// Balancer picks the target of each request, either BalanceRoundRobin
+		// or BalanceRandom. Default value is BalanceRoundRobin
+		Balancer string
+
+		// StripPrefix is removed from the request path before forwarding
+		StripPrefix string
+
+		// Rewrite maps the request path to the upstream path, after StripPrefix
+		Rewrite func(path string) string
+
+		// ErrorHandler is called when the upstream can't be reached.
+		// Default responds with 502 Bad Gateway.
+		ErrorHandler func(ctx chef.Context, err error)
+
+		// Transport is used to reach the upstreams. Default value is http.DefaultTransport
+		Transport http.RoundTripper
+
+		// Egress is the policy applied to upstream connections. Every target
+		// must be allowed by it. When Transport is set, the policy wraps it
+		// and pins are not checked.
+		Egress *EgressPolicy
+	}
+
+	proxyTarget struct {
+		url   *url.URL
+		proxy *httputil.ReverseProxy
+	}
+
+	proxyContextKey struct{}
+)
+
+// Proxy returns a middleware forwarding requests to the configured upstreams
+func Proxy(config ProxyConfig) chef.Handler {
+	if len(config.Targets) == 0 {
+		panic("chef: proxy middleware requires at least one target")
+	}
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = func(ctx chef.Context, err error) {
+			ctx.SetStatusCode(http.StatusBadGateway)
+			ctx.WriteString("bad gateway")
+		}
+	}
+
+	if config.Egress != nil {
+		if config.Transport == nil {
+			config.Transport = config.Egress.Transport()
+		} else {
+			config.Transport = config.Egress.Wrap(config.Transport)
+		}
+	}
+
+	targets := make([]*proxyTarget, 0, len(config.Targets))
+	for _, t := range config.Targets {
+		u, err := url.Parse(t)
+		if err != nil || u.Host == "" {
+			panic("chef: invalid proxy target " + t)
+		}
+		if config.Egress != nil && !config.Egress.Allowed(u.Hostname()) {
+			panic("chef: proxy target " + t + " denied by egress policy")
+		}
+		targets = append(targets, newProxyTarget(u, config))
+	}
+
+	var next uint32
+	pick := func() *proxyTarget {
+		if config.Balancer == BalanceRandom {
+			return targets[rand.Intn(len(targets))]
+		}
+		return targets[int(atomic.AddUint32(&next, 1)-1)%len(targets)]
+	}
+
+	return func(ctx chef.Context) {
+		req := ctx.Request()
+		req = req.WithContext(stdctx.WithValue(req.Context(), proxyContextKey{}, ctx))
+		pick().proxy.ServeHTTP(ctx.Response(), req)
+	}
+}
+
+func newProxyTarget(u *url.URL, config ProxyConfig) *proxyTarget {
+	t := &proxyTarget{url: u}
+	t.proxy = &httputil.ReverseProxy{
+		Transport: config.Transport,
+		Director: func(req *http.Request) {
+			path := req.URL.Path
+			if config.StripPrefix != "" {
+				path = strings.TrimPrefix(path, config.StripPrefix)
+				if !strings.HasPrefix(path, "/") {
+					path = "/" + path
+				}
+			}
+			if config.Rewrite != nil {
+				path = config.Rewrite(path)
+			}
+
+			proto := "http"
+			if req.TLS != nil {
+				proto = "https"
+			}
+			req.Header.Set(chef.HeaderXForwardedProto, proto)
+			req.Header.Set("X-Forwarded-Host", req.Host)
+
+			req.URL.Scheme = u.Scheme
+			req.URL.Host = u.Host
+			req.URL.Path = strings.TrimSuffix(u.Path, "/") + path
+			req.URL.RawPath = ""
+			req.Host = u.Host
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			ctx, _ := req.Context().Value(proxyContextKey{}).(chef.Context)
+			if ctx == nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			config.ErrorHandler(ctx, err)
+		},
+	}
+	return t
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// RateLimitOptions is the configuration used to setup the rate limiting
+	// middleware
+	RateLimitOptions struct {
+		// Requests is the number of requests a client may make per Period
+		Requests int
+
+		// Period is the window Requests apply to. Default value is 1m
+		Period chef.Duration
+
+		// Burst is how many requests a client may make at once. Default
+		// value is Requests
+		Burst int
+
+		// KeyFunc identifies the client. Default value is the RealIP, which
+		// honors the forwarded headers of trusted proxies only, see [Proxy]
+		KeyFunc func(ctx chef.Context) string
+	}
+
+	// RateLimiter represents the rate limiting middleware instance, a token
+	// bucket per client
+	RateLimiter struct {
+		options RateLimitOptions
+		rate    float64 // tokens per second
+
+		lock    sync.Mutex
+		buckets map[string]*bucket
+		swept   time.Time
+	}
+
+	bucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+// NewRateLimiter creates a rate limiter with provided options
+func NewRateLimiter(options RateLimitOptions) *RateLimiter {
+	if options.Requests <= 0 {
+		panic("chef: rate limit requests must be positive")
+	}
+	period := options.Period.Or(time.Minute)
+	if options.Burst <= 0 {
+		options.Burst = options.Requests
+	}
+	if options.KeyFunc == nil {
+		options.KeyFunc = func(ctx chef.Context) string {
+			return ctx.RealIP()
+		}
+	}
+	return &RateLimiter{
+		options: options,
+		rate:    float64(options.Requests) / period.Seconds(),
+		buckets: map[string]*bucket{},
+		swept:   time.Now(),
+	}
+}
+
+// RateLimit returns a middleware answering 429 to clients over the limit
+func RateLimit(options RateLimitOptions) chef.Handler {
+	return NewRateLimiter(options).Handler
+}
+
+// Handler runs the request if the client has requests left
+func (l *RateLimiter) Handler(ctx chef.Context) {
+	remaining, wait := l.take(l.options.KeyFunc(ctx), time.Now())
+	ctx.SetHeader("X-RateLimit-Limit", strconv.Itoa(l.options.Requests))
+	ctx.SetHeader("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if wait > 0 {
+		ctx.SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		ctx.SetStatusCode(http.StatusTooManyRequests)
+		ctx.WriteString("too many requests")
+		return
+	}
+	ctx.Next()
+}
+
+// take spends a token of the client bucket, returning the tokens left or how
+// long until the next one
+func (l *RateLimiter) take(key string, now time.Time) (int, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.options.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.options.Burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return 0, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return int(b.tokens), 0
+}
+
+// sweep drops the buckets refilled to the brim at most once a minute, the
+// lock must be held
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	full := float64(l.options.Burst) / l.rate
+	for key, b := range l.buckets {
+		if now.Sub(b.last).Seconds() >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
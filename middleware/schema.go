@@ -0,0 +1,382 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// Schema is a compiled JSON Schema document. The validation vocabulary
+	// is supported for the common keywords: type, enum, const, properties,
+	// required, additionalProperties, items, the numeric, string and array
+	// bounds, pattern, allOf, anyOf, oneOf, not and local $ref.
+	Schema struct {
+		root     map[string]interface{}
+		patterns map[string]*regexp.Regexp
+	}
+
+	// SchemaViolation is a value failing a schema keyword
+	SchemaViolation struct {
+		// Path is the JSON pointer of the failing value
+		Path    string `json:"path"`
+		Message string `json:"message"`
+	}
+)
+
+// LoadSchema reads and compiles the JSON Schema in file
+func LoadSchema(file string) (*Schema, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchema(b)
+}
+
+// ParseSchema compiles a JSON Schema document
+func ParseSchema(doc []byte) (*Schema, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+	s := &Schema{root: root, patterns: map[string]*regexp.Regexp{}}
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ValidateSchema returns a middleware validating request bodies against the
+// schema files keyed by route pattern, optionally prefixed by a method
+// ("POST /users"). Invalid bodies get a 400 listing the violations. Schemas
+// are loaded when the middleware is created.
+func ValidateSchema(files map[string]string) chef.Handler {
+	schemas := make(map[string]*Schema, len(files))
+	for route, file := range files {
+		s, err := LoadSchema(file)
+		if err != nil {
+			panic("chef: invalid schema " + file + ": " + err.Error())
+		}
+		schemas[route] = s
+	}
+
+	return func(ctx chef.Context) {
+		s, ok := schemas[ctx.Request().Method+" "+ctx.Path()]
+		if !ok {
+			s, ok = schemas[ctx.Path()]
+		}
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		body, err := ctx.Body()
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		var violations []SchemaViolation
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			violations = []SchemaViolation{{Path: "", Message: "invalid JSON: " + err.Error()}}
+		} else {
+			violations = s.Validate(v)
+		}
+		if len(violations) > 0 {
+			ctx.SetHeader(chef.HeaderContentType, chef.MIMEApplicationJSONCharsetUTF8)
+			ctx.SetStatusCode(http.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{
+				"error":      "request body does not match schema",
+				"violations": violations,
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// Validate returns the violations of v, a value decoded from JSON with
+// json.Number or float64 numbers
+func (s *Schema) Validate(v interface{}) []SchemaViolation {
+	var out []SchemaViolation
+	s.validate(s.root, v, "", &out)
+	return out
+}
+
+func (s *Schema) compilePatterns(node interface{}) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if p, ok := n["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return err
+			}
+			s.patterns[p] = re
+		}
+		for _, child := range n {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range n {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve follows a local reference such as "#/$defs/address"
+func (s *Schema) resolve(ref string) (map[string]interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	var node interface{} = s.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		node = m[part]
+	}
+	m, ok := node.(map[string]interface{})
+	return m, ok
+}
+
+func (s *Schema) validate(schema interface{}, v interface{}, path string, out *[]SchemaViolation) {
+	switch sc := schema.(type) {
+	case bool:
+		if !sc {
+			*out = append(*out, SchemaViolation{path, "no value is allowed here"})
+		}
+		return
+	case map[string]interface{}:
+		s.validateObject(sc, v, path, out)
+	}
+}
+
+func (s *Schema) validateObject(sc map[string]interface{}, v interface{}, path string, out *[]SchemaViolation) {
+	fail := func(format string, args ...interface{}) {
+		*out = append(*out, SchemaViolation{path, fmt.Sprintf(format, args...)})
+	}
+
+	if ref, ok := sc["$ref"].(string); ok {
+		target, ok := s.resolve(ref)
+		if !ok {
+			fail("unresolvable reference %s", ref)
+			return
+		}
+		s.validateObject(target, v, path, out)
+	}
+
+	if t, ok := sc["type"]; ok && !matchesType(t, v) {
+		fail("must be of type %v", t)
+		return
+	}
+	if enum, ok := sc["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+	if c, ok := sc["const"]; ok && !jsonEqual(c, v) {
+		fail("must be %v", c)
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		s.validateProperties(sc, val, path, out)
+	case []interface{}:
+		if n, ok := number(sc["minItems"]); ok && float64(len(val)) < n {
+			fail("must have at least %v items", n)
+		}
+		if n, ok := number(sc["maxItems"]); ok && float64(len(val)) > n {
+			fail("must have at most %v items", n)
+		}
+		if unique, _ := sc["uniqueItems"].(bool); unique {
+			for i := range val {
+				for j := i + 1; j < len(val); j++ {
+					if jsonEqual(val[i], val[j]) {
+						fail("items must be unique")
+						i = len(val)
+						break
+					}
+				}
+			}
+		}
+		if items, ok := sc["items"]; ok {
+			for i, item := range val {
+				s.validate(items, item, path+"/"+strconv.Itoa(i), out)
+			}
+		}
+	case string:
+		l := float64(utf8.RuneCountInString(val))
+		if n, ok := number(sc["minLength"]); ok && l < n {
+			fail("must be at least %v characters long", n)
+		}
+		if n, ok := number(sc["maxLength"]); ok && l > n {
+			fail("must be at most %v characters long", n)
+		}
+		if p, ok := sc["pattern"].(string); ok && !s.patterns[p].MatchString(val) {
+			fail("must match %s", p)
+		}
+	default:
+		if f, ok := number(v); ok {
+			if n, ok := number(sc["minimum"]); ok && f < n {
+				fail("must be >= %v", n)
+			}
+			if n, ok := number(sc["maximum"]); ok && f > n {
+				fail("must be <= %v", n)
+			}
+			if n, ok := number(sc["exclusiveMinimum"]); ok && f <= n {
+				fail("must be > %v", n)
+			}
+			if n, ok := number(sc["exclusiveMaximum"]); ok && f >= n {
+				fail("must be < %v", n)
+			}
+			if n, ok := number(sc["multipleOf"]); ok && n != 0 {
+				if q := f / n; math.Abs(q-math.Round(q)) > 1e-9 {
+					fail("must be a multiple of %v", n)
+				}
+			}
+		}
+	}
+
+	if all, ok := sc["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			s.validate(sub, v, path, out)
+		}
+	}
+	if anyOf, ok := sc["anyOf"].([]interface{}); ok && s.countValid(anyOf, v, path) == 0 {
+		fail("must match at least one schema of anyOf")
+	}
+	if oneOf, ok := sc["oneOf"].([]interface{}); ok && s.countValid(oneOf, v, path) != 1 {
+		fail("must match exactly one schema of oneOf")
+	}
+	if not, ok := sc["not"]; ok && s.countValid([]interface{}{not}, v, path) == 1 {
+		fail("must not match the schema of not")
+	}
+}
+
+func (s *Schema) validateProperties(sc map[string]interface{}, obj map[string]interface{}, path string, out *[]SchemaViolation) {
+	if required, ok := sc["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := obj[name]; !ok {
+				*out = append(*out, SchemaViolation{path + "/" + escapePointer(name), "is required"})
+			}
+		}
+	}
+
+	props, _ := sc["properties"].(map[string]interface{})
+	for name, value := range obj {
+		p := path + "/" + escapePointer(name)
+		if sub, ok := props[name]; ok {
+			s.validate(sub, value, p, out)
+			continue
+		}
+		if additional, ok := sc["additionalProperties"]; ok {
+			if allowed, isBool := additional.(bool); isBool && !allowed {
+				*out = append(*out, SchemaViolation{p, "is not allowed"})
+				continue
+			}
+			s.validate(additional, value, p, out)
+		}
+	}
+}
+
+func (s *Schema) countValid(schemas []interface{}, v interface{}, path string) int {
+	valid := 0
+	for _, sub := range schemas {
+		var errs []SchemaViolation
+		s.validate(sub, v, path, &errs)
+		if len(errs) == 0 {
+			valid++
+		}
+	}
+	return valid
+}
+
+func matchesType(t interface{}, v interface{}) bool {
+	if list, ok := t.([]interface{}); ok {
+		for _, each := range list {
+			if matchesType(each, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := number(v)
+		return ok
+	case "integer":
+		f, ok := number(v)
+		return ok && f == math.Trunc(f)
+	}
+	return false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
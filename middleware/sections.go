@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/gochef/chef"
+)
+
+// the built-in middlewares enabled by their own config section, in the order
+// they run
+func init() {
+	chef.RegisterSectionMiddleware("Secure", func(config *chef.Config) (chef.Handler, error) {
+		var options SecureOptions
+		if err := config.Unmarshal("Secure", &options); err != nil {
+			return nil, err
+		}
+		return Secure(options), nil
+	})
+
+	chef.RegisterSectionMiddleware("CORS", func(config *chef.Config) (chef.Handler, error) {
+		var options CorsOptions
+		if err := config.Unmarshal("CORS", &options); err != nil {
+			return nil, err
+		}
+		return NewCors(options).Handler, nil
+	})
+
+	chef.RegisterSectionMiddleware("RateLimit", func(config *chef.Config) (chef.Handler, error) {
+		var options RateLimitOptions
+		if err := config.Unmarshal("RateLimit", &options); err != nil {
+			return nil, err
+		}
+		if options.Requests <= 0 {
+			return nil, errors.New("Requests must be positive")
+		}
+		return RateLimit(options), nil
+	})
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"strconv"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// SecureOptions is the configuration used to setup the security headers
+	// middleware
+	SecureOptions struct {
+		// FrameOptions is the X-Frame-Options value. Default value is
+		// "SAMEORIGIN"
+		FrameOptions string
+
+		// AllowSniffing omits X-Content-Type-Options: nosniff
+		AllowSniffing bool
+
+		// ReferrerPolicy is the Referrer-Policy value. Default value is
+		// "strict-origin-when-cross-origin"
+		ReferrerPolicy string
+
+		// HSTSMaxAge is how long in seconds browsers only use HTTPS for the
+		// host. Strict-Transport-Security is sent on HTTPS requests when set.
+		HSTSMaxAge            int
+		HSTSIncludeSubdomains bool
+		HSTSPreload           bool
+
+		// ContentSecurityPolicy is sent as is when set, see CSPNonce for
+		// nonce based policies
+		ContentSecurityPolicy string
+		CSPReportOnly         bool
+
+		// PermissionsPolicy is sent as is when set
+		PermissionsPolicy string
+	}
+)
+
+// Secure returns a middleware setting the security headers of every response
+func Secure(options SecureOptions) chef.Handler {
+	if options.FrameOptions == "" {
+		options.FrameOptions = "SAMEORIGIN"
+	}
+	if options.ReferrerPolicy == "" {
+		options.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+
+	hsts := ""
+	if options.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(options.HSTSMaxAge)
+		if options.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if options.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	csp := chef.HeaderContentSecurityPolicy
+	if options.CSPReportOnly {
+		csp += "-Report-Only"
+	}
+
+	return func(ctx chef.Context) {
+		ctx.SetHeader(chef.HeaderXFrameOptions, options.FrameOptions)
+		ctx.SetHeader(chef.HeaderReferrerPolicy, options.ReferrerPolicy)
+		if !options.AllowSniffing {
+			ctx.SetHeader(chef.HeaderXContentTypeOptions, "nosniff")
+		}
+		if hsts != "" && ctx.Scheme() == "https" {
+			ctx.SetHeader(chef.HeaderStrictTransportSecurity, hsts)
+		}
+		if options.ContentSecurityPolicy != "" {
+			ctx.SetHeader(csp, options.ContentSecurityPolicy)
+		}
+		if options.PermissionsPolicy != "" {
+			ctx.SetHeader(chef.HeaderPermissionsPolicy, options.PermissionsPolicy)
+		}
+		ctx.Next()
+	}
+}
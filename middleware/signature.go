@@ -0,0 +1,319 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+// Request signing headers
+const (
+	HeaderXSignatureKey   = "X-Signature-Key"
+	HeaderXSignature      = "X-Signature"
+	HeaderXSignatureNonce = "X-Signature-Nonce"
+	HeaderDate            = "Date"
+
+	HeaderXSignatureTimestamp = "X-Signature-Timestamp"
+)
+
+// Signature encodings
+const (
+	SignatureHex    = "hex"
+	SignatureBase64 = "base64"
+)
+
+type (
+	// KeyLookup returns the secret for a key ID, or false if the key is unknown
+	KeyLookup func(keyID string) ([]byte, bool)
+
+	// SignedRequestsOptions is the configuration used to setup the signed
+	// requests middleware. Requests are signed either with a key named by the
+	// X-Signature-Key header and resolved by KeyLookup, see SignRequest, or
+	// with a single shared Secret, as webhooks are, see Sign.
+	SignedRequestsOptions struct {
+		// KeyLookup resolves the secret of the key the request claims to be signed with
+		KeyLookup KeyLookup
+
+		// Secret is the shared HMAC key, used when KeyLookup is nil
+		Secret []byte
+
+		// MaxSkew is the maximum difference allowed between the request date
+		// and the server clock. Default value is 5 minutes
+		MaxSkew time.Duration
+
+		// Nonces remembers the nonces, or the signatures of shared secret
+		// requests, seen within the skew window. Default value is a MemoryStore
+		Nonces Store
+
+		// Header carries the shared secret signature. Default value is
+		// X-Signature
+		Header string
+
+		// Prefix precedes the shared secret signature in the header, i.e.:
+		// "sha256="
+		Prefix string
+
+		// Encoding of the shared secret signature, SignatureHex or
+		// SignatureBase64. Default value is SignatureHex
+		Encoding string
+
+		// TimestampHeader carries the unix time a shared secret request was
+		// signed at. Default value is X-Signature-Timestamp
+		TimestampHeader string
+
+		// Hash is the HMAC hash function of shared secret signatures. Default
+		// value is sha256.New
+		Hash func() hash.Hash
+	}
+
+	// RequestVerifier represents the middleware instance
+	RequestVerifier struct {
+		options   SignedRequestsOptions
+		noncesMux sync.Mutex
+	}
+)
+
+var (
+	errMissingSignature = errors.New("missing signature")
+	errUnknownKey       = errors.New("unknown signing key")
+	errInvalidDate      = errors.New("invalid or skewed date")
+	errReplayedNonce    = errors.New("replayed request")
+	errBadSignature     = errors.New("invalid signature")
+)
+
+// NewRequestVerifier creates a new signed requests middleware instance with provided options
+func NewRequestVerifier(options SignedRequestsOptions) *RequestVerifier {
+	if options.KeyLookup == nil && len(options.Secret) == 0 {
+		panic("chef: signed requests middleware requires a KeyLookup or a Secret")
+	}
+	return &RequestVerifier{options: options.withDefaults()}
+}
+
+// SignedRequests returns a middleware rejecting requests that aren't signed
+// with a key known to keyLookup
+func SignedRequests(keyLookup KeyLookup) chef.Handler {
+	return NewRequestVerifier(SignedRequestsOptions{KeyLookup: keyLookup}).Handler
+}
+
+// VerifySignature returns a middleware rejecting with a 401 requests whose
+// HMAC of method, path, timestamp and body with options.Secret doesn't match
+// the signature header, is too old, or was already seen
+func VerifySignature(options SignedRequestsOptions) chef.Handler {
+	if len(options.Secret) == 0 {
+		panic("chef: signature middleware requires a secret")
+	}
+	options.KeyLookup = nil
+	return NewRequestVerifier(options).Handler
+}
+
+// Handler verifies the request signature before passing it down the chain
+func (v *RequestVerifier) Handler(ctx chef.Context) {
+	verify := v.verifyKeyed
+	if v.options.KeyLookup == nil {
+		verify = v.verifySecret
+	}
+	if err := verify(ctx); err != nil {
+		ctx.SetStatusCode(http.StatusUnauthorized)
+		ctx.WriteString(err.Error())
+		return
+	}
+	ctx.Next()
+}
+
+func (v *RequestVerifier) verifyKeyed(ctx chef.Context) error {
+	req := ctx.Request()
+	keyID := req.Header.Get(HeaderXSignatureKey)
+	signature := req.Header.Get(HeaderXSignature)
+	nonce := req.Header.Get(HeaderXSignatureNonce)
+	if keyID == "" || signature == "" || nonce == "" {
+		return errMissingSignature
+	}
+
+	key, ok := v.options.KeyLookup(keyID)
+	if !ok {
+		return errUnknownKey
+	}
+
+	date, err := http.ParseTime(req.Header.Get(HeaderDate))
+	if err != nil || !v.inWindow(date) {
+		return errInvalidDate
+	}
+
+	body, err := ctx.Body()
+	if err != nil {
+		return err
+	}
+
+	expected := signRequest(key, req, body)
+	given, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(given, expected) {
+		return errBadSignature
+	}
+
+	// only remember nonces of authentic requests so that forged requests
+	// can't burn legitimate nonces
+	return v.remember("nonce:" + keyID + ":" + nonce)
+}
+
+func (v *RequestVerifier) verifySecret(ctx chef.Context) error {
+	req := ctx.Request()
+	signature := strings.TrimPrefix(req.Header.Get(v.options.Header), v.options.Prefix)
+	timestamp := req.Header.Get(v.options.TimestampHeader)
+	if signature == "" || timestamp == "" {
+		return errMissingSignature
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || !v.inWindow(time.Unix(sec, 0)) {
+		return errInvalidDate
+	}
+
+	body, err := ctx.Body()
+	if err != nil {
+		return err
+	}
+
+	given, err := v.options.decode(signature)
+	if err != nil || !hmac.Equal(given, v.options.sign(req, timestamp, body)) {
+		return errBadSignature
+	}
+
+	// the decoded MAC is the replay key, so re-encodings of the same
+	// signature (case, padding, prefix) are seen as replays too
+	return v.remember("signature:" + hex.EncodeToString(given))
+}
+
+// inWindow reports whether t is within the clock skew allowed
+func (v *RequestVerifier) inWindow(t time.Time) bool {
+	skew := time.Since(t)
+	return skew <= v.options.MaxSkew && skew >= -v.options.MaxSkew
+}
+
+// remember records key for twice the skew window, failing if it was seen
+func (v *RequestVerifier) remember(key string) error {
+	v.noncesMux.Lock()
+	defer v.noncesMux.Unlock()
+	if _, seen := v.options.Nonces.Get(key); seen {
+		return errReplayedNonce
+	}
+	v.options.Nonces.Set(key, []byte{1}, 2*v.options.MaxSkew)
+	return nil
+}
+
+// SignRequest signs req for the server side SignedRequests middleware. The
+// Date header is set to now if missing and nonce must be unique per request.
+func SignRequest(req *http.Request, keyID string, key []byte, nonce string) error {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if req.Header.Get(HeaderDate) == "" {
+		req.Header.Set(HeaderDate, time.Now().UTC().Format(http.TimeFormat))
+	}
+	req.Header.Set(HeaderXSignatureKey, keyID)
+	req.Header.Set(HeaderXSignatureNonce, nonce)
+	req.Header.Set(HeaderXSignature, base64.StdEncoding.EncodeToString(signRequest(key, req, body)))
+	return nil
+}
+
+// signRequest computes the HMAC-SHA256 of the canonical request:
+// method, path with query, date, nonce and body hash separated by newlines
+func signRequest(key []byte, req *http.Request, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		strings.ToUpper(req.Method),
+		req.URL.RequestURI(),
+		req.Header.Get(HeaderDate),
+		req.Header.Get(HeaderXSignatureNonce),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(canonical))
+	return mac.Sum(nil)
+}
+
+// Sign sets the timestamp and signature headers of req for VerifySignature
+// with options.Secret
+func (options SignedRequestsOptions) Sign(req *http.Request) error {
+	options = options.withDefaults()
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(options.TimestampHeader, timestamp)
+	req.Header.Set(options.Header, options.Prefix+options.encode(options.sign(req, timestamp, body)))
+	return nil
+}
+
+func (options SignedRequestsOptions) withDefaults() SignedRequestsOptions {
+	if options.Header == "" {
+		options.Header = HeaderXSignature
+	}
+	if options.Encoding == "" {
+		options.Encoding = SignatureHex
+	}
+	if options.TimestampHeader == "" {
+		options.TimestampHeader = HeaderXSignatureTimestamp
+	}
+	if options.Hash == nil {
+		options.Hash = sha256.New
+	}
+	if options.MaxSkew <= 0 {
+		options.MaxSkew = 5 * time.Minute
+	}
+	if options.Nonces == nil {
+		options.Nonces = NewMemoryStore()
+	}
+	return options
+}
+
+// sign computes the HMAC of method, path with query, timestamp and body
+// separated by newlines
+func (options SignedRequestsOptions) sign(req *http.Request, timestamp string, body []byte) []byte {
+	mac := hmac.New(options.Hash, options.Secret)
+	mac.Write([]byte(strings.ToUpper(req.Method) + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (options SignedRequestsOptions) encode(b []byte) string {
+	if options.Encoding == SignatureBase64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (options SignedRequestsOptions) decode(s string) ([]byte, error) {
+	if options.Encoding == SignatureBase64 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return hex.DecodeString(s)
+}
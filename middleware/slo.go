@@ -0,0 +1,284 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+	"github.com/gochef/chef/utils"
+)
+
+type (
+	// SLOOptions is the configuration used to setup the SLO middleware
+	SLOOptions struct {
+		// Objective is the target ratio of successful requests per route.
+		// Default value is 0.999
+		Objective float64
+
+		// Latency is the latency objective at Percentile. Zero disables latency tracking.
+		Latency time.Duration
+
+		// Percentile at which Latency is measured. Default value is 0.99
+		Percentile float64
+
+		// Window is the rolling window the error rate and latencies are computed over.
+		// Default value is 5 minutes
+		Window time.Duration
+
+		// BurnRate is how many times faster than sustainable the error budget may
+		// be consumed before OnBurn fires. Default value is 10
+		BurnRate float64
+
+		// MinRequests is the number of requests a route needs in the window before
+		// it is evaluated. Default value is 100
+		MinRequests int
+
+		// IsError decides whether a response counts against the error budget.
+		// Default treats 5xx status codes as errors.
+		IsError func(ctx chef.Context) bool
+
+		// OnBurn is called when a route burns its budget too fast or misses its
+		// latency objective. It is called at most once per Window and route.
+		OnBurn func(report SLOReport)
+
+		// Notifier is sent the same alerts as OnBurn, i.e. a channel of the
+		// notify module
+		Notifier Notifier
+
+		// Logger reports failed notifications, i.e. app.Logger(). Default
+		// value logs to the screen
+		Logger *utils.Logger
+	}
+
+	// Notifier delivers alerts to people, by mail, chat or pager
+	Notifier interface {
+		Notify(subject, message string) error
+	}
+
+	// SLOReport summarizes the state of a route against its objectives
+	SLOReport struct {
+		Route           string
+		Requests        int
+		Errors          int
+		ErrorRate       float64
+		BurnRate        float64
+		Latency         time.Duration
+		BudgetExhausted bool
+		LatencyBreached bool
+	}
+
+	// SLO represents the middleware instance
+	SLO struct {
+		options SLOOptions
+		slot    time.Duration
+		lock    sync.Mutex
+		routes  map[string]*sloRoute
+	}
+
+	// sloRoute counts the requests of a route in sloSlots slots spanning the
+	// window, so its memory doesn't grow with the traffic
+	sloRoute struct {
+		slots [sloSlots]sloSlot
+		fired time.Time
+	}
+
+	sloSlot struct {
+		start     time.Time
+		requests  int
+		errors    int
+		latencies [sloBuckets]int
+	}
+)
+
+const (
+	// sloSlots is the number of slots a window is split in. Routes are
+	// evaluated when a slot starts, so alerts lag by at most Window/sloSlots
+	sloSlots = 10
+
+	// sloBuckets is the number of latency buckets, growing by a factor of
+	// sqrt(2) from sloMinLatency. The last one holds everything above.
+	sloBuckets    = 48
+	sloMinLatency = 100 * time.Microsecond
+)
+
+// NewSLO creates a new SLO tracker instance with provided options
+func NewSLO(options SLOOptions) *SLO {
+	if options.Objective <= 0 || options.Objective >= 1 {
+		options.Objective = 0.999
+	}
+	if options.Percentile <= 0 || options.Percentile >= 1 {
+		options.Percentile = 0.99
+	}
+	if options.Window <= 0 {
+		options.Window = 5 * time.Minute
+	}
+	if options.BurnRate <= 0 {
+		options.BurnRate = 10
+	}
+	if options.MinRequests <= 0 {
+		options.MinRequests = 100
+	}
+	if options.IsError == nil {
+		options.IsError = func(ctx chef.Context) bool {
+			return ctx.Response().Status >= http.StatusInternalServerError
+		}
+	}
+	if options.Logger == nil {
+		options.Logger = utils.NewLogger(&utils.LoggerConfig{})
+	}
+	options.Logger = options.Logger.GetModuleLogger("slo")
+
+	return &SLO{
+		options: options,
+		slot:    max(options.Window/sloSlots, time.Millisecond),
+		routes:  map[string]*sloRoute{},
+	}
+}
+
+// Handler records the outcome of the request against its route objectives
+func (s *SLO) Handler(ctx chef.Context) {
+	start := time.Now()
+	ctx.Next()
+
+	route := ctx.Path()
+	if route == "" {
+		return
+	}
+
+	at := time.Now()
+	latency := at.Sub(start)
+	failed := s.options.IsError(ctx)
+
+	s.lock.Lock()
+	r, ok := s.routes[route]
+	if !ok {
+		r = &sloRoute{}
+		s.routes[route] = r
+	}
+	var report SLOReport
+	fire := false
+	if r.record(at, s.slot, latency, failed) {
+		// a slot started, evaluate the route over the window
+		report = s.report(route, r, at)
+		fire = (report.BudgetExhausted || report.LatencyBreached) && at.Sub(r.fired) >= s.options.Window
+		if fire {
+			r.fired = at
+		}
+	}
+	s.lock.Unlock()
+
+	if fire {
+		go s.alert(report)
+	}
+}
+
+// alert hands report to OnBurn and the Notifier
+func (s *SLO) alert(report SLOReport) {
+	if s.options.OnBurn != nil {
+		s.options.OnBurn(report)
+	}
+	if s.options.Notifier == nil {
+		return
+	}
+	subject := "SLO breached on " + report.Route
+	message := fmt.Sprintf("%d requests, %d errors (%.2f%%, burn rate %.1f), p%g latency %s",
+		report.Requests, report.Errors, report.ErrorRate*100, report.BurnRate,
+		s.options.Percentile*100, report.Latency)
+	if err := s.options.Notifier.Notify(subject, message); err != nil {
+		s.options.Logger.Errorf("Notification failed: %s", err)
+	}
+}
+
+// Reports returns the current state of every tracked route
+func (s *SLO) Reports() []SLOReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	reports := make([]SLOReport, 0, len(s.routes))
+	for route, r := range s.routes {
+		reports = append(reports, s.report(route, r, now))
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Route < reports[j].Route
+	})
+	return reports
+}
+
+// report sums the slots of r within the window ending at now, the lock must
+// be held
+func (s *SLO) report(route string, r *sloRoute, now time.Time) SLOReport {
+	rep := SLOReport{Route: route}
+	var latencies [sloBuckets]int
+	cutoff := now.Add(-s.options.Window)
+	for i := range r.slots {
+		slot := &r.slots[i]
+		if !slot.start.After(cutoff) {
+			continue
+		}
+		rep.Requests += slot.requests
+		rep.Errors += slot.errors
+		for b, n := range slot.latencies {
+			latencies[b] += n
+		}
+	}
+	if rep.Requests == 0 {
+		return rep
+	}
+
+	rep.ErrorRate = float64(rep.Errors) / float64(rep.Requests)
+	rep.BurnRate = rep.ErrorRate / (1 - s.options.Objective)
+	rank := int(math.Ceil(float64(rep.Requests) * s.options.Percentile))
+	for b, n := range latencies {
+		if rank -= n; rank <= 0 {
+			rep.Latency = sloBucketBound(b)
+			break
+		}
+	}
+
+	if rep.Requests >= s.options.MinRequests {
+		rep.BudgetExhausted = rep.BurnRate >= s.options.BurnRate
+		rep.LatencyBreached = s.options.Latency > 0 && rep.Latency > s.options.Latency
+	}
+	return rep
+}
+
+// record counts a request in the slot of size holding at, reporting whether
+// the slot was just started
+func (r *sloRoute) record(at time.Time, size, latency time.Duration, failed bool) bool {
+	n := at.UnixNano() / int64(size)
+	slot := &r.slots[n%sloSlots]
+	start := time.Unix(0, n*int64(size))
+	started := !slot.start.Equal(start)
+	if started {
+		*slot = sloSlot{start: start}
+	}
+	slot.requests++
+	if failed {
+		slot.errors++
+	}
+	slot.latencies[sloBucket(latency)]++
+	return started
+}
+
+// sloBucket returns the latency bucket of d
+func sloBucket(d time.Duration) int {
+	if d <= sloMinLatency {
+		return 0
+	}
+	b := int(math.Ceil(2 * math.Log2(float64(d)/float64(sloMinLatency))))
+	if b >= sloBuckets {
+		return sloBuckets - 1
+	}
+	return b
+}
+
+// sloBucketBound returns the upper bound of bucket b, percentiles are
+// reported at it so they are never underestimated
+func sloBucketBound(b int) time.Duration {
+	return time.Duration(float64(sloMinLatency) * math.Pow(2, float64(b)/2))
+}
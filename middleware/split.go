@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+
+	"github.com/gochef/chef"
+)
+
+// Split variants recorded on the context
+const (
+	VariantControl = "control"
+	VariantCanary  = "canary"
+)
+
+type (
+	// SplitOptions is the configuration used to setup the traffic split middleware
+	SplitOptions struct {
+		// Percent of requests, from 0 to 100, sent to Variant
+		Percent float64
+
+		// Variant handles the requests picked for it instead of the rest of the chain
+		Variant chef.Handler
+
+		// Name is recorded on the context for requests sent to Variant.
+		// Default value is VariantCanary
+		Name string
+
+		// Cookie makes the choice sticky per client when set
+		Cookie string
+
+		// CookieMaxAge is the lifetime of the sticky cookie in seconds.
+		// Default value is one day
+		CookieMaxAge int
+
+		// ContextKey is the key the chosen variant is stored under in the
+		// context, for logging and metrics. Default value is "variant"
+		ContextKey string
+	}
+)
+
+// Split returns a middleware sending percent of the requests to variant
+// instead of the rest of the chain
+func Split(percent float64, variant chef.Handler) chef.Handler {
+	return SplitWithOptions(SplitOptions{Percent: percent, Variant: variant})
+}
+
+// SplitWithOptions returns a traffic split middleware with provided options
+func SplitWithOptions(options SplitOptions) chef.Handler {
+	if options.Variant == nil {
+		panic("chef: split middleware requires a variant handler")
+	}
+	if options.Name == "" {
+		options.Name = VariantCanary
+	}
+	if options.CookieMaxAge <= 0 {
+		options.CookieMaxAge = 86400
+	}
+	if options.ContextKey == "" {
+		options.ContextKey = "variant"
+	}
+
+	return func(ctx chef.Context) {
+		variant := ""
+		if options.Cookie != "" {
+			if c, err := ctx.Request().Cookie(options.Cookie); err == nil && (c.Value == options.Name || c.Value == VariantControl) {
+				variant = c.Value
+			}
+		}
+		if variant == "" {
+			variant = VariantControl
+			if rand.Float64()*100 < options.Percent {
+				variant = options.Name
+			}
+			if options.Cookie != "" {
+				http.SetCookie(ctx.Response(), &http.Cookie{
+					Name:     options.Cookie,
+					Value:    variant,
+					Path:     "/",
+					MaxAge:   options.CookieMaxAge,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+		}
+
+		ctx.Set(options.ContextKey, variant)
+		if variant == options.Name {
+			options.Variant(ctx)
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,376 @@
+package middleware
+
+import (
+	stdctx "context"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+	"github.com/gochef/chef/utils"
+)
+
+// Component states
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusDown        = "down"
+)
+
+type (
+	// HealthCheck reports the health of a component, a nil error meaning healthy
+	HealthCheck func(ctx stdctx.Context) error
+
+	// StatusPageOptions is the configuration used to setup the status page
+	StatusPageOptions struct {
+		// Title is shown on the HTML page. Default value is "Status"
+		Title string
+
+		// Interval between two runs of the checks. Default value is 30 seconds
+		Interval time.Duration
+
+		// Timeout of each check. Default value is 5 seconds
+		Timeout time.Duration
+
+		// Window is the period availability is computed over.
+		// Default value is 24 hours
+		Window time.Duration
+
+		// Logger reports components going down and back up, i.e.
+		// app.Logger(). Default value logs to the screen
+		Logger *utils.Logger
+	}
+
+	// StatusPage runs health checks in the background and serves their
+	// summary, availability and incidents as HTML or JSON
+	StatusPage struct {
+		options StatusPageOptions
+
+		lock       sync.RWMutex
+		components map[string]*component
+		incidents  []Incident
+		nextID     int
+		stop       chan struct{}
+		stopOnce   sync.Once
+	}
+
+	// ComponentStatus is the reported state of a component. Error is only
+	// served by AdminHandler, as it may reveal internals.
+	ComponentStatus struct {
+		Name         string    `json:"name"`
+		Status       string    `json:"status"`
+		Error        string    `json:"error,omitempty"`
+		CheckedAt    time.Time `json:"checked_at"`
+		Availability float64   `json:"availability"`
+	}
+
+	// Incident is an annotation posted by operators
+	Incident struct {
+		ID         int        `json:"id"`
+		Title      string     `json:"title"`
+		Message    string     `json:"message,omitempty"`
+		Status     string     `json:"status"`
+		StartedAt  time.Time  `json:"started_at"`
+		ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	}
+
+	// StatusSummary is the JSON document served by the status page
+	StatusSummary struct {
+		Status     string            `json:"status"`
+		Components []ComponentStatus `json:"components"`
+		Incidents  []Incident        `json:"incidents"`
+	}
+
+	component struct {
+		check   HealthCheck
+		last    ComponentStatus
+		samples []statusSample
+	}
+
+	statusSample struct {
+		at time.Time
+		ok bool
+	}
+)
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body><h1>{{.Title}}: {{.Summary.Status}}</h1>
+<table>{{range .Summary.Components}}
+<tr><td>{{.Name}}</td><td>{{.Status}}</td><td>{{printf "%.3f" .Availability}}%</td></tr>{{end}}
+</table>{{if .Summary.Incidents}}
+<h2>Incidents</h2>{{range .Summary.Incidents}}
+<article><h3>{{.Title}} ({{.Status}})</h3><p>{{.Message}}</p><time>{{.StartedAt.Format "2006-01-02 15:04 MST"}}</time></article>{{end}}{{end}}
+</body></html>`))
+
+// NewStatusPage creates a status page with provided options. Call Start once
+// the checks are added.
+func NewStatusPage(options StatusPageOptions) *StatusPage {
+	if options.Title == "" {
+		options.Title = "Status"
+	}
+	if options.Interval <= 0 {
+		options.Interval = 30 * time.Second
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if options.Window <= 0 {
+		options.Window = 24 * time.Hour
+	}
+	if options.Logger == nil {
+		options.Logger = utils.NewLogger(&utils.LoggerConfig{})
+	}
+	options.Logger = options.Logger.GetModuleLogger("status")
+
+	return &StatusPage{
+		options:    options,
+		components: map[string]*component{},
+		stop:       make(chan struct{}),
+	}
+}
+
+// AddCheck registers the health check of a component
+func (s *StatusPage) AddCheck(name string, check HealthCheck) {
+	s.lock.Lock()
+	s.components[name] = &component{
+		check: check,
+		last:  ComponentStatus{Name: name, Status: StatusOperational, Availability: 100},
+	}
+	s.lock.Unlock()
+}
+
+// Start runs the checks now and then every Interval until Stop
+func (s *StatusPage) Start() {
+	s.runChecks()
+	go func() {
+		ticker := time.NewTicker(s.options.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.runChecks()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops running the checks
+func (s *StatusPage) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *StatusPage) runChecks() {
+	s.lock.RLock()
+	names := make([]string, 0, len(s.components))
+	for name := range s.components {
+		names = append(names, name)
+	}
+	s.lock.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			s.lock.RLock()
+			check := s.components[name].check
+			s.lock.RUnlock()
+
+			ctx, cancel := stdctx.WithTimeout(stdctx.Background(), s.options.Timeout)
+			err := check(ctx)
+			cancel()
+			s.record(name, err)
+		}(name)
+	}
+	wg.Wait()
+
+	s.lock.Lock()
+	s.pruneIncidents(time.Now())
+	s.lock.Unlock()
+}
+
+// pruneIncidents drops the incidents resolved before the window, the lock
+// must be held
+func (s *StatusPage) pruneIncidents(now time.Time) {
+	cutoff := now.Add(-s.options.Window)
+	kept := s.incidents[:0]
+	for _, inc := range s.incidents {
+		if inc.ResolvedAt == nil || inc.ResolvedAt.After(cutoff) {
+			kept = append(kept, inc)
+		}
+	}
+	s.incidents = kept
+}
+
+func (s *StatusPage) record(name string, err error) {
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c := s.components[name]
+	c.samples = append(c.samples, statusSample{at: now, ok: err == nil})
+	i := 0
+	for ; i < len(c.samples) && now.Sub(c.samples[i].at) > s.options.Window; i++ {
+	}
+	c.samples = c.samples[i:]
+
+	healthy := 0
+	for _, sample := range c.samples {
+		if sample.ok {
+			healthy++
+		}
+	}
+
+	prev := c.last
+	c.last = ComponentStatus{
+		Name:         name,
+		Status:       StatusOperational,
+		CheckedAt:    now,
+		Availability: 100 * float64(healthy) / float64(len(c.samples)),
+	}
+	if err != nil {
+		c.last.Status = StatusDown
+		c.last.Error = err.Error()
+		if prev.Error != c.last.Error {
+			s.options.Logger.Warningf("%s is down: %s", name, c.last.Error)
+		}
+	} else if prev.Status != StatusOperational {
+		s.options.Logger.Noticef("%s is back up", name)
+	}
+}
+
+// AddIncident posts an incident annotation and returns it
+func (s *StatusPage) AddIncident(title, message string) Incident {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pruneIncidents(time.Now())
+	s.nextID++
+	inc := Incident{
+		ID:        s.nextID,
+		Title:     title,
+		Message:   message,
+		Status:    "investigating",
+		StartedAt: time.Now(),
+	}
+	s.incidents = append(s.incidents, inc)
+	return inc
+}
+
+// ResolveIncident marks the incident as resolved, returning false if unknown
+func (s *StatusPage) ResolveIncident(id int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.incidents {
+		if s.incidents[i].ID == id {
+			now := time.Now()
+			s.incidents[i].Status = "resolved"
+			s.incidents[i].ResolvedAt = &now
+			return true
+		}
+	}
+	return false
+}
+
+// Summary returns the current state of every component, check errors
+// included, and the incidents of the availability window, most recent first
+func (s *StatusPage) Summary() StatusSummary {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sum := StatusSummary{
+		Status:     StatusOperational,
+		Components: make([]ComponentStatus, 0, len(s.components)),
+		Incidents:  []Incident{},
+	}
+	down := 0
+	for _, c := range s.components {
+		sum.Components = append(sum.Components, c.last)
+		if c.last.Status != StatusOperational {
+			down++
+		}
+	}
+	sort.Slice(sum.Components, func(i, j int) bool {
+		return sum.Components[i].Name < sum.Components[j].Name
+	})
+	switch {
+	case down > 0 && down == len(s.components):
+		sum.Status = StatusDown
+	case down > 0:
+		sum.Status = StatusDegraded
+	}
+
+	cutoff := time.Now().Add(-s.options.Window)
+	for i := len(s.incidents) - 1; i >= 0; i-- {
+		inc := s.incidents[i]
+		if inc.ResolvedAt == nil || inc.ResolvedAt.After(cutoff) {
+			sum.Incidents = append(sum.Incidents, inc)
+		}
+	}
+	return sum
+}
+
+// Handler serves the status page, as JSON to clients asking for it and with
+// ?format=json, as HTML otherwise
+func (s *StatusPage) Handler(ctx chef.Context) {
+	sum := s.Summary()
+	for i := range sum.Components {
+		sum.Components[i].Error = ""
+	}
+	ctx.SetHeader(chef.HeaderCacheControl, chef.CacheControlNoStore)
+
+	if ctx.QueryParam("format") == "json" || strings.Contains(ctx.Request().Header.Get(chef.HeaderAccept), chef.MIMEApplicationJSON) {
+		ctx.SetHeader(chef.HeaderContentType, chef.MIMEApplicationJSONCharsetUTF8)
+		ctx.JSON(sum)
+		return
+	}
+
+	ctx.SetHeader(chef.HeaderContentType, chef.MIMETextHTMLCharsetUTF8)
+	statusTemplate.Execute(ctx.Response(), struct {
+		Title   string
+		Summary StatusSummary
+	}{s.options.Title, sum})
+}
+
+// AdminHandler is the incidents admin API. GET returns the summary as JSON
+// with the check errors, POST with title and message form values creates an
+// incident, DELETE with an id query param resolves it. Mount it behind
+// authentication.
+func (s *StatusPage) AdminHandler(ctx chef.Context) {
+	switch ctx.Request().Method {
+	case chef.GET:
+		ctx.SetHeader(chef.HeaderCacheControl, chef.CacheControlNoStore)
+		ctx.SetHeader(chef.HeaderContentType, chef.MIMEApplicationJSONCharsetUTF8)
+		ctx.JSON(s.Summary())
+	case chef.POST:
+		title := ctx.FormValue("title")
+		if title == "" {
+			ctx.SetStatusCode(http.StatusBadRequest)
+			ctx.WriteString("missing title")
+			return
+		}
+		inc := s.AddIncident(title, ctx.FormValue("message"))
+		ctx.SetHeader(chef.HeaderContentType, chef.MIMEApplicationJSONCharsetUTF8)
+		ctx.SetStatusCode(http.StatusCreated)
+		ctx.JSON(inc)
+	case chef.DELETE:
+		id, err := strconv.Atoi(ctx.QueryParam("id"))
+		if err != nil || !s.ResolveIncident(id) {
+			ctx.SetStatusCode(http.StatusNotFound)
+			ctx.WriteString("incident not found")
+			return
+		}
+		ctx.SetStatusCode(http.StatusNoContent)
+	default:
+		ctx.SetHeader(chef.HeaderAllow, "GET, POST, DELETE")
+		ctx.SetStatusCode(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gochef/cache"
+)
+
+type (
+	// Store is the key/value storage used by the caching middlewares
+	Store interface {
+		Get(key string) ([]byte, bool)
+		Set(key string, value []byte, ttl time.Duration)
+		Delete(key string)
+	}
+
+	// MemoryStore is an in-process Store. Expired entries are dropped lazily.
+	MemoryStore struct {
+		lock    sync.RWMutex
+		entries map[string]memoryEntry
+		sets    int
+	}
+
+	memoryEntry struct {
+		value   []byte
+		expires time.Time
+	}
+
+	cacheStore struct {
+		cache *cache.Cache
+	}
+)
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]memoryEntry{},
+	}
+}
+
+// Get returns the value stored for key, if any and not expired
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.lock.RLock()
+	e, ok := s.entries[key]
+	s.lock.RUnlock()
+	if !ok || (!e.expires.IsZero() && time.Now().After(e.expires)) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set stores value for key. A zero ttl never expires.
+func (s *MemoryStore) Set(key string, value []byte, ttl time.Duration) {
+	e := memoryEntry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	s.lock.Lock()
+	s.entries[key] = e
+	s.sets++
+	if s.sets%1000 == 0 {
+		s.evict()
+	}
+	s.lock.Unlock()
+}
+
+// Delete removes the value stored for key
+func (s *MemoryStore) Delete(key string) {
+	s.lock.Lock()
+	delete(s.entries, key)
+	s.lock.Unlock()
+}
+
+// CacheStore adapts a gochef/cache driver to the Store interface
+func CacheStore(c *cache.Cache) Store {
+	return &cacheStore{cache: c}
+}
+
+func (s *cacheStore) Get(key string) ([]byte, bool) {
+	b, err := s.cache.Get(key)
+	if err != nil || b == nil {
+		return nil, false
+	}
+	return b, true
+}
+
+func (s *cacheStore) Set(key string, value []byte, ttl time.Duration) {
+	s.cache.Set(key, value, ttl)
+}
+
+func (s *cacheStore) Delete(key string) {
+	s.cache.Delete(key)
+}
+
+// evict must be called with the lock held
+func (s *MemoryStore) evict() {
+	now := time.Now()
+	for k, e := range s.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+type (
+	// cachedResponse is a response captured for replay by the caching middlewares
+	cachedResponse struct {
+		Status      int
+		Header      http.Header
+		Body        []byte
+		Stored      time.Time
+		Expires     time.Time
+		Fingerprint string
+	}
+)
+
+func decodeCachedResponse(b []byte) (*cachedResponse, bool) {
+	r := &cachedResponse{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(r); err != nil {
+		return nil, false
+	}
+	return r, true
+}
+
+func (r *cachedResponse) encode() []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// write replays the response on w
+func (r *cachedResponse) write(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range r.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(r.Status)
+	w.Write(r.Body)
+}
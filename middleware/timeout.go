@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"bytes"
+	stdctx "context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// TimeoutOptions is the configuration used to setup the timeout middleware
+	TimeoutOptions struct {
+		// Timeout is the deadline for the rest of the chain
+		Timeout time.Duration
+
+		// StatusCode is sent when the deadline is exceeded. Default value is 503
+		StatusCode int
+
+		// Message is the body sent when the deadline is exceeded
+		Message string
+	}
+
+	// timeoutWriter buffers the response of the chain so that it can be
+	// discarded if the deadline is hit first
+	timeoutWriter struct {
+		lock     sync.Mutex
+		header   http.Header
+		buf      bytes.Buffer
+		status   int
+		timedOut bool
+	}
+)
+
+// Timeout returns a middleware running the rest of the chain under the deadline d
+func Timeout(d time.Duration) chef.Handler {
+	return TimeoutWithOptions(TimeoutOptions{Timeout: d})
+}
+
+// TimeoutWithOptions returns a timeout middleware with provided options. The
+// chain runs on a copy of the context in its own goroutine with a cancelled
+// request context once the deadline is exceeded. Its response is buffered and
+// only sent if it completes in time; later writes fail with
+// http.ErrHandlerTimeout.
+func TimeoutWithOptions(options TimeoutOptions) chef.Handler {
+	if options.Timeout <= 0 {
+		panic("chef: timeout must be positive")
+	}
+	if options.StatusCode == 0 {
+		options.StatusCode = http.StatusServiceUnavailable
+	}
+	if options.Message == "" {
+		options.Message = http.StatusText(options.StatusCode)
+	}
+
+	return func(ctx chef.Context) {
+		req := ctx.Request()
+		res := ctx.Response()
+		c, cancel := stdctx.WithTimeout(req.Context(), options.Timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{
+			header: res.Header().Clone(),
+		}
+		inner := ctx.Copy()
+		inner.SetRequest(req.WithContext(c))
+		inner.Response().Writer = tw
+
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			inner.Next()
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.lock.Lock()
+			defer tw.lock.Unlock()
+
+			dst := res.Header()
+			for k, v := range tw.header {
+				dst[k] = v
+			}
+			for k, v := range inner.GetAll() {
+				ctx.Set(k, v)
+			}
+			if tw.status != 0 {
+				res.WriteHeader(tw.status)
+			}
+			res.Write(tw.buf.Bytes())
+		case <-c.Done():
+			tw.lock.Lock()
+			tw.timedOut = true
+			tw.lock.Unlock()
+
+			ctx.SetStatusCode(options.StatusCode)
+			ctx.WriteString(options.Message)
+		}
+	}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut || w.status != 0 {
+		return
+	}
+	w.status = code
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
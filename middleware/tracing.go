@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	stdctx "context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef"
+)
+
+// HeaderTraceparent is the W3C trace context header
+const HeaderTraceparent = "traceparent"
+
+type (
+	// TracingOptions is the configuration used to setup the tracing middleware
+	TracingOptions struct {
+		// Export receives every finished span. Plug an OpenTelemetry exporter
+		// or any other backend in here.
+		Export func(span *Span)
+
+		// ContextKey is the key the request span is stored under in the
+		// context. Default value is "span"
+		ContextKey string
+	}
+
+	// Span is a timed operation of a trace, compatible with W3C trace context
+	Span struct {
+		Name       string
+		TraceID    string
+		SpanID     string
+		ParentID   string
+		Sampled    bool
+		Start      time.Time
+		End        time.Time
+		Attributes map[string]interface{}
+		Err        error
+
+		lock   sync.Mutex
+		export func(span *Span)
+	}
+
+	spanKey struct{}
+)
+
+// Tracing returns a middleware starting a server span per request, continuing
+// the trace of the incoming traceparent header when there is one. Finished
+// spans are passed to export.
+func Tracing(export func(span *Span)) chef.Handler {
+	return TracingWithOptions(TracingOptions{Export: export})
+}
+
+// TracingWithOptions returns a tracing middleware with provided options. The
+// span is named after the route pattern, and is available to handlers through
+// the context key and SpanFromContext to start child spans.
+func TracingWithOptions(options TracingOptions) chef.Handler {
+	if options.ContextKey == "" {
+		options.ContextKey = "span"
+	}
+	if options.Export == nil {
+		options.Export = func(*Span) {}
+	}
+
+	return func(ctx chef.Context) {
+		req := ctx.Request()
+		span := &Span{
+			Name:    req.Method + " " + ctx.Path(),
+			TraceID: newTraceID(16),
+			Sampled: true,
+			Start:   time.Now(),
+			export:  options.Export,
+			Attributes: map[string]interface{}{
+				"http.method": req.Method,
+				"http.target": req.URL.RequestURI(),
+				"http.host":   req.Host,
+				"http.route":  ctx.Path(),
+			},
+		}
+		if traceID, parentID, sampled, ok := parseTraceparent(req.Header.Get(HeaderTraceparent)); ok {
+			span.TraceID, span.ParentID, span.Sampled = traceID, parentID, sampled
+		}
+		span.SpanID = newTraceID(8)
+
+		ctx.Set(options.ContextKey, span)
+		ctx.SetRequest(req.WithContext(stdctx.WithValue(req.Context(), spanKey{}, span)))
+
+		defer func() {
+			rec := recover()
+			if rec != nil {
+				span.RecordError(fmt.Errorf("panic: %v", rec))
+			}
+
+			status := ctx.Response().Status
+			span.SetAttribute("http.status_code", status)
+			if status >= http.StatusInternalServerError && span.Err == nil {
+				span.RecordError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+			}
+			span.Finish()
+
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+
+		ctx.Next()
+	}
+}
+
+// SpanFromContext returns the span stored in a request context by the tracing
+// middleware, or nil
+func SpanFromContext(c stdctx.Context) *Span {
+	span, _ := c.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Child starts a new span of the same trace
+func (s *Span) Child(name string) *Span {
+	return &Span{
+		Name:       name,
+		TraceID:    s.TraceID,
+		SpanID:     newTraceID(8),
+		ParentID:   s.SpanID,
+		Sampled:    s.Sampled,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+		export:     s.export,
+	}
+}
+
+// SetAttribute records a key/value pair on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.lock.Lock()
+	s.Attributes[key] = value
+	s.lock.Unlock()
+}
+
+// RecordError marks the span as failed
+func (s *Span) RecordError(err error) {
+	s.lock.Lock()
+	s.Err = err
+	s.lock.Unlock()
+}
+
+// Finish ends the span and exports it
+func (s *Span) Finish() {
+	s.End = time.Now()
+	if s.Sampled && s.export != nil {
+		s.export(s)
+	}
+}
+
+// Traceparent returns the W3C traceparent header value for the span
+func (s *Span) Traceparent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// Inject propagates the trace to an outgoing request
+func (s *Span) Inject(req *http.Request) {
+	req.Header.Set(HeaderTraceparent, s.Traceparent())
+}
+
+// parseTraceparent parses a version 00 traceparent header
+func parseTraceparent(h string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false, false
+	}
+	if len(parts[1]) != 32 || !isHex(parts[1]) || parts[1] == strings.Repeat("0", 32) {
+		return "", "", false, false
+	}
+	if len(parts[2]) != 16 || !isHex(parts[2]) || parts[2] == strings.Repeat("0", 16) {
+		return "", "", false, false
+	}
+	if len(parts[3]) != 2 || !isHex(parts[3]) {
+		return "", "", false, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return parts[1], parts[2], flags[0]&1 == 1, true
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9') && !('a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newTraceID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
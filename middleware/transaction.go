@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gochef/chef"
+)
+
+type (
+	// Tx is a unit of work that can be committed or rolled back. *sql.Tx
+	// satisfies it.
+	Tx interface {
+		Commit() error
+		Rollback() error
+	}
+
+	// TransactionOptions is the configuration used to setup the transaction middleware
+	TransactionOptions struct {
+		// Begin starts the transaction for the request
+		Begin func(ctx chef.Context) (Tx, error)
+
+		// ContextKey is the key the transaction is stored under in the context.
+		// Default value is "tx"
+		ContextKey string
+	}
+)
+
+// Transaction wraps every request in a transaction stored on the context. The
+// transaction is committed when the response is successful and rolled back on
+// errors or when the client asked for a dry run (see Context.IsDryRun), so
+// clients can preview the effects of write APIs safely.
+func Transaction(options TransactionOptions) chef.Handler {
+	if options.Begin == nil {
+		panic("chef: transaction middleware requires a Begin function")
+	}
+	if options.ContextKey == "" {
+		options.ContextKey = "tx"
+	}
+
+	return func(ctx chef.Context) {
+		tx, err := options.Begin(ctx)
+		if err != nil {
+			ctx.SetStatusCode(http.StatusInternalServerError)
+			ctx.WriteString("unable to start transaction")
+			return
+		}
+
+		dryRun := ctx.IsDryRun()
+		if dryRun {
+			ctx.SetHeader(chef.HeaderXDryRun, "true")
+		}
+		ctx.Set(options.ContextKey, tx)
+
+		committed := false
+		defer func() {
+			if !committed {
+				tx.Rollback()
+			}
+		}()
+
+		ctx.Next()
+
+		if !dryRun && ctx.Response().Status < http.StatusBadRequest {
+			committed = tx.Commit() == nil
+		}
+	}
+}
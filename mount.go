@@ -0,0 +1,52 @@
+package chef
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mount serves app under prefix. The mounted application keeps its own config,
+// middlewares and session settings; it only sees the part of the path after
+// prefix. Middlewares of c run before handing the request over.
+//
+// app runs along with c: its start hooks and scheduled tasks start after the
+// ones of c, and on shutdown its streams are closed with the ones of c, its
+// goroutines, tasks, jobs and stop hooks are drained before the ones of c,
+// then its shutdown hooks run first. app must not be run on its own.
+func (c *Chef) Mount(prefix string, app *Chef) {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		panic("chef: cannot mount an application at the root path")
+	}
+	if app.lifecycle == c.lifecycle {
+		panic("chef: cannot mount an application into itself")
+	}
+
+	c.lifecycle.lock.Lock()
+	c.lifecycle.mounts = append(c.lifecycle.mounts, app)
+	c.lifecycle.lock.Unlock()
+
+	h := func(ctx Context) {
+		req := ctx.Request()
+		p := strings.TrimPrefix(req.URL.Path, prefix)
+		rp := strings.TrimPrefix(req.URL.RawPath, prefix)
+		if p == "" {
+			p = "/"
+		}
+
+		r := new(http.Request)
+		*r = *req
+		r.URL = new(url.URL)
+		*r.URL = *req.URL
+		r.URL.Path = p
+		r.URL.RawPath = rp
+
+		app.router.ServeHTTP(ctx.Response(), r)
+	}
+
+	for _, m := range methods {
+		c.router.add(m, prefix, h, c.scope)
+		c.router.add(m, prefix+"/*", h, c.scope)
+	}
+}
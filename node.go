@@ -1,5 +1,7 @@
 package chef
 
+import "strings"
+
 type (
 	kind uint8
 	node struct {
@@ -46,7 +48,7 @@ var (
 	}
 )
 
-func (r *Router) insert(method, path string, h []Handler, t kind, ppath string, pnames []string) {
+func (r *Router) insert(method, path string, h []Handler, t kind, ppath string, pnames []string, literals []int) {
 	l := len(pnames)
 	if *r.maxParam < l {
 		*r.maxParam = l
@@ -57,6 +59,7 @@ func (r *Router) insert(method, path string, h []Handler, t kind, ppath string,
 		panic("chef: invalid method")
 	}
 	search := path
+	off := 0 // Offset of search in path
 
 	for {
 		sl := len(search)
@@ -110,7 +113,12 @@ func (r *Router) insert(method, path string, h []Handler, t kind, ppath string,
 			}
 		} else if l < sl {
 			search = search[l:]
+			off += l
 			c := cn.findChildWithLabel(search[0])
+			if search[0] == ':' {
+				// a param and an escaped ':' may share the label
+				c = cn.findChild(':', colonKind(off, literals))
+			}
 			if c != nil {
 				// Go deeper
 				cn = c
@@ -134,6 +142,16 @@ func (r *Router) insert(method, path string, h []Handler, t kind, ppath string,
 	}
 }
 
+// colonKind returns the kind of the node starting with the ':' found at off
+func colonKind(off int, literals []int) kind {
+	for _, i := range literals {
+		if i == off {
+			return skind
+		}
+	}
+	return pkind
+}
+
 func newNode(t kind, pre string, p *node, c children, mh *methodHandler, ppath string, pnames []string) *node {
 	return &node{
 		kind:          t,
@@ -178,6 +196,37 @@ func (n *node) findChildByKind(t kind) *node {
 	return nil
 }
 
+// paramEnd returns the length of the value the param node n takes from
+// search. A param followed by a literal in the same segment runs up to the
+// last occurrence of that literal, so /:name.:ext matches /a.b.tar.gz with
+// name "a.b.tar" and ext "gz". Otherwise it runs to the end of the segment.
+func (n *node) paramEnd(search string) int {
+	segment := search
+	if i := strings.IndexByte(segment, '/'); i >= 0 {
+		segment = segment[:i]
+	}
+	end := -1
+	for _, c := range n.children {
+		if c.kind != skind {
+			continue
+		}
+		literal := c.prefix
+		if i := strings.IndexByte(literal, '/'); i >= 0 {
+			literal = literal[:i]
+		}
+		if literal == "" {
+			continue
+		}
+		if i := strings.LastIndex(segment, literal); i > end {
+			end = i
+		}
+	}
+	if end < 0 {
+		return len(segment)
+	}
+	return end
+}
+
 func (n *node) addHandler(method string, h []Handler) {
 	switch method {
 	case GET:
@@ -226,17 +275,28 @@ func (n *node) findHandler(method string) []Handler {
 	}
 }
 
+// allowedMethods returns the methods with a handler registered on the node
+func (n *node) allowedMethods() []string {
+	allowed := []string{}
+	for _, m := range methods {
+		if n.findHandler(m) != nil {
+			allowed = append(allowed, m)
+		}
+	}
+	return allowed
+}
+
 func (n *node) checkMethodNotAllowed() []Handler {
 	for _, m := range methods {
 		if h := n.findHandler(m); h != nil {
 			hs := []Handler{
-				MethodNotAllowedHandler,
+				methodNotAllowed,
 			}
 			return hs
 		}
 	}
 	hs := []Handler{
-		NotFoundHandler,
+		notFound,
 	}
 	return hs
 }
@@ -273,7 +333,7 @@ func (r *Router) Find(method, path string, c Context) {
 		pl := 0 // Prefix length
 		l := 0  // LCP length
 
-		if cn.label != ':' {
+		if cn.kind != pkind {
 			sl := len(search)
 			pl = len(cn.prefix)
 
@@ -333,9 +393,7 @@ func (r *Router) Find(method, path string, c Context) {
 			}
 
 			cn = child
-			i, l := 0, len(search)
-			for ; i < l && search[i] != '/'; i++ {
-			}
+			i := cn.paramEnd(search)
 			pvalues[n] = search[:i]
 
 			n++
@@ -366,6 +424,7 @@ func (r *Router) Find(method, path string, c Context) {
 
 End:
 	ctx.SetHandlers(cn.findHandler(method))
+	ctx.node = cn
 	ctx.path = cn.ppath
 	ctx.pnames = cn.pnames
 	for i, n := range ctx.pnames {
@@ -388,9 +447,11 @@ End:
 		} else {
 			ctx.SetHandlers(cn.checkMethodNotAllowed())
 		}
+		ctx.node = cn
 		ctx.path = cn.ppath
 		ctx.pnames = cn.pnames
 		pvalues[len(cn.pnames)-1] = ""
+		ctx.params[cn.pnames[len(cn.pnames)-1]] = ""
 	}
 
 	return
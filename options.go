@@ -0,0 +1,79 @@
+package chef
+
+import (
+	"github.com/gochef/chef/utils"
+)
+
+// EnvConfigFile is the environment variable holding the path of the config
+// file. When unset, the first of config.toml, config.yaml, config.yml and
+// config.json found in the working directory is used.
+const EnvConfigFile = "CHEF_CONFIG"
+
+type (
+	// Option customizes the instance created by New
+	Option func(c *Chef)
+
+	// RouterOptions tunes the router of an instance
+	RouterOptions struct {
+		// NotFound handles requests matching no route. Default value is
+		// NotFoundHandler
+		NotFound Handler
+
+		// MethodNotAllowed handles requests matching a route for other
+		// methods only. Default value is MethodNotAllowedHandler
+		MethodNotAllowed Handler
+	}
+)
+
+// WithConfigFile loads the config from path, taking precedence over
+// CHEF_CONFIG
+func WithConfigFile(path string) Option {
+	return func(c *Chef) {
+		c.configFile = path
+	}
+}
+
+// WithConfigOverride changes the config with fn once loaded, before anything
+// is set up from it. It is applied again on every reload.
+func WithConfigOverride(fn func(config *Config)) Option {
+	return func(c *Chef) {
+		c.overrides = append(c.overrides, fn)
+	}
+}
+
+// WithPort listens on port, i.e. ":8080", whatever App.Port is
+func WithPort(port string) Option {
+	return WithConfigOverride(func(config *Config) {
+		config.App.Port = port
+	})
+}
+
+// WithLogger uses logger instead of creating one from the [Logger] config
+// section
+func WithLogger(logger *utils.Logger) Option {
+	return func(c *Chef) {
+		c.logger = logger
+	}
+}
+
+// WithRenderer renders the views of Context.Render with r, i.e. the
+// *Templates returned by NewTemplates
+func WithRenderer(r Renderer) Option {
+	return func(c *Chef) {
+		c.renderer = r
+	}
+}
+
+// WithRouterOptions tunes the router with options
+func WithRouterOptions(options RouterOptions) Option {
+	return func(c *Chef) {
+		c.routerOptions = options
+	}
+}
+
+// applyOverrides applies the WithConfigOverride options to config
+func (c *Chef) applyOverrides(config *Config) {
+	for _, fn := range c.overrides {
+		fn(config)
+	}
+}
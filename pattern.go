@@ -0,0 +1,83 @@
+package chef
+
+import (
+	"strings"
+)
+
+// Handle registers h for a net/http (Go 1.22) style pattern such as
+// "GET /users/{id}" or "/files/{path...}". Without a method the route matches
+// every method. As with http.ServeMux, a pattern ending in "/" matches the
+// whole subtree unless it ends in "{$}".
+func (c *Chef) Handle(pattern string, h Handler) {
+	mthds, p := splitPattern(pattern)
+	for _, m := range mthds {
+		c.router.add(m, p, h, c.scope)
+	}
+}
+
+// Handle registers h for a net/http (Go 1.22) style pattern, see Chef.Handle
+func (g *Group) Handle(pattern string, h Handler) {
+	mthds, p := splitPattern(pattern)
+	for _, m := range mthds {
+		g.add(m, p, h)
+	}
+}
+
+// splitPattern returns the methods and the path of a stdlib pattern
+func splitPattern(pattern string) ([]string, string) {
+	pattern = strings.TrimSpace(pattern)
+	mthds := methods[:]
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		m := strings.ToUpper(pattern[:i])
+		mthds = []string{m}
+		if m == GET {
+			// like http.ServeMux, GET also matches HEAD
+			mthds = append(mthds, HEAD)
+		}
+		pattern = strings.TrimSpace(pattern[i+1:])
+	}
+	if pattern == "" || pattern[0] != '/' {
+		panic("chef: host patterns are not supported: " + pattern)
+	}
+
+	if strings.HasSuffix(pattern, "/{$}") {
+		pattern = strings.TrimSuffix(pattern, "{$}")
+	} else if strings.HasSuffix(pattern, "/") {
+		pattern += "*"
+	}
+	return mthds, pattern
+}
+
+// translatePattern rewrites the {name} and {name...} wildcards of net/http
+// patterns into :name and *name
+func translatePattern(p string) string {
+	if strings.IndexByte(p, '{') < 0 {
+		return p
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(p, '{')
+		if i < 0 {
+			b.WriteString(p)
+			return b.String()
+		}
+		j := strings.IndexByte(p[i:], '}')
+		if j < 0 {
+			panic("chef: unclosed wildcard in " + p)
+		}
+		b.WriteString(p[:i])
+
+		name := p[i+1 : i+j]
+		switch {
+		case name == "$":
+		case strings.HasSuffix(name, "..."):
+			b.WriteByte('*')
+			b.WriteString(strings.TrimSuffix(name, "..."))
+		default:
+			b.WriteByte(':')
+			b.WriteString(name)
+		}
+		p = p[i+j+1:]
+	}
+}
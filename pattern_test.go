@@ -0,0 +1,43 @@
+package chef
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		methods []string
+		path    string
+	}{
+		{"GET /users/{id}", []string{GET, HEAD}, "/users/{id}"},
+		{"post  /users", []string{POST}, "/users"},
+		{"/files/", methods[:], "/files/*"},
+		{"DELETE /files/{$}", []string{DELETE}, "/files/"},
+	}
+	for _, tt := range tests {
+		mthds, p := splitPattern(tt.pattern)
+		if !reflect.DeepEqual(mthds, tt.methods) || p != tt.path {
+			t.Errorf("splitPattern(%q) = %v %q, want %v %q", tt.pattern, mthds, p, tt.methods, tt.path)
+		}
+	}
+}
+
+func TestTranslatePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"/users/:id", "/users/:id"},
+		{"/users/{id}", "/users/:id"},
+		{"/users/{id}/posts/{post}", "/users/:id/posts/:post"},
+		{"/files/{path...}", "/files/*path"},
+		{"/files/{$}", "/files/"},
+	}
+	for _, tt := range tests {
+		if got := translatePattern(tt.pattern); got != tt.want {
+			t.Errorf("translatePattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package chef
+
+import (
+	"strconv"
+	"strings"
+)
+
+// HeaderPriority is the RFC 9218 priority header
+const HeaderPriority = "Priority"
+
+// Urgency bounds of RFC 9218, lower is more important
+const (
+	UrgencyHighest = 0
+	UrgencyDefault = 3
+	UrgencyLowest  = 7
+)
+
+type (
+	// Priority is the priority a client asked for its request
+	Priority struct {
+		Urgency     int
+		Incremental bool
+	}
+)
+
+// parsePriority parses the u and i parameters of a Priority header. Unknown
+// parameters and invalid values are ignored as required by RFC 9218.
+func parsePriority(h string) Priority {
+	p := Priority{Urgency: UrgencyDefault}
+	for _, param := range strings.Split(h, ",") {
+		param = strings.TrimSpace(param)
+		name, value := param, ""
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			name, value = param[:i], param[i+1:]
+		}
+
+		switch name {
+		case "u":
+			if u, err := strconv.Atoi(value); err == nil && u >= UrgencyHighest && u <= UrgencyLowest {
+				p.Urgency = u
+			}
+		case "i":
+			p.Incremental = value == "" || value == "?1"
+		}
+	}
+	return p
+}
@@ -0,0 +1,65 @@
+// Package profiling serves the net/http/pprof profiles and the expvar
+// variables through a chef application, behind an auth middleware.
+//
+// Importing net/http/pprof and expvar registers /debug/pprof/ and /debug/vars
+// on http.DefaultServeMux, so they live in this package rather than in chef:
+// only applications importing it get that side effect, and those shouldn't
+// serve http.DefaultServeMux.
+package profiling
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gochef/chef"
+)
+
+// Mount serves the profiles under prefix+"/pprof/" and the expvar variables at
+// prefix+"/vars", behind auth
+func Mount(c *chef.Chef, prefix string, auth chef.Handler) {
+	if auth == nil {
+		panic("chef: profiling endpoints require an auth middleware")
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		panic("chef: cannot mount profiling endpoints at the root path")
+	}
+
+	c.Group(prefix, func(g chef.Group) {
+		g.Use(auth)
+		g.GET("/pprof/*", serveProfile)
+		g.POST("/pprof/symbol", serve(http.HandlerFunc(pprof.Symbol)))
+		g.GET("/vars", serve(expvar.Handler()))
+	})
+}
+
+// serveProfile dispatches to the pprof handler of the requested profile, the
+// index listing them when none is
+func serveProfile(ctx chef.Context) {
+	var h http.Handler
+	switch name := ctx.Param("*"); name {
+	case "":
+		// pprof.Index only resolves profiles under /debug/pprof/, the
+		// others are served by name below
+		h = http.HandlerFunc(pprof.Index)
+	case "cmdline":
+		h = http.HandlerFunc(pprof.Cmdline)
+	case "profile":
+		h = http.HandlerFunc(pprof.Profile)
+	case "symbol":
+		h = http.HandlerFunc(pprof.Symbol)
+	case "trace":
+		h = http.HandlerFunc(pprof.Trace)
+	default:
+		h = pprof.Handler(name)
+	}
+	h.ServeHTTP(ctx.Response(), ctx.Request())
+}
+
+func serve(h http.Handler) chef.Handler {
+	return func(ctx chef.Context) {
+		h.ServeHTTP(ctx.Response(), ctx.Request())
+	}
+}
@@ -0,0 +1,174 @@
+package chef
+
+import (
+	"net"
+	"strings"
+)
+
+var (
+	// defaultTrustedProxies are trusted when Proxy.Trusted is empty: the
+	// loopback and private ranges load balancers usually connect from
+	defaultTrustedProxies = parseCIDRs([]string{
+		"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+	})
+
+	// defaultProxyHeaders are honored when Proxy.Headers is empty
+	defaultProxyHeaders = []string{HeaderXForwardedFor, HeaderXRealIP, HeaderXForwardedProto}
+
+	// proxyHeaders are the forwarded headers Proxy.Headers may list
+	proxyHeaders = []string{HeaderXForwardedFor, HeaderXRealIP, HeaderXForwardedProto, HeaderXForwardedSsl, HeaderForwarded}
+)
+
+// parseCIDRs parses CIDR ranges and single IPs, skipping invalid ones
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if n, ok := parseCIDR(cidr); ok {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func parseCIDR(cidr string) (*net.IPNet, bool) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, false
+		}
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, true
+	}
+	_, n, err := net.ParseCIDR(cidr)
+	return n, err == nil
+}
+
+// trustsProxy reports whether the forwarded headers sent by ip are honored
+func (config *Config) trustsProxy(ip net.IP) bool {
+	nets := defaultTrustedProxies
+	if config != nil && config.trustedProxies != nil {
+		nets = config.trustedProxies
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// honors reports whether the forwarded header is honored from trusted proxies
+func (config *Config) honors(header string) bool {
+	if config != nil && len(config.Proxy.Headers) > 0 {
+		return containsFold(config.Proxy.Headers, header)
+	}
+	return containsFold(defaultProxyHeaders, header)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the client IP from the hops of X-Forwarded-For or
+// Forwarded, the nearest one not being a trusted proxy
+func (config *Config) forwardedFor(hops []string, client string) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		client = hop
+		if !config.trustsProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedParams returns the values of the parameter key, i.e. "for" or
+// "proto", in the elements of the Forwarded headers, in order
+func forwardedParams(headers []string, key string) []string {
+	var values []string
+	for _, element := range forwardedElements(headers) {
+		if v, ok := element[strings.ToLower(key)]; ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// forwardedElements returns the parameters of each element of the Forwarded
+// headers, one element per hop, keyed by lowercase name
+func forwardedElements(headers []string) []map[string]string {
+	var elements []map[string]string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			params := map[string]string{}
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok {
+					params[strings.ToLower(k)] = forwardedNode(strings.Trim(v, `"`))
+				}
+			}
+			elements = append(elements, params)
+		}
+	}
+	return elements
+}
+
+// forwardedProto returns the proto of the Forwarded element describing the
+// client, found walking the elements from the nearest past trusted proxies
+// like forwardedFor. It's empty when that element has none.
+func (config *Config) forwardedProto(headers []string) string {
+	elements := forwardedElements(headers)
+	proto := ""
+	for i := len(elements) - 1; i >= 0; i-- {
+		proto = elements[i]["proto"]
+		ip := net.ParseIP(elements[i]["for"])
+		if ip == nil || !config.trustsProxy(ip) {
+			break
+		}
+	}
+	return proto
+}
+
+// clientHop returns the index of the client in the X-Forwarded-For hops,
+// walking from the nearest past trusted proxies like forwardedFor
+func (config *Config) clientHop(hops []string) int {
+	for i := len(hops) - 1; i > 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil || !config.trustsProxy(ip) {
+			return i
+		}
+	}
+	return 0
+}
+
+// forwardedNode strips the port and brackets of a Forwarded node, i.e.
+// "[2001:db8::1]:4711"
+func forwardedNode(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.Trim(node, "[]")
+}
+
+// splitHops splits the comma separated X-Forwarded-For headers
+func splitHops(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
@@ -0,0 +1,124 @@
+package chef
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func proxyTestContext(remote string, headers map[string]string) *context {
+	config := &Config{}
+	config.Proxy.Headers = []string{HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP, HeaderXForwardedProto}
+	config.trustedProxies = parseCIDRs([]string{"10.0.0.0/8"})
+
+	req := httptest.NewRequest(GET, "/", nil)
+	req.RemoteAddr = remote
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return &context{request: req, config: config}
+}
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "untrusted peer",
+			remote:  "203.0.113.9:1234",
+			headers: map[string]string{HeaderXForwardedFor: "1.1.1.1"},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "trusted peer",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderXForwardedFor: "1.1.1.1"},
+			want:    "1.1.1.1",
+		},
+		{
+			name:    "spoofed leftmost hop",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderXForwardedFor: "6.6.6.6, 1.1.1.1, 10.0.0.2"},
+			want:    "1.1.1.1",
+		},
+		{
+			name:    "only trusted hops",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderXForwardedFor: "10.0.0.3, 10.0.0.2"},
+			want:    "10.0.0.3",
+		},
+		{
+			name:    "forwarded",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderForwarded: `for=6.6.6.6, for="[2001:db8::1]:4711", for=10.0.0.2`},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "real ip",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderXRealIP: "1.1.1.1"},
+			want:    "1.1.1.1",
+		},
+	}
+	for _, tt := range tests {
+		if got := proxyTestContext(tt.remote, tt.headers).RealIP(); got != tt.want {
+			t.Errorf("%s: RealIP() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScheme(t *testing.T) {
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "untrusted peer",
+			remote:  "203.0.113.9:1234",
+			headers: map[string]string{HeaderXForwardedProto: "https"},
+			want:    "http",
+		},
+		{
+			name:    "trusted peer",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderXForwardedProto: "HTTPS"},
+			want:    "https",
+		},
+		{
+			name:    "proto set by the nearest proxy",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderXForwardedProto: "https, http"},
+			want:    "http",
+		},
+		{
+			name:   "proto appended along the hops",
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				HeaderXForwardedFor:   "6.6.6.6, 1.1.1.1, 10.0.0.2",
+				HeaderXForwardedProto: "https, http, https",
+			},
+			want: "http",
+		},
+		{
+			name:    "spoofed forwarded element",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderForwarded: "for=6.6.6.6;proto=https, for=1.1.1.1;proto=http"},
+			want:    "http",
+		},
+		{
+			name:    "forwarded through trusted hops",
+			remote:  "10.0.0.1:1234",
+			headers: map[string]string{HeaderForwarded: "for=1.1.1.1;proto=https, for=10.0.0.2;proto=http"},
+			want:    "https",
+		},
+	}
+	for _, tt := range tests {
+		if got := proxyTestContext(tt.remote, tt.headers).Scheme(); got != tt.want {
+			t.Errorf("%s: Scheme() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package chef
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+type (
+	// SectionMiddleware builds the middleware configured by a config section
+	SectionMiddleware func(config *Config) (Handler, error)
+
+	sectionMiddleware struct {
+		section string
+		build   SectionMiddleware
+	}
+)
+
+var (
+	registry = struct {
+		sync.RWMutex
+		m map[string]Handler
+	}{
+		m: map[string]Handler{},
+	}
+
+	// sections are kept in registration order, which is the order they run in
+	sections = struct {
+		sync.RWMutex
+		list []sectionMiddleware
+	}{}
+)
+
+// RegisterMiddleware makes m available under name to the [Middleware] config
+// section. Register before calling New, typically from an init function.
+func RegisterMiddleware(name string, m Handler) {
+	registry.Lock()
+	registry.m[strings.ToLower(name)] = m
+	registry.Unlock()
+}
+
+// LookupMiddleware returns the middleware registered under name
+func LookupMiddleware(name string) (Handler, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	m, ok := registry.m[strings.ToLower(name)]
+	return m, ok
+}
+
+// RegisterSectionMiddleware makes New enable the middleware built by build
+// when the config has the section, i.e. [CORS], typically decoding its
+// settings with Config.Unmarshal. Section middlewares run before the ones
+// listed in [Middleware] and can be switched off by section name in
+// Middleware.Disabled. The built-in ones are registered by importing the
+// middleware package.
+func RegisterSectionMiddleware(section string, build SectionMiddleware) {
+	sections.Lock()
+	defer sections.Unlock()
+	for i, s := range sections.list {
+		if strings.EqualFold(s.section, section) {
+			sections.list[i].build = build
+			return
+		}
+	}
+	sections.list = append(sections.list, sectionMiddleware{section: section, build: build})
+}
+
+// sectionMiddlewares builds the middlewares of the sections present in config
+func sectionMiddlewares(config *Config) ([]Handler, error) {
+	sections.RLock()
+	defer sections.RUnlock()
+	var hs []Handler
+	for _, s := range sections.list {
+		if !config.hasSection(s.section) {
+			continue
+		}
+		m, err := s.build(config)
+		if err != nil {
+			return nil, errors.New("chef: invalid [" + s.section + "] config: " + err.Error())
+		}
+		hs = append(hs, toggled(config, s.section, m))
+	}
+	return hs, nil
+}
+
+// configuredMiddlewares resolves the names listed in config, in order. Each
+// one is skipped while listed in Middleware.Disabled, which is reloadable.
+func configuredMiddlewares(config *Config, names []string) []Handler {
+	hs := make([]Handler, 0, len(names))
+	for _, name := range names {
+		m, ok := LookupMiddleware(name)
+		if !ok {
+			panic("chef: unknown middleware " + name)
+		}
+		hs = append(hs, toggled(config, name, m))
+	}
+	return hs
+}
+
+// toggled runs m unless name is disabled in the config of the request, or in
+// config outside of the router
+func toggled(config *Config, name string, m Handler) Handler {
+	return func(ctx Context) {
+		current := config
+		if c, ok := ctx.(*context); ok && c.config != nil {
+			current = c.config
+		}
+		for _, disabled := range current.Middleware.Disabled {
+			if strings.EqualFold(disabled, name) {
+				ctx.Next()
+				return
+			}
+		}
+		m(ctx)
+	}
+}
@@ -0,0 +1,155 @@
+package chef
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// reloadable lists the config fields ReloadConfig applies at runtime, by
+// path prefix. The others need a restart.
+var reloadable = []string{
+	"Logger.Level",
+	"Cache.",
+	"Flags",
+	"Time.",
+	"Server.MaxQueryParams",
+	"Server.MaxQueryLength",
+	"Server.MaxMultipartParts",
+	"Server.AutoOptions",
+	"Middleware.Disabled",
+	"Server.ShutdownTimeout",
+	"Scheduler.",
+	"Dev.",
+}
+
+// ReloadConfig reads the config file again and applies the changes that are
+// safe at runtime: log level, cache settings, feature flags, time settings,
+// request limits, middleware toggles, scheduler jitter and the sections read
+// by Config.Unmarshal. Every change is logged, noting those that need a
+// restart. It is called on SIGHUP and on changes of the config source.
+func (c *Chef) ReloadConfig() error {
+	if c.configFile == "" && c.source == nil {
+		return errors.New("chef: config wasn't loaded from a file")
+	}
+	loaded, err := c.readConfig()
+	if err != nil {
+		return err
+	}
+	c.applyOverrides(loaded)
+	setDefaults(loaded)
+	if err := loaded.Validate(); err != nil {
+		return err
+	}
+	times, err := newTimeSettings(loaded)
+	if err != nil {
+		return err
+	}
+
+	current := c.Config()
+	logger := c.logger.GetModuleLogger("chef")
+	for _, change := range configDiff(reflect.ValueOf(*current), reflect.ValueOf(*loaded), "") {
+		if isReloadable(change.path) {
+			logger.Noticef("%s: %s -> %s", change.path, change.from, change.to)
+		} else {
+			logger.Warningf("%s: %s -> %s, restart to apply", change.path, change.from, change.to)
+		}
+	}
+
+	// requests keep reading the current config, the changes go to a copy
+	// replacing it
+	next := *current
+	if loaded.Logger.Level != "" && loaded.Logger.Level != current.Logger.Level {
+		if err := c.logger.SetLevel(loaded.Logger.Level); err != nil {
+			return err
+		}
+		logger := *current.Logger
+		logger.Level = loaded.Logger.Level
+		next.Logger = &logger
+	}
+	next.Cache = loaded.Cache
+	next.Flags = loaded.Flags
+	next.Time = loaded.Time
+	next.Server.MaxQueryParams = loaded.Server.MaxQueryParams
+	next.Server.MaxQueryLength = loaded.Server.MaxQueryLength
+	next.Server.MaxMultipartParts = loaded.Server.MaxMultipartParts
+	next.Server.AutoOptions = loaded.Server.AutoOptions
+	next.Server.ShutdownTimeout = loaded.Server.ShutdownTimeout
+	next.Middleware.Disabled = loaded.Middleware.Disabled
+	next.Scheduler = loaded.Scheduler
+	next.Dev = loaded.Dev
+	next.extra = loaded.extra
+	next.times = times
+	c.config.Store(&next)
+	// Time values follow the app that set them, see run
+	timeDefaults.CompareAndSwap(current.times, times)
+	c.loadFlags()
+	return nil
+}
+
+type configChange struct {
+	path, from, to string
+}
+
+// configDiff returns the fields differing between a and b
+func configDiff(a, b reflect.Value, path string) []configChange {
+	if a.Kind() == reflect.Ptr {
+		if a.IsNil() && b.IsNil() {
+			return nil
+		}
+		// a missing section compares as a zero one
+		if a.IsNil() {
+			a = reflect.New(a.Type().Elem())
+		}
+		if b.IsNil() {
+			b = reflect.New(b.Type().Elem())
+		}
+		return configDiff(a.Elem(), b.Elem(), path)
+	}
+
+	if a.Kind() == reflect.Struct {
+		var changes []configChange
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			if field.PkgPath != "" || field.Type.Kind() == reflect.Interface {
+				continue
+			}
+			name := field.Name
+			if path != "" {
+				name = path + "." + name
+			}
+			changes = append(changes, configDiff(a.Field(i), b.Field(i), name)...)
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return nil
+	}
+	change := configChange{path: path, from: fmt.Sprint(a.Interface()), to: fmt.Sprint(b.Interface())}
+	if isSecretField(path) {
+		change.from, change.to = "***", "***"
+	}
+	return []configChange{change}
+}
+
+func isReloadable(path string) bool {
+	for _, prefix := range reloadable {
+		if path == prefix || strings.HasSuffix(prefix, ".") && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecretField reports whether the value at path must not be logged
+func isSecretField(path string) bool {
+	name := strings.ToLower(path[strings.LastIndexByte(path, '.')+1:])
+	for _, s := range []string{"password", "secret", "token", "key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package chef
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+type (
+	// ErrorReporter forwards server errors to a tracking service such as
+	// Sentry or Rollbar. Report is called on the request goroutine, so slow
+	// reporters should queue the report and return.
+	ErrorReporter interface {
+		Report(report ErrorReport)
+	}
+
+	// ErrorReporterFunc adapts a function to the ErrorReporter interface
+	ErrorReporterFunc func(report ErrorReport)
+
+	// ErrorReport holds a server error and the request it happened on. The
+	// credentials of the request (Authorization, Cookie and CSRF token
+	// headers) are left out of Header.
+	ErrorReport struct {
+		Err       error
+		Panic     bool
+		Stack     []byte
+		Status    int
+		Method    string
+		URL       string
+		Route     string
+		RealIP    string
+		RequestID string
+		Header    http.Header
+	}
+
+	// panicError is the error recorded by Recover
+	panicError struct {
+		value interface{}
+		stack []byte
+	}
+)
+
+// reportRedactedHeaders are removed from the request header of reports
+var reportRedactedHeaders = []string{
+	HeaderAuthorization,
+	"Proxy-Authorization",
+	HeaderCookie,
+	HeaderXCSRFToken,
+	"X-XSRF-Token",
+}
+
+// Report calls fn(report)
+func (fn ErrorReporterFunc) Report(report ErrorReport) {
+	fn(report)
+}
+
+// SetErrorReporter registers the reporter receiving panics recovered by
+// Recover and every response with a 5xx status
+func (c *Chef) SetErrorReporter(r ErrorReporter) {
+	c.router.reporter = r
+}
+
+// Recover is a middleware turning panics of the rest of the chain into 500
+// responses. The panic is recorded as the request error with its stack.
+func Recover(ctx Context) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			ctx.Error(&panicError{value: rec, stack: debug.Stack()})
+		}
+	}()
+	ctx.Next()
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// report sends the request error to the reporter if the response failed
+func (r *Router) report(ctx *context) {
+	status := ctx.response.Status
+	if r.reporter == nil || status < http.StatusInternalServerError {
+		return
+	}
+
+	req := ctx.request
+	report := ErrorReport{
+		Err:       ctx.err,
+		Status:    status,
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Route:     ctx.path,
+		RealIP:    ctx.RealIP(),
+		RequestID: req.Header.Get(HeaderXRequestID),
+		Header:    req.Header.Clone(),
+	}
+	for _, h := range reportRedactedHeaders {
+		report.Header.Del(h)
+	}
+	if report.Err == nil {
+		report.Err = errors.New(http.StatusText(status))
+	}
+	var pe *panicError
+	if errors.As(report.Err, &pe) {
+		report.Panic = true
+		report.Stack = pe.stack
+	}
+	r.reporter.Report(report)
+}
@@ -0,0 +1,80 @@
+package chef
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+type (
+	// Response wraps an http.ResponseWriter and keeps track of the status code
+	// and number of bytes written
+	Response struct {
+		Writer    http.ResponseWriter
+		Status    int
+		Size      int64
+		Committed bool
+	}
+)
+
+// NewResponse returns a response instance wrapping w
+func NewResponse(w http.ResponseWriter) *Response {
+	return &Response{
+		Writer: w,
+		Status: http.StatusOK,
+	}
+}
+
+// Header returns the header map that will be sent by WriteHeader
+func (r *Response) Header() http.Header {
+	return r.Writer.Header()
+}
+
+// WriteHeader sends the status code. Only the first call has any effect.
+func (r *Response) WriteHeader(code int) {
+	if r.Committed {
+		return
+	}
+	r.Status = code
+	r.Writer.WriteHeader(code)
+	r.Committed = true
+}
+
+// Write writes the data to the connection, committing the response first if needed
+func (r *Response) Write(b []byte) (int, error) {
+	if !r.Committed {
+		r.WriteHeader(r.Status)
+	}
+	n, err := r.Writer.Write(b)
+	r.Size += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client
+func (r *Response) Flush() {
+	if f, ok := r.Writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection
+func (r *Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.Writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("chef: response does not implement http.Hijacker")
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the original http.ResponseWriter, for http.ResponseController
+func (r *Response) Unwrap() http.ResponseWriter {
+	return r.Writer
+}
+
+func (r *Response) reset(w http.ResponseWriter) {
+	r.Writer = w
+	r.Status = http.StatusOK
+	r.Size = 0
+	r.Committed = false
+}
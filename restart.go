@@ -0,0 +1,181 @@
+package chef
+
+import (
+	stdctx "context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// envListenFDs holds the number of listening sockets passed to the new
+	// process on a restart, starting at listenFDsStart
+	envListenFDs = "CHEF_LISTEN_FDS"
+
+	// envListenFDNames holds the colon separated role of each passed socket
+	envListenFDNames = "CHEF_LISTEN_FDNAMES"
+
+	// envReadyFD holds the descriptor the new process writes to once it is
+	// about to accept requests
+	envReadyFD = "CHEF_READY_FD"
+
+	// listenerApp and listenerRedirect are the roles of the listening
+	// sockets, so the new process serves each one as before
+	listenerApp      = "app"
+	listenerRedirect = "redirect"
+
+	// restartReadyTimeout bounds the wait for the new process to be ready
+	restartReadyTimeout = time.Minute
+)
+
+// inherited holds the sockets passed to this process by role, read once
+var inherited struct {
+	once sync.Once
+	lock sync.Mutex
+	lns  map[string][]net.Listener
+	err  error
+}
+
+// inheritedListeners returns the sockets of role passed by systemd or by the
+// process that restarted into this one, if any. Each is returned only once.
+// The sockets passed by systemd are all served by the app.
+func inheritedListeners(role string) ([]net.Listener, error) {
+	inherited.once.Do(func() {
+		inherited.lns, inherited.err = readInherited()
+	})
+	inherited.lock.Lock()
+	defer inherited.lock.Unlock()
+	lns := inherited.lns[role]
+	delete(inherited.lns, role)
+	return lns, inherited.err
+}
+
+func readInherited() (map[string][]net.Listener, error) {
+	lns, err := systemdListeners()
+	if err != nil || len(lns) > 0 {
+		return map[string][]net.Listener{listenerApp: lns}, err
+	}
+
+	n, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenFDNames)
+
+	lns, err = fileListeners(n, names)
+	if err != nil {
+		return nil, err
+	}
+	byRole := map[string][]net.Listener{}
+	for i, ln := range lns {
+		role := listenerApp
+		if i < len(names) && names[i] != "" {
+			role = names[i]
+		}
+		byRole[role] = append(byRole[role], ln)
+	}
+	return byRole, nil
+}
+
+// listenRole returns the inherited sockets of role or else listens on addr,
+// within the configured connection limits
+func (c *Chef) listenRole(role, addr string) ([]net.Listener, error) {
+	lns, err := inheritedListeners(role)
+	if err != nil {
+		return nil, err
+	}
+	if len(lns) == 0 {
+		ln, err := c.listen(addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+
+	c.logger.GetModuleLogger("chef").Noticef("Serving %s on %d inherited sockets", role, len(lns))
+	srv := c.Config().Server
+	for i, ln := range lns {
+		lns[i] = LimitListener(ln, srv.MaxConns, srv.MaxConnsPerIP, srv.RejectWith503)
+	}
+	return lns, nil
+}
+
+// keepListeners records the listening sockets of role, passed to the new
+// process on a restart
+func (c *Chef) keepListeners(role string, lns []net.Listener) {
+	c.lifecycle.lock.Lock()
+	if c.lifecycle.listeners == nil {
+		c.lifecycle.listeners = map[string][]net.Listener{}
+	}
+	c.lifecycle.listeners[role] = lns
+	c.lifecycle.lock.Unlock()
+}
+
+// listenerFile returns a duplicate of the file descriptor of ln
+func listenerFile(ln net.Listener) (*os.File, error) {
+	if l, ok := ln.(*limitListener); ok {
+		ln = l.Listener
+	}
+	if l, ok := ln.(*net.UnixListener); ok {
+		// the socket file must outlive this process
+		l.SetUnlinkOnClose(false)
+	}
+	f, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("chef: cannot pass " + ln.Addr().String() + " to a new process")
+	}
+	return f.File()
+}
+
+// notifyReady tells systemd and the process that restarted into this one, if
+// any, that the server is about to accept requests
+func notifyReady() {
+	sdNotify("READY=1")
+
+	fd, err := strconv.Atoi(os.Getenv(envReadyFD))
+	if err != nil {
+		return
+	}
+	os.Unsetenv(envReadyFD)
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// waitReady waits for the new process to write to r, see notifyReady
+func waitReady(r *os.File) error {
+	r.SetReadDeadline(time.Now().Add(restartReadyTimeout))
+	_, err := r.Read(make([]byte, 1))
+	switch {
+	case err == io.EOF:
+		return errors.New("chef: new process exited before serving")
+	case os.IsTimeout(err):
+		return errors.New("chef: new process not ready after " + restartReadyTimeout.String())
+	}
+	return err
+}
+
+// handOver restarts the process and, once the new one is ready, drains this
+// one, reporting whether the new process took over
+func (c *Chef) handOver() bool {
+	logger := c.logger.GetModuleLogger("chef")
+	pid, err := c.restart()
+	if err != nil {
+		logger.Errorf("Restart failed: %s", err)
+		return false
+	}
+	// systemd tracks the new process, which outlives this one
+	sdNotify("MAINPID=" + strconv.Itoa(pid))
+	logger.Noticef("Restarted as process %d, draining", pid)
+	ctx, cancel := stdctx.WithTimeout(stdctx.Background(), c.shutdownTimeout())
+	defer cancel()
+	c.Shutdown(ctx)
+	return true
+}
@@ -0,0 +1,104 @@
+//go:build !windows
+
+package chef
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// watchRestart restarts the process on SIGUSR2 until done is closed: a new
+// process is started with the listening sockets and this one drains its
+// in-flight requests
+func (c *Chef) watchRestart(done <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-signals:
+			if c.handOver() {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// restart starts the running executable again, passing it the listening
+// sockets, and returns its pid once it is ready to serve. The new process is
+// killed if it isn't ready in time.
+func (c *Chef) restart() (int, error) {
+	c.lifecycle.lock.Lock()
+	roles := make([]string, 0, len(c.lifecycle.listeners))
+	for role := range c.lifecycle.listeners {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	var (
+		lns   []net.Listener
+		names []string
+	)
+	for _, role := range roles {
+		for _, ln := range c.lifecycle.listeners[role] {
+			lns = append(lns, ln)
+			names = append(names, role)
+		}
+	}
+	c.lifecycle.lock.Unlock()
+
+	files := make([]*os.File, 0, len(lns)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range lns {
+		f, err := listenerFile(ln)
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	files = append(files, w)
+
+	path, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		envListenFDs+"="+strconv.Itoa(len(lns)),
+		envListenFDNames+"="+strings.Join(names, ":"),
+		envReadyFD+"="+strconv.Itoa(listenFDsStart+len(lns)),
+	)
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	// only the new process holds the write end, so it exiting ends the wait
+	w.Close()
+
+	if err := waitReady(r); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	go cmd.Wait()
+	return cmd.Process.Pid, nil
+}
@@ -0,0 +1,11 @@
+package chef
+
+import "errors"
+
+// watchRestart does nothing, restarts rely on SIGUSR2
+func (c *Chef) watchRestart(done <-chan struct{}) {}
+
+// restart fails, listening sockets can't be passed to a new process
+func (c *Chef) restart() (int, error) {
+	return 0, errors.New("chef: restarts are not supported on windows")
+}
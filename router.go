@@ -1,8 +1,12 @@
 package chef
 
 import (
+	stdctx "context"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type (
@@ -22,8 +26,25 @@ type (
 		routes      map[string]*route
 		middlewares []Handler
 		after       []Handler
-		config      *Config
+		config      *configHolder
 		maxParam    *int
+
+		// closing is closed when the application starts shutting down, see
+		// Context.Closing
+		accepts      map[string][]string
+		optionsHooks []Handler
+
+		closing   chan struct{}
+		closeOnce sync.Once
+		streams   int64
+
+		reporter ErrorReporter
+
+		// chainLog receives the handler chain of every request, see Server.DebugChain
+		chainLog func(format string, args ...interface{})
+
+		options  RouterOptions
+		renderer Renderer
 	}
 )
 
@@ -40,6 +61,25 @@ var (
 	}
 )
 
+// notFound runs the NotFound handler of the router, or NotFoundHandler
+func notFound(c Context) {
+	if ctx, ok := c.(*context); ok && ctx.router != nil && ctx.router.options.NotFound != nil {
+		ctx.router.options.NotFound(c)
+		return
+	}
+	NotFoundHandler(c)
+}
+
+// methodNotAllowed runs the MethodNotAllowed handler of the router, or
+// MethodNotAllowedHandler
+func methodNotAllowed(c Context) {
+	if ctx, ok := c.(*context); ok && ctx.router != nil && ctx.router.options.MethodNotAllowed != nil {
+		ctx.router.options.MethodNotAllowed(c)
+		return
+	}
+	MethodNotAllowedHandler(c)
+}
+
 // NewRouter returns a router instance
 func NewRouter(config *Config) *Router {
 	r := &Router{
@@ -47,8 +87,10 @@ func NewRouter(config *Config) *Router {
 			methodHandler: new(methodHandler),
 		},
 		routes:   map[string]*route{},
-		config:   config,
+		config:   newConfigHolder(config),
 		maxParam: new(int),
+		accepts:  map[string][]string{},
+		closing:  make(chan struct{}),
 	}
 	r.pool.New = func() interface{} {
 		return NewContext(nil, nil, r.maxParam)
@@ -63,13 +105,12 @@ func (r *Router) add(method, path string, h Handler, hs []Handler) {
 	if path == "" {
 		panic("chef: path cannot be empty")
 	}
-	if path[0] != '/' {
-		path = "/" + path
-	}
+	path = routePattern(path)
 	pnames := []string{} // Param names
 	ppath := path        // Pristine path
+	literals := []int{}  // Offsets of escaped ':' kept as static text
 
-	handlers := r.middlewares
+	handlers := append([]Handler{}, r.middlewares...)
 	if hs != nil {
 		handlers = append(handlers, hs...)
 	}
@@ -77,11 +118,21 @@ func (r *Router) add(method, path string, h Handler, hs []Handler) {
 	handlers = append(handlers, r.after...)
 
 	for i, l := 0, len(path); i < l; i++ {
-		if path[i] == ':' {
+		if path[i] == '\\' && i+1 < l && path[i+1] == ':' {
+			path = path[:i] + path[i+1:]
+			literals = append(literals, i)
+			l--
+		} else if path[i] == ':' {
 			j := i + 1
 
-			r.insert(method, path[:i], nil, skind, "", nil)
-			for ; i < l && path[i] != '/'; i++ {
+			r.insert(method, path[:i], nil, skind, "", nil, literals)
+			for i++; i < l && isParamChar(path[i]); i++ {
+			}
+			if i == j {
+				panic("chef: missing param name in " + ppath)
+			}
+			if i < l && path[i] == ':' {
+				panic("chef: params must be separated by a literal in " + ppath)
 			}
 
 			pnames = append(pnames, path[j:i])
@@ -89,25 +140,44 @@ func (r *Router) add(method, path string, h Handler, hs []Handler) {
 			i, l = j, len(path)
 
 			if i == l {
-				r.insert(method, path[:i], handlers, pkind, ppath, pnames)
+				r.insert(method, path[:i], handlers, pkind, ppath, pnames, literals)
 				return
 			}
-			r.insert(method, path[:i], nil, pkind, ppath, pnames)
+			r.insert(method, path[:i], nil, pkind, ppath, pnames, literals)
+			i--
 		} else if path[i] == '*' {
-			r.insert(method, path[:i], nil, skind, "", nil)
-			pnames = append(pnames, "*")
-			r.insert(method, path[:i+1], handlers, akind, ppath, pnames)
+			r.insert(method, path[:i], nil, skind, "", nil, literals)
+			name := path[i+1:]
+			if name == "" {
+				name = "*"
+			}
+			pnames = append(pnames, name)
+			r.insert(method, path[:i+1], handlers, akind, ppath, pnames, literals)
 			return
 		}
 	}
 
-	r.insert(method, path, handlers, skind, ppath, pnames)
+	r.insert(method, path, handlers, skind, ppath, pnames, literals)
+}
+
+// isParamChar reports whether b can be part of a param name. Any other
+// character ends the name, so a segment can hold several params such as
+// /report/:year-:month or /files/:name.:ext. A param followed by a literal
+// matches up to the last occurrence of that literal in the segment.
+func isParamChar(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
 }
 
 func (r *Router) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	ctx := r.pool.Get().(*context)
 	defer r.pool.Put(ctx)
-	ctx.reset(req, res, r.config)
+	ctx.reset(req, res, r.config.Load())
+	ctx.router = r
+	defer ctx.untrackStream()
+
+	if !r.checkLimits(req, ctx.response) {
+		return
+	}
 
 	method := req.Method
 	path := req.URL.RawPath
@@ -117,5 +187,81 @@ func (r *Router) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 
 	r.Find(method, path, ctx)
 
+	if config := r.config.Load(); method == OPTIONS && config != nil && config.Server.AutoOptions {
+		if ctx.node != nil && ctx.node.findHandler(OPTIONS) == nil && len(ctx.node.allowedMethods()) > 0 {
+			ctx.SetHandlers(append(append([]Handler{}, r.middlewares...), r.describe))
+		}
+	}
+
+	if explainEnabled(ctx) {
+		ctx.tracing = true
+		ctx.response.Header().Set("Trailer", HeaderXChefExplain)
+		defer func() {
+			ctx.response.Header().Set(HeaderXChefExplain, ctx.explainSummary())
+		}()
+	}
+	if r.chainLog != nil {
+		ctx.tracing = true
+		defer func() {
+			r.chainLog("%s %s -> %d: %s", method, req.URL.Path, ctx.response.Status, ctx.explainSummary())
+		}()
+	}
+
 	ctx.Next()
+	r.report(ctx)
+}
+
+// routePattern returns path as registered by add, which is what Context.Path
+// reports for the route
+func routePattern(path string) string {
+	if path == "" || path[0] != '/' {
+		path = "/" + path
+	}
+	return translatePattern(path)
+}
+
+// CloseStreams signals every request that asked for Context.Closing to wrap
+// up, i.e. with Context.SSEGoaway or WebSocketGoaway, then waits for them to
+// return or for ctx to expire
+func (r *Router) CloseStreams(ctx stdctx.Context) error {
+	r.closeOnce.Do(func() {
+		close(r.closing)
+	})
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&r.streams) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// describe answers OPTIONS requests with the capabilities of the matched
+// route: allowed methods, accepted content types and whatever the options
+// hooks add
+func (r *Router) describe(c Context) {
+	ctx := c.(*context)
+	allowed := append(ctx.node.allowedMethods(), OPTIONS)
+	c.SetHeader(HeaderAllow, strings.Join(allowed, ", "))
+
+	if types := r.accepts[ctx.path]; len(types) > 0 {
+		accept := strings.Join(types, ", ")
+		for _, m := range allowed {
+			switch m {
+			case POST:
+				c.SetHeader(HeaderAcceptPost, accept)
+			case PATCH:
+				c.SetHeader(HeaderAcceptPatch, accept)
+			}
+		}
+	}
+
+	for _, h := range r.optionsHooks {
+		h(c)
+	}
+	c.SetStatusCode(http.StatusNoContent)
 }
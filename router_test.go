@@ -0,0 +1,71 @@
+package chef
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRouterParams(t *testing.T) {
+	r := NewRouter(&Config{})
+	for _, p := range []string{
+		"/report/:year-:month",
+		"/files/:name.:ext",
+		"/files/:name/raw",
+		"/archives/:name.tar.gz",
+		"/users/:id",
+		"/users/:id/posts",
+		`/time/12\:30`,
+		"/time/:id",
+		"/static/*",
+		"/{owner}/{repo}/blob/{path...}",
+	} {
+		r.add(GET, p, func(Context) {}, nil)
+	}
+
+	tests := []struct {
+		path   string
+		route  string
+		params map[string]string
+	}{
+		{"/report/2024-05", "/report/:year-:month", map[string]string{"year": "2024", "month": "05"}},
+		{"/files/readme.md", "/files/:name.:ext", map[string]string{"name": "readme", "ext": "md"}},
+		{"/files/a.b.tar.gz", "/files/:name.:ext", map[string]string{"name": "a.b.tar", "ext": "gz"}},
+		{"/files/readme/raw", "/files/:name/raw", map[string]string{"name": "readme"}},
+		{"/archives/a.b.tar.gz", "/archives/:name.tar.gz", map[string]string{"name": "a.b"}},
+		{"/users/42", "/users/:id", map[string]string{"id": "42"}},
+		{"/users/42/posts", "/users/:id/posts", map[string]string{"id": "42"}},
+		{"/time/12:30", `/time/12\:30`, map[string]string{}},
+		{"/time/77", "/time/:id", map[string]string{"id": "77"}},
+		{"/static/css/app.css", "/static/*", map[string]string{"*": "css/app.css"}},
+		{"/gochef/chef/blob/main/router.go", "/:owner/:repo/blob/*path", map[string]string{"owner": "gochef", "repo": "chef", "path": "main/router.go"}},
+	}
+	for _, tt := range tests {
+		ctx := NewContext(httptest.NewRequest(GET, tt.path, nil), httptest.NewRecorder(), r.maxParam).(*context)
+		r.Find(GET, tt.path, ctx)
+		if ctx.Path() != tt.route {
+			t.Errorf("%s: route %q, want %q", tt.path, ctx.Path(), tt.route)
+		}
+		if !reflect.DeepEqual(ctx.params, tt.params) {
+			t.Errorf("%s: params %v, want %v", tt.path, ctx.params, tt.params)
+		}
+	}
+}
+
+func TestRouterInvalidPattern(t *testing.T) {
+	tests := []string{
+		"/users/:",
+		"/report/:year:month",
+		"/users/{id",
+	}
+	for _, p := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", p)
+				}
+			}()
+			NewRouter(&Config{}).add(GET, p, func(Context) {}, nil)
+		}()
+	}
+}
@@ -0,0 +1,57 @@
+package chef
+
+import (
+	stdctx "context"
+	"runtime/debug"
+)
+
+// Go runs fn on a goroutine tracked by the application: a panic is recovered
+// and logged instead of crashing the process, and Shutdown cancels ctx then
+// waits for fn to return before running the stop hooks. Like jobs, fn must
+// not keep the request Context.
+func (c *Chef) Go(fn func(ctx stdctx.Context)) {
+	lc := c.lifecycle
+	lc.lock.Lock()
+	if lc.routinesCtx == nil {
+		lc.routinesCtx, lc.routinesCancel = stdctx.WithCancel(stdctx.Background())
+	}
+	ctx := lc.routinesCtx
+	lc.routines.Add(1)
+	lc.lock.Unlock()
+
+	go func() {
+		defer lc.routines.Done()
+		defer func() {
+			if rec := recover(); rec != nil {
+				c.logger.GetModuleLogger("chef").Errorf("Goroutine panicked: %v\n%s", rec, debug.Stack())
+			}
+		}()
+		fn(ctx)
+	}()
+}
+
+// waitRoutines cancels the goroutines started by Go and waits for them until
+// ctx expires
+func (c *Chef) waitRoutines(ctx stdctx.Context) error {
+	lc := c.lifecycle
+	lc.lock.Lock()
+	cancel := lc.routinesCancel
+	lc.routinesCtx, lc.routinesCancel = nil, nil
+	lc.lock.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		lc.routines.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
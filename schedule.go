@@ -0,0 +1,140 @@
+package chef
+
+import (
+	stdctx "context"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// scheduler runs the scheduled tasks while the server is running
+	scheduler struct {
+		c *Chef
+
+		lock    sync.Mutex
+		tasks   []*scheduledTask
+		ctx     stdctx.Context
+		cancel  stdctx.CancelFunc
+		running sync.WaitGroup
+	}
+
+	scheduledTask struct {
+		spec     string
+		schedule *cronSchedule
+		fn       func(ctx stdctx.Context)
+		busy     int32
+	}
+)
+
+// Schedule runs fn on the cron schedule spec while the server is running,
+// i.e. "*/5 * * * *", "@daily" or "@every 30s". Times are in Time.Zone. A run
+// is skipped while the previous one is still in progress, and delayed by up
+// to Scheduler.Jitter so instances don't all fire at once. Panics on an
+// invalid spec.
+func (c *Chef) Schedule(spec string, fn func(ctx stdctx.Context)) {
+	schedule, err := parseCron(spec)
+	if err != nil {
+		panic(err.Error())
+	}
+	c.scheduler.add(&scheduledTask{spec: spec, schedule: schedule, fn: fn})
+}
+
+func newScheduler(c *Chef) *scheduler {
+	return &scheduler{c: c}
+}
+
+func (s *scheduler) add(task *scheduledTask) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tasks = append(s.tasks, task)
+	if s.ctx != nil {
+		go s.loop(s.ctx, task)
+	}
+}
+
+// start runs the tasks until stop
+func (s *scheduler) start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx != nil {
+		return
+	}
+	s.ctx, s.cancel = stdctx.WithCancel(stdctx.Background())
+	for _, task := range s.tasks {
+		go s.loop(s.ctx, task)
+	}
+}
+
+// stop cancels the running tasks and waits for them until ctx expires
+func (s *scheduler) stop(ctx stdctx.Context) error {
+	s.lock.Lock()
+	cancel := s.cancel
+	s.ctx, s.cancel = nil, nil
+	s.lock.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *scheduler) loop(ctx stdctx.Context, task *scheduledTask) {
+	for {
+		config := s.c.Config()
+		next := task.schedule.next(time.Now().In(timesOf(config).location))
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next) + jitter(config))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !atomic.CompareAndSwapInt32(&task.busy, 0, 1) {
+			s.c.logger.GetModuleLogger("chef").Warningf("Skipping %s, previous run still in progress", task.spec)
+			continue
+		}
+		s.running.Add(1)
+		go s.run(ctx, task)
+	}
+}
+
+// jitter returns a random delay below Scheduler.Jitter. It is read for every
+// run so a reloaded config applies to the next one.
+func jitter(config *Config) time.Duration {
+	max := config.Scheduler.Jitter.Or(0)
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// run calls the task, logging panics
+func (s *scheduler) run(ctx stdctx.Context, task *scheduledTask) {
+	defer s.running.Done()
+	defer atomic.StoreInt32(&task.busy, 0)
+	defer func() {
+		if rec := recover(); rec != nil {
+			s.c.logger.GetModuleLogger("chef").Errorf("Scheduled task %s panicked: %v\n%s", task.spec, rec, debug.Stack())
+		}
+	}()
+	task.fn(ctx)
+}
@@ -0,0 +1,27 @@
+package chef
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter Duration
+	}{
+		{"disabled", 0},
+		{"one second", Duration(time.Second)},
+		{"one nanosecond", 1},
+	}
+	for _, tt := range tests {
+		config := &Config{}
+		config.Scheduler.Jitter = tt.jitter
+		for i := 0; i < 100; i++ {
+			if got := jitter(config); got < 0 || (got > 0 && got >= time.Duration(tt.jitter)) {
+				t.Errorf("%s: jitter() = %v, want below %v", tt.name, got, time.Duration(tt.jitter))
+				break
+			}
+		}
+	}
+}
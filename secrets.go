@@ -0,0 +1,163 @@
+package chef
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type (
+	// SecretResolver looks up the secret a config value refers to, i.e. a
+	// Vault path or a KMS encrypted blob
+	SecretResolver interface {
+		// Resolve returns the secret for ref, the value without its scheme
+		Resolve(ref string) (string, error)
+	}
+
+	// SecretResolverFunc adapts a function to the SecretResolver interface
+	SecretResolverFunc func(ref string) (string, error)
+)
+
+var secretResolvers = struct {
+	sync.RWMutex
+	m map[string]SecretResolver
+}{
+	m: map[string]SecretResolver{
+		"file": SecretResolverFunc(resolveFileSecret),
+		"env":  SecretResolverFunc(resolveEnvSecret),
+	},
+}
+
+// Resolve calls f(ref)
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// RegisterSecretResolver makes config values of the form "scheme://ref" be
+// replaced by r.Resolve(ref) at load time. Register before calling New.
+func RegisterSecretResolver(scheme string, r SecretResolver) {
+	secretResolvers.Lock()
+	secretResolvers.m[strings.ToLower(scheme)] = r
+	secretResolvers.Unlock()
+}
+
+// ResolveSecrets replaces the config values referring to a secret with the
+// secret, i.e. "file:///run/secrets/db_pass" with the content of the file or
+// "env://DB_PASS" with the environment variable. Values with a scheme no
+// resolver is registered for are kept. It is applied by LoadConfig after the
+// environment overrides.
+func ResolveSecrets(config *Config) error {
+	if err := resolveSecrets(reflect.ValueOf(config).Elem(), ""); err != nil {
+		return err
+	}
+	for key, value := range config.extra {
+		resolved, err := resolveSecretValue(value, key)
+		if err != nil {
+			return err
+		}
+		config.extra[key] = resolved
+	}
+	return nil
+}
+
+func resolveSecrets(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return resolveSecrets(v.Elem(), path)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := resolveSecrets(v.Field(i), name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveSecrets(v.Index(i), path); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		s, err := resolveSecret(v.String(), path)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	}
+	return nil
+}
+
+// resolveSecretValue resolves the strings within a section decoded for
+// Config.Unmarshal
+func resolveSecretValue(v interface{}, path string) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return resolveSecret(v, path)
+	case map[string]interface{}:
+		for key, value := range v {
+			resolved, err := resolveSecretValue(value, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	case []interface{}:
+		for i, value := range v {
+			resolved, err := resolveSecretValue(value, path)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	}
+	return v, nil
+}
+
+// resolveSecret returns the secret s refers to, or s itself
+func resolveSecret(s, path string) (string, error) {
+	i := strings.Index(s, "://")
+	if i <= 0 {
+		return s, nil
+	}
+	secretResolvers.RLock()
+	r, ok := secretResolvers.m[strings.ToLower(s[:i])]
+	secretResolvers.RUnlock()
+	if !ok {
+		return s, nil
+	}
+
+	secret, err := r.Resolve(s[i+3:])
+	if err != nil {
+		return "", errors.New("chef: unable to resolve secret for " + path + ": " + err.Error())
+	}
+	return secret, nil
+}
+
+// resolveFileSecret reads the secret from a file, as mounted by Docker or
+// Kubernetes, without the trailing newline
+func resolveFileSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func resolveEnvSecret(name string) (string, error) {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.New("environment variable " + name + " is not set")
+	}
+	return val, nil
+}
@@ -0,0 +1,383 @@
+package chef
+
+import (
+	stdctx "context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type (
+	// lifecycle holds the running server and what to do when it stops
+	lifecycle struct {
+		lock          sync.Mutex
+		server        *http.Server
+		shutdownHooks []func()
+		startHooks    []func(stdctx.Context) error
+		stopHooks     []func(stdctx.Context) error
+		listeners     map[string][]net.Listener
+		done          chan struct{}
+		err           error
+
+		// routines are the goroutines started by Go
+		routines       sync.WaitGroup
+		routinesCtx    stdctx.Context
+		routinesCancel stdctx.CancelFunc
+
+		// templates are reloaded on change in development
+		templates []*Templates
+
+		// mounts are the applications served by Mount, started and stopped
+		// along with this one
+		mounts []*Chef
+	}
+)
+
+// OnShutdown registers hooks run once in-flight requests are drained
+func (c *Chef) OnShutdown(hooks ...func()) {
+	c.lifecycle.lock.Lock()
+	c.lifecycle.shutdownHooks = append(c.lifecycle.shutdownHooks, hooks...)
+	c.lifecycle.lock.Unlock()
+}
+
+// OnStart registers hooks run in order before the server starts accepting
+// requests, e.g. to open database pools or start queue consumers. The server
+// doesn't start if one fails.
+func (c *Chef) OnStart(hooks ...func(ctx stdctx.Context) error) {
+	c.lifecycle.lock.Lock()
+	c.lifecycle.startHooks = append(c.lifecycle.startHooks, hooks...)
+	c.lifecycle.lock.Unlock()
+}
+
+// OnStop registers hooks run in reverse order once in-flight requests,
+// scheduled tasks and background jobs are drained, with the shutdown context.
+// They also run when a start hook or the server fails, so they must cope with
+// resources that were never opened.
+func (c *Chef) OnStop(hooks ...func(ctx stdctx.Context) error) {
+	c.lifecycle.lock.Lock()
+	c.lifecycle.stopHooks = append(c.lifecycle.stopHooks, hooks...)
+	c.lifecycle.lock.Unlock()
+}
+
+// start runs the start hooks, then starts the mounted applications,
+// stopping at the first failure
+func (c *Chef) start(ctx stdctx.Context) error {
+	c.lifecycle.lock.Lock()
+	hooks, mounts := c.lifecycle.startHooks, c.lifecycle.mounts
+	c.lifecycle.lock.Unlock()
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			return err
+		}
+	}
+	for _, m := range mounts {
+		if err := m.start(ctx); err != nil {
+			return err
+		}
+		m.scheduler.start()
+	}
+	return nil
+}
+
+// stop stops the mounted applications, drains the scheduled tasks and the
+// background jobs, which may still use what the stop hooks close, then runs
+// the stop hooks. It returns the first error.
+func (c *Chef) stop(ctx stdctx.Context) error {
+	c.lifecycle.lock.Lock()
+	mounts := c.lifecycle.mounts
+	c.lifecycle.lock.Unlock()
+	var err error
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if e := mounts[i].waitRoutines(ctx); e != nil && err == nil {
+			err = e
+		}
+		if e := mounts[i].stop(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if e := c.scheduler.stop(ctx); err == nil {
+		err = e
+	}
+	if e := c.workers.stop(ctx); err == nil {
+		err = e
+	}
+
+	c.lifecycle.lock.Lock()
+	hooks := c.lifecycle.stopHooks
+	c.lifecycle.lock.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if e := hooks[i](ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Shutdown stops accepting connections, tells long-lived requests to wrap up
+// and waits for in-flight requests to complete until ctx expires, then runs
+// the shutdown hooks. Queued log records are written before it returns.
+func (c *Chef) Shutdown(ctx stdctx.Context) error {
+	lc := c.lifecycle
+	lc.lock.Lock()
+	srv, done := lc.server, lc.done
+	lc.server = nil
+	lc.lock.Unlock()
+	if srv == nil {
+		return nil
+	}
+
+	sdNotify("STOPPING=1")
+	streamsErr := c.CloseStreams(ctx)
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		err = streamsErr
+	}
+	if routinesErr := c.waitRoutines(ctx); err == nil {
+		err = routinesErr
+	}
+	if stopErr := c.stop(ctx); err == nil {
+		err = stopErr
+	}
+
+	c.shutdownHooks()
+
+	lc.err = err
+	close(done)
+	return err
+}
+
+// shutdownHooks runs the shutdown hooks of the mounted applications, then
+// the ones of c, and writes the queued log records
+func (c *Chef) shutdownHooks() {
+	c.lifecycle.lock.Lock()
+	hooks, mounts := c.lifecycle.shutdownHooks, c.lifecycle.mounts
+	c.lifecycle.lock.Unlock()
+	for _, m := range mounts {
+		m.shutdownHooks()
+	}
+	for _, hook := range hooks {
+		hook()
+	}
+	c.logger.Flush()
+}
+
+// newServer returns the HTTP server serving the application. With
+// Server.H2C, HTTP/2 is also spoken over cleartext connections.
+func (c *Chef) newServer() *http.Server {
+	var h http.Handler = c.router
+	if c.Config().Server.H2C {
+		h = h2c.NewHandler(h, &http2.Server{})
+	}
+	srv := c.Config().Server
+	return &http.Server{
+		Handler:           h,
+		ReadTimeout:       srv.ReadTimeout.Or(0),
+		ReadHeaderTimeout: srv.ReadHeaderTimeout.Or(10 * time.Second),
+		WriteTimeout:      srv.WriteTimeout.Or(0),
+		IdleTimeout:       srv.IdleTimeout.Or(2 * time.Minute),
+		MaxHeaderBytes:    srv.MaxHeaderBytes,
+	}
+}
+
+// RunServer starts srv, letting its timeouts, hooks and logger be set up
+// beforehand. The application becomes the handler when srv has none and
+// App.Port the address when srv has none. srv serves over TLS when its
+// TLSConfig has certificates. Inherited sockets are served as in Run.
+func (c *Chef) RunServer(srv *http.Server) error {
+	if srv.Handler == nil {
+		srv.Handler = c.newServer().Handler
+	}
+	if srv.Addr == "" {
+		srv.Addr = c.Config().App.Port
+	}
+
+	c.logger.GetModuleLogger("chef").Noticef("Running app on %s", srv.Addr)
+	lns, err := c.listenRole(listenerApp, srv.Addr)
+	if err != nil {
+		return err
+	}
+	c.keepListeners(listenerApp, lns)
+
+	t := srv.TLSConfig
+	return c.run(srv, func() error {
+		return c.serveListeners(lns, func(ln net.Listener) error {
+			if t != nil && (len(t.Certificates) > 0 || t.GetCertificate != nil) {
+				return srv.ServeTLS(ln, "", "")
+			}
+			return srv.Serve(ln)
+		})
+	})
+}
+
+// Serve runs the application on l, which chef doesn't dial itself, e.g. one
+// bound to port 0 in tests or passed down by a supervisor. It blocks until the
+// server is shut down.
+func (c *Chef) Serve(l net.Listener) error {
+	srv := c.Config().Server
+	return c.serve(LimitListener(l, srv.MaxConns, srv.MaxConnsPerIP, srv.RejectWith503))
+}
+
+// listen listens on addr within the configured connection limits. An addr of
+// the form "unix:/path/to.sock" listens on a unix socket, replacing any stale
+// one and applying App.SocketMode.
+func (c *Chef) listen(addr string) (net.Listener, error) {
+	var (
+		ln  net.Listener
+		err error
+	)
+	if path := strings.TrimPrefix(addr, "unix:"); path != addr {
+		ln, err = c.listenUnix(path)
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	srv := c.Config().Server
+	return LimitListener(ln, srv.MaxConns, srv.MaxConnsPerIP, srv.RejectWith503), nil
+}
+
+// listenUnix listens on the unix socket at path
+func (c *Chef) listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := os.FileMode(0660)
+	if m := c.Config().App.SocketMode; m != "" {
+		n, err := strconv.ParseUint(m, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, errors.New("chef: invalid socket mode " + m)
+		}
+		mode = os.FileMode(n)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// serve runs the server on the listeners, see run
+func (c *Chef) serve(lns ...net.Listener) error {
+	srv := c.newServer()
+	c.keepListeners(listenerApp, lns)
+	return c.run(srv, func() error {
+		return c.serveListeners(lns, srv.Serve)
+	})
+}
+
+// serveListeners serves the first listener in place and the others in the
+// background
+func (c *Chef) serveListeners(lns []net.Listener, serve func(net.Listener) error) error {
+	for _, ln := range lns[1:] {
+		go func(ln net.Listener) {
+			if err := serve(ln); err != http.ErrServerClosed {
+				c.logger.GetModuleLogger("chef").Error(err)
+			}
+		}(ln)
+	}
+	return serve(lns[0])
+}
+
+// run calls serve until it fails or the server is shut down, either by
+// Shutdown or by SIGINT/SIGTERM. SIGHUP reloads the config.
+func (c *Chef) run(srv *http.Server, serve func() error) error {
+	if err := c.start(stdctx.Background()); err != nil {
+		c.stopWithTimeout()
+		return err
+	}
+	c.scheduler.start()
+
+	// Time values have no app to ask, they follow the running one
+	timeDefaults.Store(timesOf(c.Config()))
+
+	lc := c.lifecycle
+	lc.lock.Lock()
+	lc.server = srv
+	lc.done = make(chan struct{})
+	done := lc.done
+	lc.lock.Unlock()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					if err := c.ReloadConfig(); err != nil {
+						c.logger.GetModuleLogger("chef").Errorf("Config reload failed: %s", err)
+					}
+					continue
+				}
+				c.logger.GetModuleLogger("chef").Notice("Shutting down")
+				ctx, cancel := stdctx.WithTimeout(stdctx.Background(), c.shutdownTimeout())
+				defer cancel()
+				c.Shutdown(ctx)
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	go c.watchRestart(done)
+	if c.source != nil {
+		go c.watchSource(done)
+	}
+	if c.isEnv("development") {
+		go c.watch(done)
+	}
+
+	notifyReady()
+	if err := serve(); err != http.ErrServerClosed {
+		lc.lock.Lock()
+		owned := lc.server == srv
+		if owned {
+			lc.server = nil
+		}
+		lc.lock.Unlock()
+		if !owned {
+			// Shutdown took the server first, it closes done once finished
+			<-done
+			return err
+		}
+		srv.Close()
+		c.stopWithTimeout()
+		c.logger.Flush()
+		close(done)
+		return err
+	}
+	<-done
+	return lc.err
+}
+
+// stopWithTimeout runs the stop hooks within the shutdown grace period
+func (c *Chef) stopWithTimeout() {
+	ctx, cancel := stdctx.WithTimeout(stdctx.Background(), c.shutdownTimeout())
+	defer cancel()
+	if err := c.stop(ctx); err != nil {
+		c.logger.GetModuleLogger("chef").Error(err)
+	}
+}
+
+func (c *Chef) shutdownTimeout() time.Duration {
+	return c.Config().Server.ShutdownTimeout.Or(30 * time.Second)
+}
@@ -0,0 +1,121 @@
+package chef
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gochef/session"
+)
+
+type (
+	sessionLock struct {
+		sync.RWMutex
+		refs int
+	}
+
+	// sessionLocker hands out one lock per session ID and drops it once
+	// no request holds it anymore
+	sessionLocker struct {
+		mu    sync.Mutex
+		locks map[string]*sessionLock
+	}
+)
+
+var (
+	// ErrSessionReadOnly is returned when writing a session opened by
+	// SessionReadOnly
+	ErrSessionReadOnly = errors.New("chef: session is read-only")
+
+	sessionLocks = &sessionLocker{
+		locks: map[string]*sessionLock{},
+	}
+)
+
+// SessionLock serializes requests sharing the same session so that concurrent
+// writes from the same user don't overwrite each other
+func SessionLock(c Context) {
+	lockSession(c, false)
+}
+
+// SessionReadOnly opens the session in read-only mode. Read-only requests only
+// wait for writers, never for each other.
+func SessionReadOnly(c Context) {
+	lockSession(c, true)
+}
+
+func lockSession(c Context, readOnly bool) {
+	s := c.Session()
+	if s == nil || s.ID() == "" {
+		c.Next()
+		return
+	}
+
+	unlock := sessionLocks.acquire(s.ID(), readOnly)
+	defer unlock()
+
+	if ctx, ok := c.(*context); ok {
+		// the session was read before the lock was held, reload it to see
+		// the writes of the previous holder
+		ctx.session = session.GetDriver(ctx.config.Session, ctx.request, ctx.response)
+		ctx.sessionReadOnly = readOnly
+	}
+	c.Next()
+}
+
+func (c *context) SessionSet(key string, value interface{}) error {
+	if err := c.writableSession(); err != nil {
+		return err
+	}
+	c.session.Set(key, value)
+	return nil
+}
+
+func (c *context) SessionDelete(key string) error {
+	if err := c.writableSession(); err != nil {
+		return err
+	}
+	c.session.Delete(key)
+	return nil
+}
+
+func (c *context) writableSession() error {
+	if c.session == nil {
+		return ErrNoSession
+	}
+	if c.sessionReadOnly {
+		return ErrSessionReadOnly
+	}
+	return nil
+}
+
+func (l *sessionLocker) acquire(id string, readOnly bool) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &sessionLock{}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	if readOnly {
+		lock.RLock()
+	} else {
+		lock.Lock()
+	}
+
+	return func() {
+		if readOnly {
+			lock.RUnlock()
+		} else {
+			lock.Unlock()
+		}
+
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,75 @@
+package chef
+
+import (
+	"mime"
+	"strings"
+)
+
+type (
+	// Skipper decides whether a middleware should be bypassed for the request
+	Skipper func(ctx Context) bool
+)
+
+// Unless wraps the middleware m so it is bypassed whenever skip returns true
+func Unless(m Handler, skip Skipper) Handler {
+	return func(ctx Context) {
+		if skip(ctx) {
+			ctx.Next()
+			return
+		}
+		m(ctx)
+	}
+}
+
+// SkipPaths returns a skipper matching requests under one of the path
+// prefixes. Prefixes match whole segments, so "/health" doesn't match "/healthz".
+func SkipPaths(prefixes ...string) Skipper {
+	return func(ctx Context) bool {
+		p := ctx.Request().URL.Path
+		for _, prefix := range prefixes {
+			if !strings.HasPrefix(p, prefix) {
+				continue
+			}
+			if len(p) == len(prefix) || strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/' {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SkipMethods returns a skipper matching requests using one of the methods
+func SkipMethods(methods ...string) Skipper {
+	return func(ctx Context) bool {
+		for _, m := range methods {
+			if strings.EqualFold(ctx.Request().Method, m) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SkipContentTypes returns a skipper matching requests whose Content-Type is
+// one of mimeTypes
+func SkipContentTypes(mimeTypes ...string) Skipper {
+	return func(ctx Context) bool {
+		ct, _, err := mime.ParseMediaType(ctx.Request().Header.Get(HeaderContentType))
+		if err != nil {
+			return false
+		}
+		for _, t := range mimeTypes {
+			if strings.EqualFold(ct, t) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Or returns a skipper matching when either s or other matches
+func (s Skipper) Or(other Skipper) Skipper {
+	return func(ctx Context) bool {
+		return s(ctx) || other(ctx)
+	}
+}
@@ -0,0 +1,99 @@
+package chef
+
+import (
+	stdctx "context"
+	"errors"
+	"os"
+	"time"
+)
+
+type (
+	// ConfigSource is a central store holding the config, i.e. Consul or etcd
+	ConfigSource interface {
+		// Load returns the current config document
+		Load(ctx stdctx.Context) ([]byte, error)
+
+		// Watch blocks until the config document changes, returning nil, or
+		// until ctx is done or watching fails
+		Watch(ctx stdctx.Context) error
+	}
+
+	// configSource is the source set by WithConfigSource
+	configSource struct {
+		ConfigSource
+		format string
+	}
+)
+
+// configSourceTimeout bounds loading the config from its source
+const configSourceTimeout = 10 * time.Second
+
+// WithConfigSource loads the config from source, in the format of the
+// extension format, i.e. ".toml". It is merged on top of the local config
+// file, which is used alone when the source can't be reached at startup. The
+// config is reloaded whenever the source changes while the server runs.
+func WithConfigSource(source ConfigSource, format string) Option {
+	return func(c *Chef) {
+		c.source = &configSource{ConfigSource: source, format: format}
+	}
+}
+
+// readConfig reads the config file and the config source when set
+func (c *Chef) readConfig() (*Config, error) {
+	if c.source == nil {
+		return LoadConfig(c.configFile)
+	}
+
+	config := &Config{}
+	if _, err := os.Stat(c.configFile); err == nil {
+		if err := decodeConfigFiles(c.configFile, config); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := stdctx.WithTimeout(stdctx.Background(), configSourceTimeout)
+	defer cancel()
+	data, err := c.source.Load(ctx)
+	if err != nil {
+		return nil, errors.New("chef: unable to load config from source: " + err.Error())
+	}
+	if err := decodeConfig(data, c.source.format, "config source", config); err != nil {
+		return nil, err
+	}
+
+	if err := finishConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// watchSource reloads the config on every change of the config source until
+// done is closed
+func (c *Chef) watchSource(done <-chan struct{}) {
+	ctx, cancel := stdctx.WithCancel(stdctx.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	logger := c.logger.GetModuleLogger("chef")
+	for {
+		err := c.source.Watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Errorf("Config source watch failed: %s", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-done:
+				return
+			}
+			continue
+		}
+		if err := c.ReloadConfig(); err != nil {
+			logger.Errorf("Config reload failed: %s", err)
+		}
+	}
+}
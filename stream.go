@@ -0,0 +1,44 @@
+package chef
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	// streamFlushItems is the number of items written between two flushes
+	streamFlushItems = 100
+
+	// streamFlushInterval bounds how long written records may sit in the buffer
+	streamFlushInterval = 100 * time.Millisecond
+
+	// maxRecordSize is the longest line accepted in NDJSON bodies
+	maxRecordSize = 1 << 20
+)
+
+type (
+	// Iterator yields the items of a stream one at a time. It returns false
+	// once the stream is exhausted.
+	Iterator func() (item interface{}, ok bool, err error)
+)
+
+// ChanIterator returns an iterator reading items from ch until it is closed
+func ChanIterator(ch <-chan interface{}) Iterator {
+	return func() (interface{}, bool, error) {
+		item, ok := <-ch
+		return item, ok, nil
+	}
+}
+
+type (
+	// RecordError reports a record of a streamed body that could not be processed
+	RecordError struct {
+		Line int    `json:"line"`
+		Err  string `json:"error"`
+	}
+)
+
+// Error implements the error interface
+func (e RecordError) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": " + e.Err
+}
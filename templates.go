@@ -0,0 +1,266 @@
+package chef
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Translations maps a locale to the translated strings by key
+	Translations map[string]map[string]string
+
+	// TemplateOptions is the configuration used to setup localized templates
+	TemplateOptions struct {
+		// Dir holds the templates. Default value is App.ViewPath
+		Dir string
+
+		// Pattern selects the template files in Dir. Default value is "*.html"
+		Pattern string
+
+		// Translations are baked into the templates in place of {{t "key"}}
+		// actions, so rendering needs no lookup
+		Translations Translations
+
+		// DefaultLocale is used for missing keys and unknown locales
+		DefaultLocale string
+
+		// Warm lists the locales compiled at startup and never evicted.
+		// Default value is DefaultLocale
+		Warm []string
+
+		// MaxBytes bounds the estimated memory used by the compiled variants of
+		// locales not in Warm. Least recently used ones are evicted past it.
+		// Zero means no limit.
+		MaxBytes int64
+	}
+
+	// Renderer renders the named view with data as the response of ctx, see
+	// WithRenderer. *Templates is a Renderer.
+	Renderer interface {
+		RenderContext(ctx Context, name string, data interface{}) error
+	}
+
+	// Templates holds a pre-parsed template set per locale
+	Templates struct {
+		options TemplateOptions
+		sources map[string]string
+
+		lock  sync.Mutex
+		sets  map[string]*localeSet
+		used  []string
+		bytes int64
+	}
+
+	localeSet struct {
+		tmpl  *template.Template
+		bytes int64
+		warm  bool
+	}
+)
+
+var (
+	// ErrTemplateNotFound is returned when rendering an unknown template
+	ErrTemplateNotFound = errors.New("chef: template not found")
+
+	// ErrNoRenderer is returned by Context.Render without WithRenderer
+	ErrNoRenderer = errors.New("chef: no renderer configured")
+
+	translateAction = regexp.MustCompile(`({{-?\s*)t\s+"((?:[^"\\]|\\.)*)"(\s*-?}})`)
+)
+
+// NewTemplates reads the templates and compiles the warm locales
+func NewTemplates(options TemplateOptions) (*Templates, error) {
+	if options.Pattern == "" {
+		options.Pattern = "*.html"
+	}
+	if len(options.Warm) == 0 && options.DefaultLocale != "" {
+		options.Warm = []string{options.DefaultLocale}
+	}
+
+	t := &Templates{options: options}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Templates returns localized templates read from App.ViewPath. They are
+// reloaded on change in development.
+func (c *Chef) Templates(options TemplateOptions) (*Templates, error) {
+	if options.Dir == "" {
+		options.Dir = c.Config().App.ViewPath
+	}
+	t, err := NewTemplates(options)
+	if err != nil {
+		return nil, err
+	}
+	c.lifecycle.lock.Lock()
+	c.lifecycle.templates = append(c.lifecycle.templates, t)
+	c.lifecycle.lock.Unlock()
+	return t, nil
+}
+
+// Reload reads the templates again and recompiles the warm locales. The
+// previous templates are kept if it fails.
+func (t *Templates) Reload() error {
+	files, err := filepath.Glob(t.files())
+	if err != nil {
+		return err
+	}
+	sources := make(map[string]string, len(files))
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		sources[filepath.Base(f)] = string(b)
+	}
+
+	fresh := &Templates{options: t.options, sources: sources, sets: map[string]*localeSet{}}
+	for _, locale := range t.options.Warm {
+		set, err := fresh.compile(locale)
+		if err != nil {
+			return err
+		}
+		set.warm = true
+		fresh.sets[locale] = set
+	}
+
+	t.lock.Lock()
+	t.sources, t.sets, t.used, t.bytes = fresh.sources, fresh.sets, nil, 0
+	t.lock.Unlock()
+	return nil
+}
+
+// files returns the glob pattern of the template files
+func (t *Templates) files() string {
+	return filepath.Join(t.options.Dir, t.options.Pattern)
+}
+
+// Render executes the named template of the locale variant into w
+func (t *Templates) Render(w io.Writer, locale, name string, data interface{}) error {
+	set, err := t.lookup(locale)
+	if err != nil {
+		return err
+	}
+	tmpl := set.tmpl.Lookup(name)
+	if tmpl == nil {
+		return ErrTemplateNotFound
+	}
+	return tmpl.Execute(w, data)
+}
+
+// RenderContext renders the template as HTML for the locale stored under the
+// "locale" context key, or the first language of Accept-Language
+func (t *Templates) RenderContext(ctx Context, name string, data interface{}) error {
+	locale := ctx.GetString("locale")
+	if locale == "" {
+		locale = t.acceptLanguage(ctx.Request().Header.Get("Accept-Language"))
+	}
+	ctx.SetHeader(HeaderContentType, MIMETextHTMLCharsetUTF8)
+	return t.Render(ctx.Response(), locale, name, data)
+}
+
+func (t *Templates) lookup(locale string) (*localeSet, error) {
+	if _, ok := t.options.Translations[locale]; !ok {
+		locale = t.options.DefaultLocale
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if set, ok := t.sets[locale]; ok {
+		t.touch(locale)
+		return set, nil
+	}
+
+	set, err := t.compile(locale)
+	if err != nil {
+		return nil, err
+	}
+	t.sets[locale] = set
+	t.bytes += set.bytes
+	t.touch(locale)
+	t.evict()
+	return set, nil
+}
+
+// touch marks locale as the most recently used, the lock must be held
+func (t *Templates) touch(locale string) {
+	if t.sets[locale].warm {
+		return
+	}
+	for i, l := range t.used {
+		if l == locale {
+			t.used = append(t.used[:i], t.used[i+1:]...)
+			break
+		}
+	}
+	t.used = append(t.used, locale)
+}
+
+// evict drops the least recently used variants over budget, keeping the
+// newest one so the current request can be served
+func (t *Templates) evict() {
+	for t.options.MaxBytes > 0 && t.bytes > t.options.MaxBytes && len(t.used) > 1 {
+		oldest := t.used[0]
+		t.used = t.used[1:]
+		t.bytes -= t.sets[oldest].bytes
+		delete(t.sets, oldest)
+	}
+}
+
+// compile parses every template with the translations of locale baked in.
+// Keys that are not literals are still looked up at render time.
+func (t *Templates) compile(locale string) (*localeSet, error) {
+	set := &localeSet{tmpl: template.New("").Funcs(template.FuncMap{
+		"t": func(key string) string {
+			return t.translate(locale, key)
+		},
+	})}
+	for name, src := range t.sources {
+		baked := translateAction.ReplaceAllStringFunc(src, func(action string) string {
+			m := translateAction.FindStringSubmatch(action)
+			// the translation becomes a string constant, so html/template
+			// escapes it for its context and never runs it as template code
+			s := t.translate(locale, strings.ReplaceAll(m[2], `\"`, `"`))
+			return m[1] + strconv.Quote(s) + m[3]
+		})
+		if _, err := set.tmpl.New(name).Parse(baked); err != nil {
+			return nil, err
+		}
+		set.bytes += int64(len(baked))
+	}
+	return set, nil
+}
+
+func (t *Templates) translate(locale, key string) string {
+	if s, ok := t.options.Translations[locale][key]; ok {
+		return s
+	}
+	if s, ok := t.options.Translations[t.options.DefaultLocale][key]; ok {
+		return s
+	}
+	return key
+}
+
+func (t *Templates) acceptLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		lang := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if _, ok := t.options.Translations[lang]; ok {
+			return lang
+		}
+		if i := strings.IndexByte(lang, '-'); i > 0 {
+			if _, ok := t.options.Translations[lang[:i]]; ok {
+				return lang[:i]
+			}
+		}
+	}
+	return t.options.DefaultLocale
+}
@@ -0,0 +1,150 @@
+package chef
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Special time layouts accepted in [Time] Layouts for numeric timestamps
+const (
+	TimeLayoutUnix      = "unix"
+	TimeLayoutUnixMilli = "unixmilli"
+)
+
+type (
+	// Time is a time.Time that marshals to JSON using the time format and zone
+	// of the running application, so every response formats times the same
+	// way. Mounted applications share the settings of the one running.
+	Time struct {
+		time.Time
+	}
+)
+
+var (
+	// ErrInvalidTime is returned when a value doesn't match any accepted layout
+	ErrInvalidTime = errors.New("chef: value does not match any accepted time layout")
+
+	defaultTimeLayouts = []string{time.RFC3339, TimeLayoutUnix, TimeLayoutUnixMilli}
+
+	// timeDefaults are used by Time, set from the [Time] config of the
+	// running application
+	timeDefaults atomic.Pointer[timeSettings]
+)
+
+// timeSettings are the resolved [Time] format, zone and layouts
+type timeSettings struct {
+	format   string
+	location *time.Location
+	layouts  []string
+}
+
+func init() {
+	timeDefaults.Store(&timeSettings{format: time.RFC3339, location: time.UTC, layouts: defaultTimeLayouts})
+}
+
+// MarshalJSON formats the time with the application format and zone
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(formatTime(t.Time, timeDefaults.Load()))
+}
+
+// UnmarshalJSON parses the time with the accepted layouts of the running
+// application
+func (t *Time) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		// bare numbers are unix timestamps
+		s = string(b)
+	}
+	settings := timeDefaults.Load()
+	v, err := parseTime(s, settings.layouts, settings.location)
+	if err != nil {
+		return err
+	}
+	t.Time = v
+	return nil
+}
+
+// newTimeSettings resolves the [Time] config section
+func newTimeSettings(config *Config) (*timeSettings, error) {
+	s := &timeSettings{format: time.RFC3339, location: time.UTC, layouts: defaultTimeLayouts}
+	if len(config.Time.Layouts) > 0 {
+		s.layouts = config.Time.Layouts
+	}
+	if config.Time.Format != "" {
+		s.format = config.Time.Format
+	}
+	if config.Time.Zone != "" {
+		loc, err := time.LoadLocation(config.Time.Zone)
+		if err != nil {
+			return nil, err
+		}
+		s.location = loc
+	}
+	return s, nil
+}
+
+// timesOf returns the time settings of config, the defaults when it has none
+func timesOf(config *Config) *timeSettings {
+	if config == nil || config.times == nil {
+		return timeDefaults.Load()
+	}
+	return config.times
+}
+
+func formatTime(t time.Time, s *timeSettings) string {
+	switch s.format {
+	case TimeLayoutUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case TimeLayoutUnixMilli:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	return t.In(s.location).Format(s.format)
+}
+
+// parseTime tries every layout in order. Layouts without a zone are
+// interpreted in loc.
+func parseTime(value string, layouts []string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if len(layouts) == 0 {
+		layouts = defaultTimeLayouts
+	}
+
+	allowUnix, allowMilli := false, false
+	for _, layout := range layouts {
+		switch layout {
+		case TimeLayoutUnix:
+			allowUnix = true
+		case TimeLayoutUnixMilli:
+			allowMilli = true
+		default:
+			if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// 13 digits and up are milliseconds until the year 2286
+		if allowMilli && (len(value) >= 13 || !allowUnix) {
+			return time.Unix(0, n*int64(time.Millisecond)).In(loc), nil
+		}
+		if allowUnix {
+			return time.Unix(n, 0).In(loc), nil
+		}
+	}
+
+	return time.Time{}, ErrInvalidTime
+}
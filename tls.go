@@ -0,0 +1,238 @@
+package chef
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":               tls.NoClientCert,
+		"none":           tls.NoClientCert,
+		"request":        tls.RequestClientCert,
+		"require":        tls.RequireAnyClientCert,
+		"verify":         tls.VerifyClientCertIfGiven,
+		"require-verify": tls.RequireAndVerifyClientCert,
+	}
+
+	tlsVersions = map[string]uint16{
+		"":    tls.VersionTLS12,
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	tlsCurves = map[string]tls.CurveID{
+		"x25519": tls.X25519,
+		"p256":   tls.CurveP256,
+		"p384":   tls.CurveP384,
+		"p521":   tls.CurveP521,
+	}
+)
+
+// TLSConfig builds the server TLS configuration from the [TLS] config section
+func (c *Chef) TLSConfig() (*tls.Config, error) {
+	cfg := c.Config().TLS
+	auth, ok := clientAuthTypes[strings.ToLower(cfg.ClientAuth)]
+	if !ok {
+		return nil, errors.New("chef: invalid TLS client auth " + cfg.ClientAuth)
+	}
+
+	version, ok := tlsVersions[cfg.MinVersion]
+	if !ok {
+		return nil, errors.New("chef: invalid TLS min version " + cfg.MinVersion)
+	}
+
+	t := &tls.Config{
+		ClientAuth: auth,
+		MinVersion: version,
+	}
+
+	for _, name := range cfg.CipherSuites {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return nil, errors.New("chef: unknown TLS cipher suite " + name)
+		}
+		t.CipherSuites = append(t.CipherSuites, id)
+	}
+	for _, name := range cfg.Curves {
+		id, ok := tlsCurves[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.New("chef: unknown TLS curve " + name)
+		}
+		t.CurvePreferences = append(t.CurvePreferences, id)
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("chef: no certificates found in " + cfg.ClientCAFile)
+		}
+		t.ClientCAs = pool
+	} else if auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert {
+		return nil, errors.New("chef: TLS client verification requires ClientCAFile")
+	}
+
+	return t, nil
+}
+
+// cipherSuite returns the ID of the cipher suite named as in the Go crypto/tls
+// constants, i.e. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"
+func cipherSuite(name string) (uint16, bool) {
+	for _, s := range tls.CipherSuites() {
+		if strings.EqualFold(s.Name, name) {
+			return s.ID, true
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if strings.EqualFold(s.Name, name) {
+			return s.ID, true
+		}
+	}
+	return 0, false
+}
+
+// RunTLS starts the HTTPS server with the certificate and key files, falling
+// back to TLS.CertFile and TLS.KeyFile when empty. HTTP/2 is enabled unless
+// TLS.DisableHTTP2 is set. With TLS.RedirectHTTP, plain HTTP requests on
+// TLS.RedirectPort are redirected to HTTPS.
+func (c *Chef) RunTLS(certFile, keyFile string) error {
+	if certFile == "" {
+		certFile = c.Config().TLS.CertFile
+	}
+	if keyFile == "" {
+		keyFile = c.Config().TLS.KeyFile
+	}
+
+	t, err := c.TLSConfig()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	t.Certificates = []tls.Certificate{cert}
+
+	if c.Config().TLS.RedirectHTTP {
+		c.redirectHTTP(c.httpsRedirect())
+	}
+	return c.runTLS(t)
+}
+
+// RunAutoTLS starts the HTTPS server with certificates obtained from Let's
+// Encrypt for domains and cached in TLS.ACMECacheDir. A server on
+// TLS.RedirectPort answers the HTTP-01 challenges and redirects everything
+// else to HTTPS.
+func (c *Chef) RunAutoTLS(domains ...string) error {
+	if len(domains) == 0 {
+		return errors.New("chef: RunAutoTLS requires at least one domain")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      c.Config().TLS.ACMEEmail,
+	}
+	if dir := c.Config().TLS.ACMECacheDir; dir != "" {
+		m.Cache = autocert.DirCache(dir)
+	}
+
+	t, err := c.TLSConfig()
+	if err != nil {
+		return err
+	}
+	t.GetCertificate = m.GetCertificate
+	t.NextProtos = append(c.nextProtos(), acme.ALPNProto)
+
+	c.redirectHTTP(m.HTTPHandler(c.httpsRedirect()))
+	return c.runTLS(t)
+}
+
+// redirectHTTP serves h on TLS.RedirectPort until shutdown, within the same
+// connection limits as the application
+func (c *Chef) redirectHTTP(h http.Handler) {
+	addr := c.Config().TLS.RedirectPort
+	if addr == "" {
+		addr = ":80"
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	c.OnShutdown(func() {
+		srv.Close()
+	})
+	go func() {
+		lns, err := c.listenRole(listenerRedirect, addr)
+		if err == nil {
+			c.keepListeners(listenerRedirect, lns)
+			err = c.serveListeners(lns, srv.Serve)
+		}
+		if err != http.ErrServerClosed {
+			c.logger.GetModuleLogger("chef").Error(err)
+		}
+	}()
+}
+
+// httpsRedirect returns a handler permanently redirecting to the same URL
+// on the HTTPS port
+func (c *Chef) httpsRedirect() http.Handler {
+	_, port, _ := net.SplitHostPort(c.Config().App.Port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// nextProtos returns the ALPN protocols the server speaks, h2 first unless
+// TLS.DisableHTTP2 is set
+func (c *Chef) nextProtos() []string {
+	if c.Config().TLS.DisableHTTP2 {
+		return []string{"http/1.1"}
+	}
+	return []string{"h2", "http/1.1"}
+}
+
+// runTLS listens on the app port and serves over TLS with t
+func (c *Chef) runTLS(t *tls.Config) error {
+	c.logger.GetModuleLogger("chef").Noticef("Running app with TLS on port %s", c.Config().App.Port)
+	lns, err := c.listenRole(listenerApp, c.Config().App.Port)
+	if err != nil {
+		return err
+	}
+	return c.serveTLS(lns, t)
+}
+
+// serveTLS runs the server over TLS on the listeners
+func (c *Chef) serveTLS(lns []net.Listener, t *tls.Config) error {
+	srv := c.newServer()
+	srv.TLSConfig = t
+	if c.Config().TLS.DisableHTTP2 {
+		// a non-nil map keeps net/http from enabling HTTP/2
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	c.keepListeners(listenerApp, lns)
+	return c.run(srv, func() error {
+		return c.serveListeners(lns, func(ln net.Listener) error {
+			return srv.ServeTLS(ln, "", "")
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	logging "github.com/op/go-logging"
+)
+
+const defaultAsyncBuffer = 1024
+
+type (
+	// asyncQueue writes queued records from a single goroutine, in order
+	asyncQueue struct {
+		records chan asyncRecord
+	}
+
+	asyncRecord struct {
+		backend   logging.Backend
+		level     logging.Level
+		calldepth int
+		record    *logging.Record
+
+		// flushed is closed once the records queued before it are written
+		flushed chan struct{}
+	}
+
+	// asyncBackend hands records over to the queue instead of writing them
+	asyncBackend struct {
+		queue   *asyncQueue
+		backend logging.Backend
+	}
+)
+
+func newAsyncQueue(size int) *asyncQueue {
+	if size <= 0 {
+		size = defaultAsyncBuffer
+	}
+	q := &asyncQueue{records: make(chan asyncRecord, size)}
+	go q.run()
+	return q
+}
+
+func (q *asyncQueue) run() {
+	for r := range q.records {
+		if r.flushed != nil {
+			close(r.flushed)
+			continue
+		}
+		r.backend.Log(r.level, r.calldepth, r.record)
+	}
+}
+
+// flush waits until the records queued so far are written
+func (q *asyncQueue) flush() {
+	done := make(chan struct{})
+	q.records <- asyncRecord{flushed: done}
+	<-done
+}
+
+// Log formats the record right away, while the caller's frame is still on the
+// stack for %{shortfunc} and the like, and queues it. It blocks only when the
+// queue is full.
+func (b *asyncBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	rec.Formatted(calldepth + 1)
+	b.queue.records <- asyncRecord{
+		backend:   b.backend,
+		level:     level,
+		calldepth: calldepth,
+		record:    rec,
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	logging "github.com/op/go-logging"
+)
+
+type (
+	// Fields are attached to every record of a logger returned by With
+	Fields map[string]interface{}
+
+	// fieldsMessage is the single argument of a record carrying fields, so
+	// backends can tell them apart from the message
+	fieldsMessage struct {
+		message string
+		fields  Fields
+	}
+
+	// fieldsBackend attaches fields to the records of a child logger before
+	// handing them to the backend of its parent
+	fieldsBackend struct {
+		logging.LeveledBackend
+		fields Fields
+	}
+)
+
+// With returns a child logger attaching fields to every record, i.e. the
+// request or user ID. Fields of the logger itself are kept unless
+// overridden.
+func (l *Logger) With(fields map[string]interface{}) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	module := ""
+	if l.Logger != nil {
+		module = l.Logger.Module
+	}
+	child := &logging.Logger{Module: module, ExtraCalldepth: 1}
+	child.SetBackend(&fieldsBackend{LeveledBackend: l.backend, fields: merged})
+
+	return &Logger{
+		config:  l.config,
+		backend: l.backend,
+		Logger:  child,
+		modules: l.modules,
+		async:   l.async,
+		filter:  l.filter,
+		sinks:   l.sinks,
+		hooks:   l.hooks,
+		fields:  merged,
+	}
+}
+
+// RecordFields returns the fields attached to rec by a logger returned by
+// With, nil when there are none
+func RecordFields(rec *logging.Record) Fields {
+	if m, ok := fieldsOf(rec); ok {
+		return m.fields
+	}
+	return nil
+}
+
+func fieldsOf(rec *logging.Record) (*fieldsMessage, bool) {
+	if len(rec.Args) != 1 {
+		return nil, false
+	}
+	m, ok := rec.Args[0].(*fieldsMessage)
+	return m, ok
+}
+
+func (b *fieldsBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	return b.LeveledBackend.Log(level, calldepth+1, &logging.Record{
+		ID:     rec.ID,
+		Time:   rec.Time,
+		Module: rec.Module,
+		Level:  rec.Level,
+		Args:   []interface{}{&fieldsMessage{message: rec.Message(), fields: b.fields}},
+	})
+}
+
+// String appends the fields to the message as sorted key=value pairs
+func (m *fieldsMessage) String() string {
+	var sb strings.Builder
+	sb.WriteString(m.message)
+	for _, k := range m.fields.keys() {
+		fmt.Fprintf(&sb, " %s=%v", k, m.fields[k])
+	}
+	return sb.String()
+}
+
+func (f Fields) keys() []string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sync"
+
+	logging "github.com/op/go-logging"
+)
+
+type (
+	// FormatterFunc formats a log record, i.e. to a layout mandated by a log
+	// pipeline. calldepth locates the caller, as for logging.Formatter.
+	FormatterFunc func(calldepth int, rec *logging.Record, w io.Writer) error
+
+	// VerbFunc returns the value of a custom format verb for a record
+	VerbFunc func(rec *logging.Record) interface{}
+
+	formatterRegistry struct {
+		sync.RWMutex
+		formatters map[string]logging.Formatter
+		verbs      map[string]VerbFunc
+	}
+
+	// layoutFormatter formats a layout using custom verbs, leaving the rest
+	// to the formatters of go-logging
+	layoutFormatter struct {
+		parts []layoutPart
+	}
+
+	// layoutPart is either text, static formatted by go-logging, or a custom
+	// verb
+	layoutPart struct {
+		text   string
+		static logging.Formatter
+		verb   string
+		fn     VerbFunc
+		layout string
+	}
+)
+
+var (
+	formatters = &formatterRegistry{
+		formatters: map[string]logging.Formatter{},
+		verbs:      map[string]VerbFunc{},
+	}
+
+	// builtinVerbs are formatted by go-logging
+	builtinVerbs = map[string]bool{
+		"time": true, "level": true, "id": true, "pid": true, "program": true,
+		"module": true, "message": true, "longfile": true, "shortfile": true,
+		"longpkg": true, "shortpkg": true, "longfunc": true, "shortfunc": true,
+		"callpath": true, "color": true,
+	}
+
+	verbRe = regexp.MustCompile(`%{([a-z_][a-z0-9_]*)(?::(.*?[^\\]))?}`)
+)
+
+// Format calls f
+func (f FormatterFunc) Format(calldepth int, rec *logging.Record, w io.Writer) error {
+	return f(calldepth+1, rec, w)
+}
+
+// RegisterFormatter registers a formatter selected by name with the
+// Formatter setting of a logger or of one of its backends
+func RegisterFormatter(name string, f logging.Formatter) {
+	formatters.Lock()
+	formatters.formatters[name] = f
+	formatters.Unlock()
+}
+
+// RegisterVerb registers the verb %{name} for log formats, i.e.
+// %{request_id}. Verbs must be registered before the loggers using them are
+// created. A verb that is neither built in nor registered is looked up in the
+// fields of the record, see With.
+func RegisterVerb(name string, f VerbFunc) {
+	if builtinVerbs[name] || !verbRe.MatchString("%{"+name+"}") {
+		log.Panicf("Invalid log verb %s", name)
+	}
+	formatters.Lock()
+	formatters.verbs[name] = f
+	formatters.Unlock()
+}
+
+// IsFormatter reports whether a formatter is registered as name
+func IsFormatter(name string) bool {
+	formatters.RLock()
+	defer formatters.RUnlock()
+	_, ok := formatters.formatters[name]
+	return ok
+}
+
+// newFormatter returns the formatter registered as name, or the one of
+// format when name is empty
+func newFormatter(format, name string) logging.Formatter {
+	if name != "" {
+		formatters.RLock()
+		f, ok := formatters.formatters[name]
+		formatters.RUnlock()
+		if !ok {
+			log.Panicf("Unknown log formatter %s", name)
+		}
+		return f
+	}
+
+	matches := verbRe.FindAllStringSubmatchIndex(format, -1)
+	custom := false
+	for _, m := range matches {
+		if !builtinVerbs[format[m[2]:m[3]]] {
+			custom = true
+		}
+	}
+	if !custom {
+		return logging.MustStringFormatter(format)
+	}
+
+	formatters.RLock()
+	defer formatters.RUnlock()
+	f := &layoutFormatter{}
+	prev := 0
+	for _, m := range matches {
+		verb := format[m[2]:m[3]]
+		if builtinVerbs[verb] {
+			continue
+		}
+		f.static(format[prev:m[0]])
+		part := layoutPart{verb: verb, fn: formatters.verbs[verb], layout: "%v"}
+		if m[4] >= 0 {
+			part.layout = "%" + format[m[4]:m[5]]
+		}
+		f.parts = append(f.parts, part)
+		prev = m[1]
+	}
+	f.static(format[prev:])
+	return f
+}
+
+func (f *layoutFormatter) static(layout string) {
+	switch {
+	case layout == "":
+	case verbRe.MatchString(layout):
+		f.parts = append(f.parts, layoutPart{static: logging.MustStringFormatter(layout)})
+	default:
+		// go-logging refuses layouts without verbs
+		f.parts = append(f.parts, layoutPart{text: layout})
+	}
+}
+
+func (f *layoutFormatter) Format(calldepth int, rec *logging.Record, w io.Writer) error {
+	for _, part := range f.parts {
+		if part.text != "" {
+			io.WriteString(w, part.text)
+			continue
+		}
+		if part.static != nil {
+			if err := part.static.Format(calldepth+1, rec, w); err != nil {
+				return err
+			}
+			continue
+		}
+		var v interface{}
+		if part.fn != nil {
+			v = part.fn(rec)
+		} else if fields := RecordFields(rec); fields != nil {
+			v = fields[part.verb]
+		}
+		if v == nil {
+			v = ""
+		}
+		fmt.Fprintf(w, part.layout, v)
+	}
+	return nil
+}
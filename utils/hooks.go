@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+const (
+	// hookBuffer bounds the records queued for a hook, newer ones are
+	// dropped while it is full
+	hookBuffer = 1024
+	// hookFlushTimeout bounds how long Flush waits for a hook
+	hookFlushTimeout = 5 * time.Second
+	// hookReportInterval spaces out the reports of a failing hook
+	hookReportInterval = time.Minute
+)
+
+type (
+	// Hook receives the records logged past the level of the logger, i.e. to
+	// forward them to Fluentd, Logstash, GELF or Kafka. Fire is called from a
+	// goroutine of its own for every hook, in order. Its errors and panics are
+	// reported on the standard logger without affecting the caller.
+	Hook interface {
+		Fire(entry *Entry) error
+	}
+
+	// HookFunc adapts a function to the Hook interface
+	HookFunc func(entry *Entry) error
+
+	// Entry is a log record as seen by hooks
+	Entry struct {
+		Time    time.Time
+		Module  string
+		Level   Level
+		Message string
+		// Fields are the fields attached by With, without them in Message
+		Fields Fields
+	}
+
+	// hookSet is shared by a logger and its module loggers
+	hookSet struct {
+		sync.RWMutex
+		runners []*hookRunner
+	}
+
+	// hookRunner queues the entries of a hook for its goroutine
+	hookRunner struct {
+		hook    Hook
+		entries chan hookItem
+
+		lock     sync.Mutex
+		dropped  int
+		reported time.Time
+	}
+
+	hookItem struct {
+		entry *Entry
+		// flushed is closed once the entries queued before it are fired
+		flushed chan struct{}
+	}
+
+	// hookBackend hands records to the hooks, as a backend at the level of
+	// the logger
+	hookBackend struct {
+		hooks *hookSet
+	}
+)
+
+// Fire calls f
+func (f HookFunc) Fire(entry *Entry) error {
+	return f(entry)
+}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return "UNKNOWN"
+	}
+	return levelNames[l]
+}
+
+// AddHook adds a hook to the logger and the loggers sharing its backends.
+// Records are queued for the hook, so a slow or broken one never blocks
+// logging; records are dropped while its queue is full.
+func (l *Logger) AddHook(h Hook) {
+	r := &hookRunner{hook: h, entries: make(chan hookItem, hookBuffer)}
+	go r.run()
+	l.hooks.Lock()
+	l.hooks.runners = append(l.hooks.runners, r)
+	l.hooks.Unlock()
+}
+
+func (b *hookBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	b.hooks.RLock()
+	runners := b.hooks.runners
+	b.hooks.RUnlock()
+	if len(runners) == 0 {
+		return nil
+	}
+
+	entry := &Entry{
+		Time:   rec.Time,
+		Module: rec.Module,
+		Level:  Level(level),
+	}
+	if m, ok := fieldsOf(rec); ok {
+		entry.Message, entry.Fields = m.message, m.fields
+	} else {
+		entry.Message = rec.Message()
+	}
+	for _, r := range runners {
+		r.queue(entry)
+	}
+	return nil
+}
+
+// flush waits, within hookFlushTimeout, until the entries queued so far are
+// fired
+func (s *hookSet) flush() {
+	s.RLock()
+	runners := s.runners
+	s.RUnlock()
+	for _, r := range runners {
+		r.flush()
+	}
+}
+
+func (r *hookRunner) queue(entry *Entry) {
+	select {
+	case r.entries <- hookItem{entry: entry}:
+	default:
+		r.lock.Lock()
+		r.dropped++
+		r.lock.Unlock()
+		r.report("Log hook queue full, dropping records")
+	}
+}
+
+func (r *hookRunner) run() {
+	for item := range r.entries {
+		if item.flushed != nil {
+			close(item.flushed)
+			continue
+		}
+		r.fire(item.entry)
+	}
+}
+
+func (r *hookRunner) fire(entry *Entry) {
+	defer func() {
+		if err := recover(); err != nil {
+			r.report("Log hook panicked: %v", err)
+		}
+	}()
+	if err := r.hook.Fire(entry); err != nil {
+		r.report("Log hook failed: %v", err)
+	}
+}
+
+func (r *hookRunner) flush() {
+	done := make(chan struct{})
+	timeout := time.NewTimer(hookFlushTimeout)
+	defer timeout.Stop()
+	select {
+	case r.entries <- hookItem{flushed: done}:
+	case <-timeout.C:
+		return
+	}
+	select {
+	case <-done:
+	case <-timeout.C:
+	}
+}
+
+// report writes a problem of the hook on the standard logger, at most once
+// per hookReportInterval, with the number of records dropped meanwhile
+func (r *hookRunner) report(format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if time.Since(r.reported) < hookReportInterval {
+		return
+	}
+	r.reported = time.Now()
+	if r.dropped > 0 {
+		format += " (%d records dropped)"
+		args = append(args, r.dropped)
+		r.dropped = 0
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	logging "github.com/op/go-logging"
+)
+
+// journaldSocket receives the entries of the journald native protocol
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldPriorities maps the log levels to syslog priorities
+var journaldPriorities = map[logging.Level]int{
+	logging.CRITICAL: 2,
+	logging.ERROR:    3,
+	logging.WARNING:  4,
+	logging.NOTICE:   5,
+	logging.INFO:     6,
+	logging.DEBUG:    7,
+}
+
+// journaldBackend sends log records to the systemd journal
+type journaldBackend struct {
+	conn net.Conn
+	tag  string
+}
+
+func (l *Logger) newJournaldBackend() (logging.Backend, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, err
+	}
+	tag := l.config.Tag
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	return &journaldBackend{conn: conn, tag: tag}, nil
+}
+
+// Log implements the logging.Backend interface
+func (b *journaldBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	var buf bytes.Buffer
+	journaldField(&buf, "PRIORITY", strconv.Itoa(journaldPriorities[level]))
+	journaldField(&buf, "SYSLOG_IDENTIFIER", b.tag)
+	journaldField(&buf, "CHEF_MODULE", rec.Module)
+	fields := RecordFields(rec)
+	for _, k := range fields.keys() {
+		if name := journaldFieldName(k); name != "" {
+			journaldField(&buf, name, fmt.Sprint(fields[k]))
+		}
+	}
+	journaldField(&buf, "MESSAGE", rec.Formatted(calldepth+1))
+	_, err := b.conn.Write(buf.Bytes())
+	return err
+}
+
+// journaldFieldName returns key as a journal field name, made of uppercase
+// letters, digits and underscores and not starting with one
+func journaldFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	return strings.TrimLeft(name, "_")
+}
+
+// journaldField writes a field of an entry, in the binary form when the
+// value spans several lines
+func journaldField(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
@@ -1,15 +1,20 @@
 package utils
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	logging "github.com/op/go-logging"
 )
 
 const (
 	defaultLogFormat = "[%{module}.%{shortfunc}.%{level} %{time:15:04:05}] %{message}"
+	defaultLogLevel  = "INFO"
 )
 
 // Log levels.
@@ -40,27 +45,107 @@ type (
 		level Level
 		// Level convertes to level during initialization
 		Level string
-		// format
+		// format, may use the verbs registered with RegisterVerb and the
+		// fields of the records, i.e. %{request_id}
 		Format string
+		// Formatter is the name of a formatter registered with
+		// RegisterFormatter, used instead of Format
+		Formatter string
 		// enable colors
 		Colored bool
+		// Backend is "screen", "file", "syslog" or "journald", default is
+		// "screen"
 		Backend string
+		// File is also logged to when set, empty disables it
 		File    string
 		Modules []string
 		Output  io.Writer
+		// Backends replace Backend and File with several backends, each with
+		// its own level and format
+		Backends []BackendConfig
+		// syslog server reached over "tcp" or "udp", the local one when empty
+		SyslogNetwork string
+		SyslogAddress string
+		// syslog facility, i.e. "daemon" or "local0", default is "user"
+		SyslogFacility string
+		// Tag identifies the program in syslog and journald, default is the
+		// executable name
+		Tag string
+		// Async queues records for a background goroutine to write, so callers
+		// aren't blocked on slow disks or networks. AsyncBuffer bounds the
+		// queue, default is 1024 records; callers wait when it's full.
+		Async       bool
+		AsyncBuffer int
+		// Sampling logs 1 of every N messages of the modules it names, i.e.
+		// {"chef": 10}. Errors and criticals are always logged.
+		Sampling map[string]int
+		// CollapseRepeats logs a message repeated by a module once, then
+		// "last message repeated N times" when another message comes, at
+		// most every 30 seconds or on Flush
+		CollapseRepeats bool
+	}
+
+	// BackendConfig configures one of the backends of a logger
+	BackendConfig struct {
+		// Type is "screen", "file", "syslog" or "journald", default is
+		// "screen"
+		Type string
+		// Level, Format and Formatter default to the logger's. The logger's
+		// Level still applies to the backends without one of their own.
+		Level     string
+		Format    string
+		Formatter string
+		// File is the path a "file" backend logs to
+		File    string
+		Colored bool
 	}
 
 	// Logger represents a logger intance
 	Logger struct {
-		config *LoggerConfig
+		config  *LoggerConfig
+		backend logging.LeveledBackend
 		*logging.Logger
+
+		// modules caches the module loggers, shared by every one of them
+		modules *moduleLoggers
+		// async is the queue of an Async logger, shared by its module loggers
+		async *asyncQueue
+		// filter samples and collapses records, nil when not configured
+		filter *filterBackend
+		// fields are attached to every record, see With
+		fields Fields
+		// sinks are the configured backends and their levels
+		sinks []sinkLevel
+		// hooks are shared by every logger of the same backends
+		hooks *hookSet
+	}
+
+	sinkLevel struct {
+		backend logging.LeveledBackend
+		level   Level
+		// own is set when the backend has a level of its own
+		own bool
 	}
+
+	moduleLoggers struct {
+		sync.RWMutex
+		m map[string]*Logger
+	}
+
+	// Lazy defers computing a log argument until the message is formatted,
+	// which only happens when its level is enabled
+	Lazy func() interface{}
 )
 
 // NewLogger returns a logger instance
 func NewLogger(config *LoggerConfig) *Logger {
 	l := &Logger{
-		config: config,
+		config:  config,
+		modules: &moduleLoggers{m: map[string]*Logger{}},
+		hooks:   &hookSet{},
+	}
+	if config.Async {
+		l.async = newAsyncQueue(config.AsyncBuffer)
 	}
 
 	l.initLevel().setBackends()
@@ -68,7 +153,33 @@ func NewLogger(config *LoggerConfig) *Logger {
 	return l
 }
 
+// IsBackend reports whether name is a known log backend
+func IsBackend(name string) bool {
+	switch strings.ToLower(name) {
+	case "", "screen", "file", "syslog", "journald":
+		return true
+	}
+	return false
+}
+
+// IsLevel reports whether name is a known log level
+func IsLevel(name string) bool {
+	_, err := logging.LogLevel(name)
+	return err == nil
+}
+
+// SetDefaults fills in the default level and format when they're empty
+func (config *LoggerConfig) SetDefaults() {
+	if config.Level == "" {
+		config.Level = defaultLogLevel
+	}
+	if config.Format == "" {
+		config.Format = defaultLogFormat
+	}
+}
+
 func (l *Logger) initLevel() *Logger {
+	l.config.SetDefaults()
 	level, err := logging.LogLevel(l.config.Level)
 	if err != nil {
 		log.Panicf("Invalid log level %s: %v", l.config.Level, err)
@@ -77,44 +188,330 @@ func (l *Logger) initLevel() *Logger {
 	return l
 }
 
-func (l *Logger) setLevel(b logging.LeveledBackend) {
+// applyLevels sets the level of the configured modules, letting through the
+// records of the most verbose backend. A backend with a level of its own
+// applies it to every module.
+func (l *Logger) applyLevels() {
+	gate := l.config.level
+	for _, s := range l.sinks {
+		if !s.own {
+			l.setLevel(s.backend, l.config.level)
+			continue
+		}
+		s.backend.SetLevel(logging.Level(s.level), "")
+		if s.level > gate {
+			gate = s.level
+		}
+	}
+	l.setLevel(l.backend, gate)
+}
+
+func (l *Logger) setLevel(b logging.LeveledBackend, level Level) {
 	for _, s := range l.config.Modules {
-		b.SetLevel(logging.Level(l.config.level), s)
+		b.SetLevel(logging.Level(level), s)
 	}
 }
 
 func (l *Logger) setBackends() *Logger {
-	format := logging.MustStringFormatter(l.config.Format)
-	screenBackend := l.getScreenBackend(format)
-	fileBackend := l.getFileBackend(format)
+	configs := l.config.Backends
+	if len(configs) == 0 {
+		configs = []BackendConfig{{Type: l.config.Backend, File: l.config.File, Colored: l.config.Colored}}
+		if l.config.File != "" && !strings.EqualFold(l.config.Backend, "file") {
+			configs = append(configs, BackendConfig{Type: "file", File: l.config.File, Colored: l.config.Colored})
+		}
+	}
 
-	logging.SetBackend(screenBackend, fileBackend)
+	var backends []logging.Backend
+	for _, config := range configs {
+		s := l.newSink(config)
+		l.sinks = append(l.sinks, s)
+		backends = append(backends, s.backend)
+	}
+	// hooks get the records at the level of the logger
+	hooks := sinkLevel{backend: logging.AddModuleLevel(&hookBackend{hooks: l.hooks})}
+	l.sinks = append(l.sinks, hooks)
+	backends = append(backends, hooks.backend)
+	var backend logging.Backend = logging.MultiLogger(backends...)
+	if l.filter = l.newFilterBackend(backend); l.filter != nil {
+		backend = l.filter
+	}
+	// hide the levels of the backends so the configured modules get levels
+	// of their own rather than overriding them
+	l.backend = logging.SetBackend(struct{ logging.Backend }{backend})
+	l.applyLevels()
 
 	return l
 }
 
-func (l *Logger) getFileBackend(format logging.Formatter) logging.Backend {
-	file, err := os.OpenFile(l.config.File, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0777)
+// newSink returns the backend configured by config, with its own level
+func (l *Logger) newSink(config BackendConfig) sinkLevel {
+	var formatter logging.Formatter
+	if config.Format != "" || config.Formatter != "" {
+		formatter = newFormatter(config.Format, config.Formatter)
+	} else {
+		formatter = newFormatter(l.config.Format, l.config.Formatter)
+	}
+
+	var b logging.Backend
+	switch strings.ToLower(config.Type) {
+	case "", "screen":
+		b = newWriterBackend(os.Stdout, config.Colored)
+	case "file":
+		b = newFileBackend(config.File, config.Colored)
+	case "syslog":
+		var err error
+		if b, err = l.newSyslogBackend(); err != nil {
+			log.Panicf("Unable to connect to syslog: %v", err)
+		}
+	case "journald":
+		var err error
+		if b, err = l.newJournaldBackend(); err != nil {
+			log.Panicf("Unable to connect to journald: %v", err)
+		}
+	default:
+		log.Panicf("Unknown log backend %s", config.Type)
+	}
+
+	s := sinkLevel{backend: logging.AddModuleLevel(logging.NewBackendFormatter(l.sink(b), formatter))}
+	if config.Level != "" {
+		level, err := logging.LogLevel(config.Level)
+		if err != nil {
+			log.Panicf("Invalid log level %s: %v", config.Level, err)
+		}
+		s.level, s.own = Level(level), true
+	}
+	return s
+}
+
+// SetLevel changes the level of the configured modules at runtime, on the
+// backends without a level of their own
+func (l *Logger) SetLevel(name string) error {
+	level, err := logging.LogLevel(name)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	l.config.Level = name
+	l.config.level = Level(level)
+	l.applyLevels()
+	return nil
+}
+
+// newFileBackend logs to path, creating its directory as needed. When the
+// file can't be opened it logs to stderr instead, with a warning.
+func newFileBackend(path string, colored bool) logging.Backend {
+	var file io.Writer
+	f, err := openLogFile(path)
+	if err != nil {
+		log.Printf("Unable to open log file, logging to stderr instead: %v", err)
+		file = os.Stderr
+	} else {
+		file = f
+	}
+	return newWriterBackend(file, colored)
+}
+
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
 	}
-	backendFile := logging.NewLogBackend(file, "", 0)
-	backendFileFormatter := logging.NewBackendFormatter(backendFile, format)
-	backendFile.Color = l.config.Colored
-	backendFileLeveled := logging.AddModuleLevel(backendFileFormatter)
-	return backendFileLeveled
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 }
 
-func (l *Logger) getScreenBackend(format logging.Formatter) logging.LeveledBackend {
-	backendScreen := logging.NewLogBackend(os.Stdout, "", 0)
-	backendScreen.Color = l.config.Colored
-	backendScreenFormatter := logging.NewBackendFormatter(backendScreen, format)
-	backendScreenLeveled := logging.AddModuleLevel(backendScreenFormatter)
-	return backendScreenLeveled
+// sink hands the records written to b over to the queue of an Async logger
+func (l *Logger) sink(b logging.Backend) logging.Backend {
+	if l.async == nil {
+		return b
+	}
+	return &asyncBackend{queue: l.async, backend: b}
 }
 
+// Flush writes the pending repeat counts and waits until the queued records
+// of an Async logger are written and the hooks got theirs. It does nothing on
+// a nil logger.
+func (l *Logger) Flush() {
+	if l == nil {
+		return
+	}
+	if l.filter != nil {
+		l.filter.flush()
+	}
+	if l.async != nil {
+		l.async.flush()
+	}
+	l.hooks.flush()
+}
+
+func newWriterBackend(w io.Writer, colored bool) logging.Backend {
+	b := logging.NewLogBackend(w, "", 0)
+	b.Color = colored
+	return b
+}
+
+// GetModuleLogger returns the logger of module. Module loggers are created
+// once and safe for concurrent use. The fields of a logger returned by With
+// carry over to its module loggers.
 func (l *Logger) GetModuleLogger(module string) *Logger {
-	l.Logger = logging.MustGetLogger(module)
+	if len(l.fields) > 0 {
+		plain := *l
+		plain.fields = nil
+		return plain.GetModuleLogger(module).With(l.fields)
+	}
+	l.modules.RLock()
+	m, ok := l.modules.m[module]
+	l.modules.RUnlock()
+	if ok {
+		return m
+	}
+	m = &Logger{
+		config:  l.config,
+		backend: l.backend,
+		Logger:  logging.MustGetLogger(module),
+		modules: l.modules,
+		async:   l.async,
+		filter:  l.filter,
+		sinks:   l.sinks,
+		hooks:   l.hooks,
+	}
+	// the level methods below add a frame between the caller and the record
+	m.Logger.ExtraCalldepth = 1
+	l.modules.Lock()
+	defer l.modules.Unlock()
+	if existing, ok := l.modules.m[module]; ok {
+		return existing
+	}
+	l.modules.m[module] = m
+	return m
+}
 
-	return l
+// Enabled reports whether messages at level are logged, to skip preparing
+// costly ones
+func (l *Logger) Enabled(level Level) bool {
+	return l.Logger.IsEnabledFor(logging.Level(level))
+}
+
+// String calls f, see Lazy
+func (f Lazy) String() string {
+	return fmt.Sprint(f())
+}
+
+// The level methods check the level first and copy the arguments only for
+// enabled levels, so the argument slice of a disabled message stays on the
+// caller's stack. Formatting already waits for a backend to need the message.
+
+// Critical logs a message at CRITICAL
+func (l *Logger) Critical(args ...interface{}) {
+	if l.Enabled(CRITICAL) {
+		l.Logger.Critical(copyArgs(args)...)
+	}
+}
+
+// Criticalf logs a formatted message at CRITICAL
+func (l *Logger) Criticalf(format string, args ...interface{}) {
+	if l.Enabled(CRITICAL) {
+		l.Logger.Criticalf(format, copyArgs(args)...)
+	}
+}
+
+// Error logs a message at ERROR
+func (l *Logger) Error(args ...interface{}) {
+	if l.Enabled(ERROR) {
+		l.Logger.Error(copyArgs(args)...)
+	}
+}
+
+// Errorf logs a formatted message at ERROR
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	if l.Enabled(ERROR) {
+		l.Logger.Errorf(format, copyArgs(args)...)
+	}
+}
+
+// Warning logs a message at WARNING
+func (l *Logger) Warning(args ...interface{}) {
+	if l.Enabled(WARNING) {
+		l.Logger.Warning(copyArgs(args)...)
+	}
+}
+
+// Warningf logs a formatted message at WARNING
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	if l.Enabled(WARNING) {
+		l.Logger.Warningf(format, copyArgs(args)...)
+	}
+}
+
+// Notice logs a message at NOTICE
+func (l *Logger) Notice(args ...interface{}) {
+	if l.Enabled(NOTICE) {
+		l.Logger.Notice(copyArgs(args)...)
+	}
+}
+
+// Noticef logs a formatted message at NOTICE
+func (l *Logger) Noticef(format string, args ...interface{}) {
+	if l.Enabled(NOTICE) {
+		l.Logger.Noticef(format, copyArgs(args)...)
+	}
+}
+
+// Info logs a message at INFO
+func (l *Logger) Info(args ...interface{}) {
+	if l.Enabled(INFO) {
+		l.Logger.Info(copyArgs(args)...)
+	}
+}
+
+// Infof logs a formatted message at INFO
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l.Enabled(INFO) {
+		l.Logger.Infof(format, copyArgs(args)...)
+	}
+}
+
+// Debug logs a message at DEBUG
+func (l *Logger) Debug(args ...interface{}) {
+	if l.Enabled(DEBUG) {
+		l.Logger.Debug(copyArgs(args)...)
+	}
+}
+
+// Debugf logs a formatted message at DEBUG
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l.Enabled(DEBUG) {
+		l.Logger.Debugf(format, copyArgs(args)...)
+	}
+}
+
+// Fatal logs a message at CRITICAL, flushes then exits
+func (l *Logger) Fatal(args ...interface{}) {
+	l.Logger.Critical(args...)
+	l.Flush()
+	os.Exit(1)
+}
+
+// Fatalf logs a formatted message at CRITICAL, flushes then exits
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.Logger.Criticalf(format, args...)
+	l.Flush()
+	os.Exit(1)
+}
+
+// Panic logs a message at CRITICAL, flushes then panics
+func (l *Logger) Panic(args ...interface{}) {
+	s := fmt.Sprint(args...)
+	l.Logger.Critical(s)
+	l.Flush()
+	panic(s)
+}
+
+// Panicf logs a formatted message at CRITICAL, flushes then panics
+func (l *Logger) Panicf(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	l.Logger.Critical(s)
+	l.Flush()
+	panic(s)
+}
+
+func copyArgs(args []interface{}) []interface{} {
+	return append([]interface{}(nil), args...)
 }
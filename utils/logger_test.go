@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+func benchmarkLogger(b *testing.B, level string) *Logger {
+	l := NewLogger(&LoggerConfig{Level: level, Backend: "file", File: os.DevNull, Modules: []string{"bench"}})
+	b.Cleanup(l.Flush)
+	return l.GetModuleLogger("bench")
+}
+
+func BenchmarkDisabledDebugf(b *testing.B) {
+	l := benchmarkLogger(b, "INFO")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Debugf("GET %s took %d ms", "/users", i)
+	}
+}
+
+func BenchmarkWithFields(b *testing.B) {
+	for _, level := range []string{"INFO", "DEBUG"} {
+		b.Run(level, func(b *testing.B) {
+			l := benchmarkLogger(b, level).With(Fields{"request_id": "abc", "user_id": 42})
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				l.Debugf("GET %s took %d ms", "/users", i)
+			}
+		})
+	}
+}
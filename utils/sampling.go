@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+// repeatInterval is how long a repeated message may stay collapsed before
+// the count is written anyway
+const repeatInterval = 30 * time.Second
+
+type (
+	// filterBackend samples and collapses records before they reach the
+	// backends
+	filterBackend struct {
+		backend logging.Backend
+
+		// samples counts the records of the sampled modules, the map itself
+		// is never written once built
+		samples map[string]*samplingCounter
+
+		collapse bool
+		lock     sync.Mutex
+		repeats  map[string]*repeat
+	}
+
+	samplingCounter struct {
+		every uint64
+		n     uint64
+	}
+
+	// repeat is the last message of a module and how many times it was
+	// logged again since it was written
+	repeat struct {
+		level   logging.Level
+		message string
+		count   int
+		since   time.Time
+	}
+)
+
+// newFilterBackend returns nil when neither Sampling nor CollapseRepeats is
+// configured
+func (l *Logger) newFilterBackend(b logging.Backend) *filterBackend {
+	f := &filterBackend{
+		backend:  b,
+		samples:  map[string]*samplingCounter{},
+		collapse: l.config.CollapseRepeats,
+		repeats:  map[string]*repeat{},
+	}
+	for module, every := range l.config.Sampling {
+		if every > 1 {
+			f.samples[module] = &samplingCounter{every: uint64(every)}
+		}
+	}
+	if len(f.samples) == 0 && !f.collapse {
+		return nil
+	}
+	return f
+}
+
+func (f *filterBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	if !f.sampled(level, rec.Module) {
+		return nil
+	}
+	if !f.collapse {
+		return f.backend.Log(level, calldepth+1, rec)
+	}
+
+	message := rec.Message()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	r := f.repeats[rec.Module]
+	if r != nil && r.level == level && r.message == message {
+		r.count++
+		if time.Since(r.since) < repeatInterval {
+			return nil
+		}
+		f.logRepeat(calldepth+1, rec.Module, r)
+		r.since = time.Now()
+		return nil
+	}
+	if r != nil {
+		f.logRepeat(calldepth+1, rec.Module, r)
+	}
+	f.repeats[rec.Module] = &repeat{level: level, message: message, since: time.Now()}
+	return f.backend.Log(level, calldepth+1, rec)
+}
+
+// sampled reports whether a record of module at level is kept. Errors and
+// criticals always are.
+func (f *filterBackend) sampled(level logging.Level, module string) bool {
+	c, ok := f.samples[module]
+	if !ok || level <= logging.ERROR {
+		return true
+	}
+	return (atomic.AddUint64(&c.n, 1)-1)%c.every == 0
+}
+
+// logRepeat writes how many times r was repeated, if at all, and resets the
+// count
+func (f *filterBackend) logRepeat(calldepth int, module string, r *repeat) {
+	if r.count == 0 {
+		return
+	}
+	f.backend.Log(r.level, calldepth+1, &logging.Record{
+		Time:   time.Now(),
+		Module: module,
+		Level:  r.level,
+		Args:   []interface{}{fmt.Sprintf("last message repeated %d times", r.count)},
+	})
+	r.count = 0
+}
+
+// flush writes the pending repeat counts
+func (f *filterBackend) flush() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for module, r := range f.repeats {
+		f.logRepeat(1, module, r)
+	}
+}
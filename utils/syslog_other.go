@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package utils
+
+import (
+	"errors"
+
+	logging "github.com/op/go-logging"
+)
+
+func (l *Logger) newSyslogBackend() (logging.Backend, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+
+package utils
+
+import (
+	"errors"
+	"log/syslog"
+	"strings"
+
+	logging "github.com/op/go-logging"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// newSyslogBackend connects to the local syslog daemon, or to the remote one
+// at SyslogAddress over SyslogNetwork
+func (l *Logger) newSyslogBackend() (logging.Backend, error) {
+	facility := syslog.LOG_USER
+	if name := l.config.SyslogFacility; name != "" {
+		f, ok := syslogFacilities[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.New("unknown syslog facility " + name)
+		}
+		facility = f
+	}
+
+	w, err := syslog.Dial(l.config.SyslogNetwork, l.config.SyslogAddress, facility|syslog.LOG_INFO, l.config.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &logging.SyslogBackend{Writer: w}, nil
+}
@@ -0,0 +1,151 @@
+package chef
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type (
+	// FieldError describes a field failing one of its validation rules
+	FieldError struct {
+		Field string `json:"field"`
+		Rule  string `json:"rule"`
+	}
+
+	// ValidationErrors lists every field failing validation
+	ValidationErrors []FieldError
+)
+
+// Error implements the error interface
+func (e ValidationErrors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, f := range e {
+		parts = append(parts, f.Field+" failed "+f.Rule)
+	}
+	return "chef: validation failed: " + strings.Join(parts, ", ")
+}
+
+// Validate checks the struct pointed to by v against the rules in its
+// `validate` tags: required, min=N, max=N and oneof=a b c. min and max bound
+// numbers by value and strings and slices by length. tag names the tag used
+// to report field names, falling back to the Go field name. It panics on
+// unknown or malformed rules, which are programming errors.
+func Validate(v interface{}, tag string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return ErrInvalidBindTarget
+	}
+	rt := rv.Type()
+
+	var errs ValidationErrors
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		rules := field.Tag.Get("validate")
+		if rules == "" || field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		if t := strings.Split(field.Tag.Get(tag), ",")[0]; t != "" && t != "-" {
+			name = t
+		}
+
+		f := rv.Field(i)
+		for _, rule := range strings.Split(rules, ",") {
+			if rule = strings.TrimSpace(rule); rule == "" {
+				continue
+			}
+			ok, known := checkRule(f, rule)
+			if !known {
+				panic("chef: invalid validation rule " + strconv.Quote(rule) + " on " + rt.Name() + "." + field.Name)
+			}
+			if !ok {
+				errs = append(errs, FieldError{Field: name, Rule: rule})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkRule reports whether f satisfies rule, and whether the rule is a
+// known one with a valid argument
+func checkRule(f reflect.Value, rule string) (ok, known bool) {
+	name, arg := rule, ""
+	if i := strings.IndexByte(rule, '='); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+	var bound float64
+	switch name {
+	case "required", "oneof":
+	case "min", "max":
+		var err error
+		if bound, err = strconv.ParseFloat(arg, 64); err != nil {
+			return false, false
+		}
+	default:
+		return false, false
+	}
+
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return name != "required", true
+		}
+		f = f.Elem()
+	}
+
+	switch name {
+	case "required":
+		return !f.IsZero(), true
+	case "min", "max":
+		n, ok := measure(f)
+		if !ok {
+			return false, true
+		}
+		if name == "min" {
+			return n >= bound, true
+		}
+		return n <= bound, true
+	}
+	s := valueString(f)
+	for _, option := range strings.Fields(arg) {
+		if s == option {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// measure returns the value of numbers and the length of strings, slices and maps
+func measure(f reflect.Value) (float64, bool) {
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(f.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(f.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return f.Float(), true
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(f.Len()), true
+	}
+	return 0, false
+}
+
+func valueString(f reflect.Value) string {
+	switch f.Kind() {
+	case reflect.String:
+		return f.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(f.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), 10)
+	}
+	return ""
+}
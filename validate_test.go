@@ -0,0 +1,85 @@
+package chef
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type signup struct {
+		Name  string   `json:"name" validate:"required, min=2, max=5"`
+		Age   *int     `json:"age" validate:"min=18"`
+		Plan  string   `json:"plan" validate:"oneof=free pro"`
+		Tags  []string `json:"tags" validate:"max=2"`
+		Email string   `validate:"required"`
+	}
+	age := func(n int) *int { return &n }
+
+	tests := []struct {
+		name string
+		in   signup
+		want ValidationErrors
+	}{
+		{
+			name: "valid",
+			in:   signup{Name: "ann", Age: age(30), Plan: "pro", Tags: []string{"a"}, Email: "a@b.c"},
+		},
+		{
+			name: "nil pointer skips bounds",
+			in:   signup{Name: "ann", Plan: "free", Email: "a@b.c"},
+		},
+		{
+			name: "every failing rule",
+			in:   signup{Age: age(12), Plan: "gold", Tags: []string{"a", "b", "c"}},
+			want: ValidationErrors{
+				{Field: "name", Rule: "required"},
+				{Field: "name", Rule: "min=2"},
+				{Field: "age", Rule: "min=18"},
+				{Field: "plan", Rule: "oneof=free pro"},
+				{Field: "tags", Rule: "max=2"},
+				{Field: "Email", Rule: "required"},
+			},
+		},
+		{
+			name: "string length",
+			in:   signup{Name: "annabel", Plan: "free", Email: "a@b.c"},
+			want: ValidationErrors{{Field: "name", Rule: "max=5"}},
+		},
+	}
+	for _, tt := range tests {
+		err := Validate(&tt.in, "json")
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if got, _ := err.(ValidationErrors); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateInvalidRule(t *testing.T) {
+	tests := []interface{}{
+		&struct {
+			Name string `validate:"requried"`
+		}{},
+		&struct {
+			Name string `validate:"mn=3"`
+		}{},
+		&struct {
+			Name string `validate:"min=three"`
+		}{},
+	}
+	for _, v := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T: expected a panic", v)
+				}
+			}()
+			Validate(v, "json")
+		}()
+	}
+}
@@ -0,0 +1,151 @@
+package chef
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// watchedFile is a file watched in development
+	watchedFile struct {
+		kind string
+		mod  time.Time
+	}
+)
+
+// watch polls the config, templates, static assets and, when Dev.Build is
+// set, Go sources until done is closed. Config and templates are reloaded in
+// place, code changes rebuild and restart the process. Static assets are read
+// on every request so their changes are only logged.
+func (c *Chef) watch(done <-chan struct{}) {
+	interval := time.Duration(c.Config().Dev.WatchInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger := c.logger.GetModuleLogger("chef")
+	seen := c.watchedFiles()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		current := c.watchedFiles()
+		changed := map[string]bool{}
+		for path, f := range current {
+			if !seen[path].mod.Equal(f.mod) {
+				changed[f.kind] = true
+			}
+		}
+		for path, f := range seen {
+			if _, ok := current[path]; !ok {
+				changed[f.kind] = true
+			}
+		}
+		seen = current
+
+		if changed["go"] && c.Config().Dev.Build != "" {
+			logger.Notice("Code changed, rebuilding")
+			if err := c.rebuild(); err != nil {
+				logger.Errorf("Build failed: %s", err)
+			} else if c.handOver() {
+				return
+			}
+		}
+		if changed["config"] {
+			if err := c.ReloadConfig(); err != nil {
+				logger.Errorf("Config reload failed: %s", err)
+			} else {
+				logger.Notice("Config reloaded")
+			}
+		}
+		if changed["template"] {
+			c.lifecycle.lock.Lock()
+			templates := c.lifecycle.templates
+			c.lifecycle.lock.Unlock()
+			for _, t := range templates {
+				if err := t.Reload(); err != nil {
+					logger.Errorf("Template reload failed: %s", err)
+				}
+			}
+			logger.Notice("Templates reloaded")
+		}
+		if changed["static"] {
+			logger.Notice("Static assets changed")
+		}
+	}
+}
+
+// watchedFiles returns the watched files by path
+func (c *Chef) watchedFiles() map[string]watchedFile {
+	files := map[string]watchedFile{}
+	stat := func(path, kind string) {
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			files[path] = watchedFile{kind: kind, mod: fi.ModTime()}
+		}
+	}
+	walk := func(dir, kind string, keep func(path string) bool) {
+		if dir == "" {
+			return
+		}
+		filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if fi.IsDir() {
+				if path != dir && (strings.HasPrefix(fi.Name(), ".") || fi.Name() == "vendor") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if keep(path) {
+				files[path] = watchedFile{kind: kind, mod: fi.ModTime()}
+			}
+			return nil
+		})
+	}
+
+	if c.configFile != "" {
+		stat(c.configFile, "config")
+		for _, overlay := range configOverlays(c.configFile, c.Config().App.Env) {
+			stat(overlay, "config")
+		}
+	}
+
+	c.lifecycle.lock.Lock()
+	templates := c.lifecycle.templates
+	c.lifecycle.lock.Unlock()
+	for _, t := range templates {
+		matches, _ := filepath.Glob(t.files())
+		for _, m := range matches {
+			stat(m, "template")
+		}
+	}
+
+	all := func(string) bool { return true }
+	walk(c.Config().App.Static, "static", all)
+	if c.Config().Fileserver.Use {
+		walk(c.Config().Fileserver.Dir, "static", all)
+	}
+	if c.Config().Dev.Build != "" {
+		walk(".", "go", func(path string) bool {
+			return strings.HasSuffix(path, ".go") && !strings.HasSuffix(path, "_test.go")
+		})
+	}
+	return files
+}
+
+// rebuild runs the Dev.Build command
+func (c *Chef) rebuild() error {
+	args := strings.Fields(c.Config().Dev.Build)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,29 @@
+package chef
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WebSocketServiceRestart is the close code telling clients the server is
+// restarting and they should reconnect
+const WebSocketServiceRestart = 1012
+
+// WebSocketGoaway writes a close frame with WebSocketServiceRestart and a
+// "retry=<milliseconds>" reason to conn, the hijacked connection of a
+// WebSocket, for handlers to send once Context.Closing is signalled. The
+// connection should be closed after the peer answers or a short timeout.
+func WebSocketGoaway(conn io.Writer, retry time.Duration) error {
+	reason := "retry=" + strconv.FormatInt(retry.Milliseconds(), 10)
+	frame := make([]byte, 4, 4+len(reason))
+	// FIN and close opcode, then the unmasked payload length: servers never
+	// mask their frames and the reason is well under the 125 bytes limit
+	frame[0] = 0x88
+	frame[1] = byte(2 + len(reason))
+	binary.BigEndian.PutUint16(frame[2:], WebSocketServiceRestart)
+	frame = append(frame, reason...)
+	_, err := conn.Write(frame)
+	return err
+}
@@ -0,0 +1,172 @@
+package chef
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type (
+	// WizardStep is one page of a multi-step form
+	WizardStep struct {
+		Name     string
+		Validate func(ctx Context, data Data) error
+	}
+
+	// Wizard keeps the progress of a multi-step form in the session until it
+	// is submitted as a whole. Use it together with SessionLock so parallel
+	// submissions from the same user are serialized.
+	Wizard struct {
+		name   string
+		steps  []WizardStep
+		submit func(ctx Context, data Data) error
+	}
+
+	wizardState struct {
+		Step int
+		Data []Data
+	}
+)
+
+var (
+	// ErrNoSession is returned when a feature needs a session and none is configured
+	ErrNoSession = errors.New("chef: session is not enabled")
+
+	// ErrWizardIncomplete is returned when submitting a wizard before its last step
+	ErrWizardIncomplete = errors.New("chef: wizard has incomplete steps")
+)
+
+// NewWizard returns a wizard going through steps in order and handing the
+// merged data of all steps to submit at the end
+func NewWizard(name string, submit func(ctx Context, data Data) error, steps ...WizardStep) *Wizard {
+	if len(steps) == 0 {
+		panic("chef: wizard needs at least one step")
+	}
+	return &Wizard{
+		name:   name,
+		steps:  steps,
+		submit: submit,
+	}
+}
+
+// Current returns the step the user is on and its index
+func (w *Wizard) Current(ctx Context) (WizardStep, int) {
+	st := w.load(ctx)
+	return w.steps[st.Step], st.Step
+}
+
+// Data returns the data entered so far, merged across steps
+func (w *Wizard) Data(ctx Context) Data {
+	return merge(w.load(ctx).Data)
+}
+
+// Save validates data for the current step, stores it and moves forward.
+// Saving the last step keeps the user on it until Submit.
+func (w *Wizard) Save(ctx Context, data Data) error {
+	st := w.load(ctx)
+	step := w.steps[st.Step]
+	if step.Validate != nil {
+		if err := step.Validate(ctx, data); err != nil {
+			return err
+		}
+	}
+
+	st.Data[st.Step] = data
+	if st.Step < len(w.steps)-1 {
+		st.Step++
+	}
+	return w.store(ctx, st)
+}
+
+// Back moves to the previous step, keeping what was entered
+func (w *Wizard) Back(ctx Context) error {
+	st := w.load(ctx)
+	if st.Step > 0 {
+		st.Step--
+	}
+	return w.store(ctx, st)
+}
+
+// GoTo moves to an earlier step, or to a later one if every step before it
+// has been completed
+func (w *Wizard) GoTo(ctx Context, index int) error {
+	st := w.load(ctx)
+	if index < 0 || index >= len(w.steps) {
+		return errors.New("chef: wizard step out of range")
+	}
+	for i := 0; i < index; i++ {
+		if st.Data[i] == nil {
+			return ErrWizardIncomplete
+		}
+	}
+	st.Step = index
+	return w.store(ctx, st)
+}
+
+// Submit validates every step again and hands the merged data to the submit
+// function. The progress is only cleared once it succeeded, so a failed
+// submission can be retried.
+func (w *Wizard) Submit(ctx Context) error {
+	st := w.load(ctx)
+	for i, step := range w.steps {
+		if st.Data[i] == nil {
+			return ErrWizardIncomplete
+		}
+		if step.Validate != nil {
+			if err := step.Validate(ctx, st.Data[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.submit(ctx, merge(st.Data)); err != nil {
+		return err
+	}
+	return w.Reset(ctx)
+}
+
+// Reset drops the progress of the wizard
+func (w *Wizard) Reset(ctx Context) error {
+	return ctx.SessionDelete(w.key())
+}
+
+func (w *Wizard) key() string {
+	return "wizard." + w.name
+}
+
+func (w *Wizard) load(ctx Context) *wizardState {
+	st := &wizardState{}
+	if s := ctx.Session(); s != nil {
+		if raw, ok := s.Get(w.key()).(string); ok {
+			json.Unmarshal([]byte(raw), st)
+		}
+	}
+
+	// the wizard may have changed since the state was saved
+	if len(st.Data) != len(w.steps) {
+		data := make([]Data, len(w.steps))
+		copy(data, st.Data)
+		st.Data = data
+	}
+	if st.Step < 0 || st.Step >= len(w.steps) {
+		st.Step = 0
+	}
+	return st
+}
+
+func (w *Wizard) store(ctx Context, st *wizardState) error {
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return ctx.SessionSet(w.key(), string(raw))
+}
+
+func merge(steps []Data) Data {
+	out := Data{}
+	for _, d := range steps {
+		for k, v := range d {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+package chef
+
+import (
+	stdctx "context"
+	"errors"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gochef/chef/utils"
+)
+
+type (
+	// Job is a unit of background work. ctx is cancelled when the drain on
+	// shutdown times out. Jobs must not keep the request Context, which is
+	// reused once the handler returns; copy the values they need instead.
+	Job func(ctx stdctx.Context) error
+
+	// Workers runs enqueued jobs on a fixed pool of goroutines
+	Workers struct {
+		size    int
+		retries int
+		delay   time.Duration
+		logger  *utils.Logger
+
+		lock    sync.Mutex
+		jobs    chan Job
+		stopped bool
+		start   sync.Once
+		wg      sync.WaitGroup
+		ctx     stdctx.Context
+		cancel  stdctx.CancelFunc
+	}
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when the job queue is full
+	ErrQueueFull = errors.New("chef: job queue is full")
+
+	// ErrWorkersStopped is returned by Enqueue once shutdown has begun
+	ErrWorkersStopped = errors.New("chef: workers are stopped")
+)
+
+// newWorkers creates the worker pool from the [Workers] config section
+func newWorkers(c *Chef) *Workers {
+	cfg := c.Config().Workers
+	w := &Workers{
+		size:    cfg.Size,
+		retries: cfg.Retries,
+		delay:   cfg.RetryDelay.Or(time.Second),
+		logger:  c.logger,
+	}
+	if w.size <= 0 {
+		w.size = 4
+	}
+	queue := cfg.Queue
+	if queue <= 0 {
+		queue = 100
+	}
+	w.jobs = make(chan Job, queue)
+	w.ctx, w.cancel = stdctx.WithCancel(stdctx.Background())
+	return w
+}
+
+// Enqueue schedules job on the worker pool, see Workers.Enqueue
+func (c *Chef) Enqueue(job Job) error {
+	return c.workers.Enqueue(job)
+}
+
+// Enqueue schedules job without blocking. The workers start with the first
+// job. A failing job is retried up to Workers.Retries times with an
+// exponential backoff; a panicking one is logged and dropped.
+func (w *Workers) Enqueue(job Job) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped {
+		return ErrWorkersStopped
+	}
+	w.start.Do(func() {
+		w.wg.Add(w.size)
+		for i := 0; i < w.size; i++ {
+			go w.work()
+		}
+	})
+
+	select {
+	case w.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// stop refuses new jobs and waits for the queued ones until ctx expires, then
+// cancels the context of the running ones
+func (w *Workers) stop(ctx stdctx.Context) error {
+	w.lock.Lock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.jobs)
+	}
+	w.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		return ctx.Err()
+	}
+}
+
+func (w *Workers) work() {
+	defer w.wg.Done()
+	for job := range w.jobs {
+		w.run(job)
+	}
+}
+
+// run calls job until it succeeds or runs out of retries
+func (w *Workers) run(job Job) {
+	logger := w.logger.GetModuleLogger("chef")
+	delay := w.delay
+	for attempt := 0; ; attempt++ {
+		err := w.call(job)
+		if err == nil {
+			return
+		}
+		if p, panicked := err.(*panicError); panicked {
+			logger.Errorf("Job failed: %s\n%s", p, p.stack)
+			return
+		}
+		if attempt >= w.retries {
+			logger.Errorf("Job failed: %s", err)
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-w.ctx.Done():
+			logger.Errorf("Job abandoned: %s", err)
+			return
+		}
+	}
+}
+
+// call runs job, turning a panic into an error
+func (w *Workers) call(job Job) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = &panicError{value: rec, stack: debug.Stack()}
+		}
+	}()
+	return job(w.ctx)
+}